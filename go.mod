@@ -1,9 +1,14 @@
 module github.com/empijei/go-safeweb-example-app
 
-go 1.16
+go 1.18
 
 require (
 	github.com/google/go-safeweb v0.0.0-20210512121813-2f2da980e2ef
 	github.com/google/safehtml v0.0.2
+	github.com/yuin/goldmark v1.3.5
 	golang.org/x/crypto v0.0.0-20210322153248-0c34fe9e7dc2
+	golang.org/x/net v0.0.0-20210410081132-afb366fc7cd1
+	gopkg.in/yaml.v3 v3.0.1
 )
+
+require golang.org/x/text v0.3.6 // indirect
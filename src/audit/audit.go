@@ -0,0 +1,57 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package audit records the security relevant actions of users, like exports
+// of their data, in a log kept apart from the one used for debugging.
+package audit
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// Event is an entry of the audit log, written as a line of JSON.
+type Event struct {
+	Time   time.Time `json:"time"`
+	User   string    `json:"user"`
+	Action string    `json:"action"`
+	// Details depend on the action.
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+// Logger writes events to an io.Writer. It is safe for concurrent use.
+type Logger struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// New returns a Logger that writes to w.
+func New(w io.Writer) *Logger {
+	return &Logger{enc: json.NewEncoder(w)}
+}
+
+// Log records that user performed action.
+func (l *Logger) Log(user, action string, details map[string]interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e := Event{Time: time.Now().UTC(), User: user, Action: action, Details: details}
+	if err := l.enc.Encode(e); err != nil {
+		// The action already happened, losing track of it is all that can
+		// be done.
+		log.Printf("Writing audit event %q of %q: %v", action, user, err)
+	}
+}
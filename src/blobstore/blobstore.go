@@ -0,0 +1,207 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package blobstore stores the content of files, e.g. attachments, by key.
+//
+// Blobs are opaque: the metadata describing them, and who can access them, is
+// kept by the storage.
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ErrNotFound is returned for keys that have no blob.
+var ErrNotFound = errors.New("blob not found")
+
+// Store stores blobs. Keys must be valid, see ValidKey.
+type Store interface {
+	// Put stores the content of r under key, replacing any previous blob.
+	// Callers are responsible for limiting the size of r.
+	Put(ctx context.Context, key string, r io.Reader) error
+	// Get returns the content of the blob under key, which must be closed.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete deletes the blob under key. Deleting a missing blob is not an
+	// error.
+	Delete(ctx context.Context, key string) error
+	// List returns the keys of the blobs that start with prefix, in no
+	// particular order.
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+// ValidKey reports whether key can be used in a Store: it must only contain
+// ASCII letters, digits, '-' and '_', as the random IDs of the storage do, so
+// it is safe to use as a file name.
+func ValidKey(key string) bool {
+	if key == "" || len(key) > 128 {
+		return false
+	}
+	for _, c := range key {
+		ok := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_'
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func checkKey(key string) error {
+	if !ValidKey(key) {
+		return fmt.Errorf("invalid blob key %q", key)
+	}
+	return nil
+}
+
+// Memory is an in-memory Store, which loses all blobs when the program
+// terminates.
+type Memory struct {
+	mu    sync.Mutex
+	blobs map[string][]byte
+}
+
+// NewMemory returns an empty in-memory Store.
+func NewMemory() *Memory {
+	return &Memory{blobs: map[string][]byte{}}
+}
+
+func (m *Memory) Put(ctx context.Context, key string, r io.Reader) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.blobs[key] = b
+	return nil
+}
+
+func (m *Memory) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.blobs[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	// Blobs are never modified in place, only replaced.
+	return ioutil.NopCloser(bytes.NewReader(b)), nil
+}
+
+func (m *Memory) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.blobs, key)
+	return nil
+}
+
+func (m *Memory) List(ctx context.Context, prefix string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var keys []string
+	for k := range m.blobs {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+// FS is a Store that keeps each blob in a file of a directory.
+type FS struct {
+	dir string
+}
+
+// NewFS returns a Store that keeps blobs in dir, creating it if needed.
+func NewFS(dir string) (*FS, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &FS{dir: dir}, nil
+}
+
+// Put writes the blob to a temporary file first, so that readers never see
+// a partial blob.
+func (f *FS) Put(ctx context.Context, key string, r io.Reader) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(f.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(f.dir, key))
+}
+
+func (f *FS) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+	file, err := os.Open(filepath.Join(f.dir, key))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return nil, ErrNotFound
+	case err != nil:
+		return nil, err
+	}
+	return file, nil
+}
+
+func (f *FS) Delete(ctx context.Context, key string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	err := os.Remove(filepath.Join(f.dir, key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// List skips the temporary files of Put, which are not valid keys.
+func (f *FS) List(ctx context.Context, prefix string) ([]string, error) {
+	entries, err := ioutil.ReadDir(f.dir)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, e := range entries {
+		if k := e.Name(); e.Mode().IsRegular() && ValidKey(k) && strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,124 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listen creates a listener from its textual description, which is one of:
+//
+//   - "host:port" for a TCP listener;
+//   - "unix:PATH" for a Unix-domain socket listener;
+//   - "systemd" or "systemd:NAME" for a socket passed by systemd socket
+//     activation. See http://0pointer.de/blog/projects/socket-activation.html.
+//     Without a NAME the first socket is used, otherwise the one whose
+//     FileDescriptorName= matches.
+func listen(spec string) (net.Listener, error) {
+	switch {
+	case strings.HasPrefix(spec, "unix:"):
+		return listenUnix(strings.TrimPrefix(spec, "unix:"))
+	case spec == "systemd":
+		return listenSystemd("")
+	case strings.HasPrefix(spec, "systemd:"):
+		return listenSystemd(strings.TrimPrefix(spec, "systemd:"))
+	default:
+		return net.Listen("tcp", spec)
+	}
+}
+
+func listenUnix(path string) (net.Listener, error) {
+	// A socket left behind by a previous run that did not shut down cleanly
+	// would make Listen fail. Only remove sockets, never regular files.
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	return net.Listen("unix", path)
+}
+
+// The first file descriptor passed by systemd, see sd_listen_fds(3).
+const sdListenFDsStart = 3
+
+// systemdListener is a socket passed by systemd.
+type systemdListener struct {
+	net.Listener
+	name string
+}
+
+// systemdListeners are collected on first use, as the environment describing
+// them is cleared right after.
+var systemdListeners []systemdListener
+
+func listenSystemd(name string) (net.Listener, error) {
+	if systemdListeners == nil {
+		ls, err := systemdSockets()
+		if err != nil {
+			return nil, err
+		}
+		systemdListeners = ls
+	}
+	for _, l := range systemdListeners {
+		if name == "" || l.name == name {
+			return l.Listener, nil
+		}
+	}
+	if name == "" {
+		return nil, fmt.Errorf("no sockets passed by systemd")
+	}
+	return nil, fmt.Errorf("no socket named %q passed by systemd", name)
+}
+
+// systemdSockets returns the sockets passed by systemd, in order.
+func systemdSockets() ([]systemdListener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, fmt.Errorf("no sockets passed by systemd to this process")
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid LISTEN_FDS: %v", err)
+	}
+	var names []string
+	if fdNames := os.Getenv("LISTEN_FDNAMES"); fdNames != "" {
+		names = strings.Split(fdNames, ":")
+	}
+	// Do not pass the sockets on to child processes.
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	var ls []systemdListener
+	for i := 0; i < n; i++ {
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(sdListenFDsStart+i), name)
+		l, err := net.FileListener(f)
+		// FileListener dups the descriptor, the original is no longer needed.
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("socket %d passed by systemd: %v", i, err)
+		}
+		ls = append(ls, systemdListener{Listener: l, name: name})
+	}
+	return ls, nil
+}
@@ -15,37 +15,242 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"flag"
+	"io"
 	"log"
-	"net"
 	"net/http"
-	"strconv"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/google/go-safeweb/safehttp"
 
+	"github.com/empijei/go-safeweb-example-app/src/audit"
+	"github.com/empijei/go-safeweb-example-app/src/blobstore"
+	"github.com/empijei/go-safeweb-example-app/src/config"
+	"github.com/empijei/go-safeweb-example-app/src/health"
+	"github.com/empijei/go-safeweb-example-app/src/scheduler"
 	"github.com/empijei/go-safeweb-example-app/src/secure"
+	"github.com/empijei/go-safeweb-example-app/src/secure/envelope"
+	"github.com/empijei/go-safeweb-example-app/src/secure/reports"
+	"github.com/empijei/go-safeweb-example-app/src/secure/sharelink"
 	"github.com/empijei/go-safeweb-example-app/src/server"
 	"github.com/empijei/go-safeweb-example-app/src/storage"
 )
 
 var (
-	port = flag.Int("port", 8080, "Port for the HTTP server")
-	dev  = flag.Bool("dev", false, "Run in dev mode")
+	configFile = flag.String("config", "", "Path to the YAML configuration file. Settings can be overridden with NOTEKEEPER_* environment variables")
+	dev        = flag.Bool("dev", false, "Run in dev mode, overrides the configuration")
 )
 
+// CSP reports are only used to spot problems, there is no point in accepting
+// more than a few of them per second.
+const (
+	cspReportsRate  = 5
+	cspReportsBurst = 50
+)
+
+// rewrapInterval is how often the data keys are wrapped by the current master
+// key, after a rotation.
+const rewrapInterval = time.Hour
+
 func main() {
 	log.SetFlags(log.Flags() | log.Lshortfile)
 	flag.Parse()
-	safehttp.UseLocalDev() // TODO(clap): remove this
+	conf, err := config.Load(*configFile)
+	if err != nil {
+		log.Fatalf("Loading configuration: %v", err)
+	}
 	if *dev {
+		conf.Dev = true
+	}
+	if err := conf.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	for _, w := range conf.Warnings() {
+		log.Printf("WARNING: %s", w)
+	}
+	if conf.Dev {
+		log.Print("********************************************************")
+		log.Print("* RUNNING IN DEV MODE, DO NOT EXPOSE THIS TO THE WORLD *")
+		log.Print("********************************************************")
 		safehttp.UseLocalDev()
 	}
-	db := storage.NewDB()
 
-	addr := net.JoinHostPort("localhost", strconv.Itoa(*port))
-	cfg := secure.NewMuxConfig(db, addr)
-	server.Load(db, cfg)
+	keys, err := envelope.NewKeyring(conf.Secrets.MasterKeys)
+	if err != nil {
+		log.Fatalf("Loading the master keys: %v", err)
+	}
+	// Other backends would store the wrapped data keys with the data.
+	var vault *envelope.Vault
+	var db *storage.DB
+	switch conf.Storage.Backend {
+	case "memory":
+		vault = envelope.NewVault(keys, envelope.NewMemoryKeyStore())
+		db = storage.NewDB(vault)
+	}
+
+	var blobs blobstore.Store = blobstore.NewMemory()
+	if conf.Storage.BlobDir != "" {
+		if blobs, err = blobstore.NewFS(conf.Storage.BlobDir); err != nil {
+			log.Fatalf("Opening the blob store: %v", err)
+		}
+	}
+
+	var workers []io.Closer
+	auditLog := audit.New(os.Stderr)
+	if conf.Server.AuditLog != "" {
+		f, err := os.OpenFile(conf.Server.AuditLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			log.Fatalf("Opening the audit log: %v", err)
+		}
+		auditLog = audit.New(f)
+		workers = append(workers, f)
+	}
+	checks := map[string]health.Check{}
+	var tlsConfig *tls.Config
+	if conf.TLS.Enabled() {
+		certs, err := secure.NewCertReloader(conf.TLS.Cert, conf.TLS.Key, conf.TLS.ReloadInterval)
+		if err != nil {
+			log.Fatalf("Loading TLS certificate: %v", err)
+		}
+		workers = append(workers, certs)
+		checks["tls"] = certs.Check
+		var clientCAs *x509.CertPool
+		if conf.TLS.ClientCA != "" {
+			if clientCAs, err = secure.LoadCertPool(conf.TLS.ClientCA); err != nil {
+				log.Fatalf("Loading TLS client CAs: %v", err)
+			}
+		}
+		tlsConfig = secure.NewTLSConfig(certs, clientCAs)
+	}
 
-	log.Printf("Listening on %q", addr)
-	log.Fatal(http.ListenAndServe(addr, cfg.Mux()))
+	jobs := scheduler.New()
+	jobs.Every("trash purge", conf.Storage.PurgeInterval, server.PurgeTrash(db, blobs, conf.Storage.TrashRetention))
+	jobs.Every("data key rewrap", rewrapInterval, server.RewrapKeys(vault))
+	jobs.Start()
+	workers = append(workers, jobs)
+	checks["scheduler"] = jobs.Check
+
+	cspReports := reports.NewCollector(cspReportsRate, cspReportsBurst)
+	cfg := secure.NewMuxConfig(db, conf, cspReports)
+	server.Load(db, sharelink.NewSigner(conf.Secrets.ShareLinkKey), blobs, auditLog, conf.Storage.TrashRetention, cfg)
+	adminCfg := secure.NewAdminMuxConfig(db, checks, cspReports)
+
+	srv := newServer(conf.Server, cfg.Mux())
+	srv.TLSConfig = tlsConfig
+	// Event streams only end when they stop receiving events.
+	srv.RegisterOnShutdown(db.StopEvents)
+	adminSrv := newServer(conf.Server, adminCfg.Mux())
+	srvs := []*http.Server{srv, adminSrv}
+
+	l, err := listen(conf.Server.Listen)
+	if err != nil {
+		log.Fatalf("Listening on %q: %v", conf.Server.Listen, err)
+	}
+	adminL, err := listen(conf.Server.AdminListen)
+	if err != nil {
+		log.Fatalf("Listening on %q: %v", conf.Server.AdminListen, err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	errc := make(chan error, 3)
+	if conf.Server.RedirectListen != "" {
+		redirectL, err := listen(conf.Server.RedirectListen)
+		if err != nil {
+			log.Fatalf("Listening on %q: %v", conf.Server.RedirectListen, err)
+		}
+		redirectSrv := newServer(conf.Server, httpsRedirect(conf.Server.PublicHosts[0]))
+		srvs = append(srvs, redirectSrv)
+		go func() {
+			log.Printf("Redirecting to HTTPS from %q", redirectL.Addr())
+			errc <- redirectSrv.Serve(redirectL)
+		}()
+	}
+	go func() {
+		log.Printf("Admin server listening on %q", adminL.Addr())
+		errc <- adminSrv.Serve(adminL)
+	}()
+	go func() {
+		log.Printf("Listening on %q", l.Addr())
+		if tlsConfig != nil {
+			// The certificate comes from TLSConfig.GetCertificate.
+			errc <- srv.ServeTLS(l, "", "")
+			return
+		}
+		errc <- srv.Serve(l)
+	}()
+
+	// failed is set when a server stops by itself, so that the process exits
+	// with an error and gets restarted.
+	failed := false
+	select {
+	case err := <-errc:
+		log.Printf("Server failed: %v", err)
+		failed = true
+	case <-ctx.Done():
+		log.Printf("Shutting down, waiting up to %v for in-flight requests", conf.Server.ShutdownTimeout)
+	}
+	// Restore the default behavior, so that a second signal kills the process.
+	stop()
+
+	// Background workers and the storage are only closed once no handler can
+	// use them anymore.
+	if !shutdown(conf.Server.ShutdownTimeout, srvs, append(workers, db)...) || failed {
+		os.Exit(1)
+	}
+	log.Print("Shutdown complete")
+}
+
+// newServer creates an http.Server for h, with limits that prevent clients from
+// holding on to resources indefinitely.
+func newServer(conf config.Server, h http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           h,
+		ReadHeaderTimeout: conf.ReadHeaderTimeout,
+		ReadTimeout:       conf.ReadTimeout,
+		WriteTimeout:      conf.WriteTimeout,
+		IdleTimeout:       conf.IdleTimeout,
+		MaxHeaderBytes:    conf.MaxHeaderBytes,
+	}
+}
+
+// shutdown drains the servers, then closes the closers in order. It reports
+// whether everything shut down cleanly.
+func shutdown(timeout time.Duration, srvs []*http.Server, closers ...io.Closer) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	errc := make(chan error, len(srvs))
+	for _, srv := range srvs {
+		srv := srv
+		go func() {
+			err := srv.Shutdown(ctx)
+			if err != nil {
+				// Drop the connections that did not finish in time.
+				srv.Close()
+			}
+			errc <- err
+		}()
+	}
+	ok := true
+	for range srvs {
+		if err := <-errc; err != nil {
+			log.Printf("Draining connections: %v", err)
+			ok = false
+		}
+	}
+	for _, c := range closers {
+		if err := c.Close(); err != nil {
+			log.Printf("Closing %T: %v", c, err)
+			ok = false
+		}
+	}
+	return ok
 }
@@ -0,0 +1,37 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+)
+
+// httpsRedirect redirects plaintext requests to the same URL over HTTPS on
+// host. The Host header of the request is ignored, as it is not checked.
+//
+// Only GET and HEAD requests are redirected: other requests have already sent
+// their body in plaintext, and should fail loudly instead of being repeated.
+func httpsRedirect(host string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Use HTTPS", http.StatusBadRequest)
+			return
+		}
+		u := *r.URL
+		u.Scheme = "https"
+		u.Host = host
+		http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+	})
+}
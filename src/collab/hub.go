@@ -0,0 +1,426 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package collab lets several users edit the same note at the same time.
+//
+// Each client keeps a copy of the note and sends its edits as operations
+// (see Op) tagged with the revision they are based on. The server transforms
+// them against the operations applied since that revision, applies them, and
+// broadcasts them to the other clients, which transform them against their
+// own pending edits. This is the protocol of ot.js, which only needs one
+// pending operation per client and a linear history on the server.
+//
+// Messages are JSON objects with a "type":
+//   - "op" (client and server): an operation and the revision it is based on.
+//   - "ack" (server): the last operation of the client was applied.
+//   - "init" (server): the whole text, sent when joining and whenever the
+//     client cannot catch up with operations, e.g. after the note was edited
+//     outside of the session.
+//   - "presence" (server): the users editing the note.
+//   - "saved" (server): the version of the note in storage.
+package collab
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/empijei/go-safeweb-example-app/src/storage"
+)
+
+const (
+	// maxHistory is the number of operations a client can fall behind and
+	// still catch up with, instead of being sent the whole text again.
+	maxHistory = 256
+	// maxClients bounds the connections to a single note.
+	maxClients = 32
+	// maxTextLen is the maximum length of a note, in code points.
+	maxTextLen = 1 << 20
+	// sendBuffer is the number of messages a client can fall behind before it
+	// is disconnected.
+	sendBuffer = 64
+	// saveDelay throttles writes to the storage.
+	saveDelay = 2 * time.Second
+)
+
+// Close codes, the same as the WebSocket ones.
+const (
+	closeGoingAway       = 1001
+	closePolicyViolation = 1008
+	closeTryAgainLater   = 1013
+)
+
+// ErrTooManyClients is returned by Serve when too many clients are editing
+// the note already.
+var ErrTooManyClients = errors.New("too many clients")
+
+// Conn is a connection to a client, e.g. a *websocket.Conn.
+type Conn interface {
+	ReadMessage() (string, error)
+	WriteMessage(msg string) error
+	Close(code int)
+}
+
+// Hub holds the notes being edited.
+type Hub struct {
+	db *storage.DB
+
+	mu sync.Mutex
+	// owner -> title -> document
+	docs map[string]map[string]*document
+}
+
+// NewHub returns a Hub that loads and saves notes from db.
+func NewHub(db *storage.DB) *Hub {
+	return &Hub{db: db, docs: map[string]map[string]*document{}}
+}
+
+// Serve lets user edit the note of owner with the given title over conn,
+// until the connection is closed or user cannot edit the note anymore.
+func (h *Hub) Serve(conn Conn, owner, title, user string) error {
+	c := &client{user: user, conn: conn, send: make(chan []byte, sendBuffer)}
+	if !h.db.NoteRole(user, owner, title).CanEdit() {
+		conn.Close(closePolicyViolation)
+		return storage.ErrForbidden
+	}
+	d, err := h.join(owner, title, c)
+	if err != nil {
+		conn.Close(closeTryAgainLater)
+		return err
+	}
+	defer h.leave(d, c)
+
+	go c.writeLoop()
+	for {
+		raw, err := conn.ReadMessage()
+		if err != nil {
+			return nil
+		}
+		var m struct {
+			Type string `json:"type"`
+			Rev  int    `json:"rev"`
+			Op   Op     `json:"op"`
+		}
+		if err := json.Unmarshal([]byte(raw), &m); err != nil || m.Type != "op" {
+			conn.Close(closePolicyViolation)
+			return errors.New("invalid message")
+		}
+		d.apply(c, m.Rev, m.Op)
+	}
+}
+
+// join adds c to the document of the note, loading it if needed.
+func (h *Hub) join(owner, title string, c *client) (*document, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	d := h.docs[owner][title]
+	if d == nil {
+		n, ok := h.db.GetNote(owner, title)
+		if !ok {
+			return nil, errors.New("no such note")
+		}
+		notes, cancel, err := h.db.Subscribe(owner)
+		if err != nil {
+			return nil, err
+		}
+		d = &document{
+			hub:      h,
+			owner:    owner,
+			title:    title,
+			text:     []rune(n.Text),
+			markdown: n.Markdown,
+			tags:     n.Tags,
+			version:  n.Version,
+			clients:  map[*client]bool{},
+			cancel:   cancel,
+		}
+		if h.docs[owner] == nil {
+			h.docs[owner] = map[string]*document{}
+		}
+		h.docs[owner][title] = d
+		go d.watch(notes)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.clients) >= maxClients {
+		return nil, ErrTooManyClients
+	}
+	d.clients[c] = true
+	c.sendLocked(d.initMsg())
+	d.broadcastPresence()
+	return d, nil
+}
+
+// leave removes c from d, and unloads d once its last client left.
+func (h *Hub) leave(d *document, c *client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.removeLocked(c)
+	if len(d.clients) > 0 {
+		d.broadcastPresence()
+		return
+	}
+	d.saveLocked()
+	d.closed = true
+	d.cancel()
+	delete(h.docs[d.owner], d.title)
+	if len(h.docs[d.owner]) == 0 {
+		delete(h.docs, d.owner)
+	}
+}
+
+// document is a note being edited.
+type document struct {
+	hub          *Hub
+	owner, title string
+
+	mu   sync.Mutex
+	text []rune
+	// rev is the number of operations applied. history holds the last ones,
+	// base is the revision history starts from.
+	rev, base int
+	history   []Op
+	// markdown and tags are kept as is, only the text is edited.
+	markdown bool
+	tags     []string
+	// version is the version in storage the text is based on.
+	version int
+	dirty   bool
+	saving  *time.Timer
+	clients map[*client]bool
+	cancel  func()
+	closed  bool
+}
+
+// apply applies op, sent by c and based on revision rev.
+func (d *document) apply(c *client, rev int, op Op) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed || !d.clients[c] {
+		return
+	}
+	// The note might have been unshared since the client joined.
+	if !d.hub.db.NoteRole(c.user, d.owner, d.title).CanEdit() {
+		go c.conn.Close(closePolicyViolation)
+		return
+	}
+	text, err := d.transformAndApply(rev, &op)
+	if err != nil {
+		// The client is out of sync, start over.
+		c.sendLocked(d.initMsg())
+		return
+	}
+	d.text = text
+	d.rev++
+	d.history = append(d.history, op)
+	if len(d.history) > maxHistory {
+		d.history = d.history[len(d.history)-maxHistory:]
+		d.base = d.rev - maxHistory
+	}
+	c.sendLocked(mustMarshal(map[string]interface{}{"type": "ack", "rev": d.rev}))
+	msg := mustMarshal(map[string]interface{}{"type": "op", "rev": d.rev, "op": op})
+	for other := range d.clients {
+		if other != c {
+			other.sendLocked(msg)
+		}
+	}
+	d.dirty = true
+	if d.saving == nil {
+		d.saving = time.AfterFunc(saveDelay, d.save)
+	}
+}
+
+func (d *document) transformAndApply(rev int, op *Op) ([]rune, error) {
+	if rev < d.base || rev > d.rev {
+		return nil, errors.New("revision out of range")
+	}
+	for _, concurrent := range d.history[rev-d.base:] {
+		var err error
+		if *op, _, err = Transform(*op, concurrent); err != nil {
+			return nil, err
+		}
+	}
+	if op.targetLen > maxTextLen {
+		return nil, errors.New("text too long")
+	}
+	return op.Apply(d.text)
+}
+
+func (d *document) save() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.saveLocked()
+}
+
+// saveLocked writes the text to storage, if it changed.
+func (d *document) saveLocked() {
+	if d.saving != nil {
+		d.saving.Stop()
+		d.saving = nil
+	}
+	if d.closed || !d.dirty {
+		return
+	}
+	d.dirty = false
+	n, err := d.hub.db.AddOrEditNote(d.owner, storage.Note{Title: d.title, Text: string(d.text), Markdown: d.markdown, Tags: d.tags, Version: d.version})
+	if errors.Is(err, storage.ErrConflict) {
+		// The note was edited outside of the session, which wins: the session
+		// might have been started from a stale copy.
+		d.resetLocked(n)
+		return
+	}
+	if err != nil {
+		log.Printf("collab: saving note: %v", err)
+		return
+	}
+	d.version = n.Version
+	d.broadcast(mustMarshal(map[string]interface{}{"type": "saved", "version": n.Version}))
+}
+
+// watch follows the changes to the notes of the owner, to pick up edits made
+// outside of the session.
+func (d *document) watch(notes <-chan storage.Note) {
+	for {
+		for n := range notes {
+			d.mu.Lock()
+			switch {
+			case d.closed || n.Title != d.title:
+			case n.Deleted:
+				// Saving would bring the note back.
+				d.dirty = false
+				for c := range d.clients {
+					go c.conn.Close(closeGoingAway)
+				}
+			case n.Version > d.version:
+				d.resetLocked(n)
+			}
+			d.mu.Unlock()
+		}
+		// The subscription ended: the document was unloaded, this fell
+		// behind, or the server is shutting down.
+		d.mu.Lock()
+		if d.closed {
+			d.mu.Unlock()
+			return
+		}
+		var cancel func()
+		var err error
+		notes, cancel, err = d.hub.db.Subscribe(d.owner)
+		if err != nil {
+			for c := range d.clients {
+				c.conn.Close(closeGoingAway)
+			}
+			d.mu.Unlock()
+			return
+		}
+		d.cancel = cancel
+		// Changes might have been missed.
+		if n, ok := d.hub.db.GetNote(d.owner, d.title); ok && n.Version > d.version {
+			d.resetLocked(n)
+		}
+		d.mu.Unlock()
+	}
+}
+
+// resetLocked replaces the text with n and sends it to all clients. Their
+// pending operations are based on the old text, so the revision is bumped to
+// reject them.
+func (d *document) resetLocked(n storage.Note) {
+	d.text = []rune(n.Text)
+	d.markdown = n.Markdown
+	d.tags = n.Tags
+	d.version = n.Version
+	d.dirty = false
+	d.rev++
+	d.base = d.rev
+	d.history = nil
+	d.broadcast(d.initMsg())
+}
+
+func (d *document) initMsg() []byte {
+	return mustMarshal(map[string]interface{}{
+		"type":    "init",
+		"rev":     d.rev,
+		"text":    string(d.text),
+		"version": d.version,
+	})
+}
+
+func (d *document) broadcastPresence() {
+	seen := map[string]bool{}
+	users := []string{}
+	for c := range d.clients {
+		if !seen[c.user] {
+			seen[c.user] = true
+			users = append(users, c.user)
+		}
+	}
+	sort.Strings(users)
+	d.broadcast(mustMarshal(map[string]interface{}{"type": "presence", "users": users}))
+}
+
+func (d *document) broadcast(msg []byte) {
+	for c := range d.clients {
+		c.sendLocked(msg)
+	}
+}
+
+func (d *document) removeLocked(c *client) {
+	if d.clients[c] {
+		delete(d.clients, c)
+		close(c.send)
+	}
+}
+
+// client is a connection to a document. Its messages are sent by a separate
+// goroutine, so that a slow client does not hold up the others.
+type client struct {
+	user string
+	conn Conn
+	// send is closed when the client leaves.
+	send chan []byte
+}
+
+// sendLocked queues msg, disconnecting the client if it fell behind. The
+// document lock must be held.
+func (c *client) sendLocked(msg []byte) {
+	select {
+	case c.send <- msg:
+	default:
+		// Closing might block on a pending write, do not hold the lock.
+		go c.conn.Close(closeTryAgainLater)
+	}
+}
+
+func (c *client) writeLoop() {
+	for msg := range c.send {
+		if err := c.conn.WriteMessage(string(msg)); err != nil {
+			// Closing the connection makes Serve return.
+			c.conn.Close(closeGoingAway)
+		}
+	}
+}
+
+func mustMarshal(v interface{}) []byte {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
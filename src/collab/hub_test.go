@@ -0,0 +1,147 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collab
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/empijei/go-safeweb-example-app/src/secure/envelope"
+	"github.com/empijei/go-safeweb-example-app/src/storage"
+)
+
+// fakeConn is a Conn whose client is driven by the test.
+type fakeConn struct {
+	in   chan string
+	out  chan string
+	once sync.Once
+	done chan struct{}
+}
+
+func newFakeConn() *fakeConn {
+	return &fakeConn{in: make(chan string), out: make(chan string, sendBuffer), done: make(chan struct{})}
+}
+
+func (c *fakeConn) ReadMessage() (string, error) {
+	select {
+	case msg := <-c.in:
+		return msg, nil
+	case <-c.done:
+		return "", errors.New("closed")
+	}
+}
+
+func (c *fakeConn) WriteMessage(msg string) error {
+	c.out <- msg
+	return nil
+}
+
+func (c *fakeConn) Close(code int) {
+	c.once.Do(func() { close(c.done) })
+}
+
+type message struct {
+	Type string          `json:"type"`
+	Rev  int             `json:"rev"`
+	Text string          `json:"text"`
+	Op   json.RawMessage `json:"op"`
+}
+
+// next returns the next message of type typ sent to the client, skipping the
+// others.
+func (c *fakeConn) next(t *testing.T, typ string) message {
+	t.Helper()
+	for {
+		select {
+		case raw := <-c.out:
+			var m message
+			if err := json.Unmarshal([]byte(raw), &m); err != nil {
+				t.Fatal(err)
+			}
+			if m.Type == typ {
+				return m
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("No %q message", typ)
+		}
+	}
+}
+
+func TestHubRebasesStaleOperations(t *testing.T) {
+	keys, err := envelope.NewKeyring([]string{"master"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := storage.NewDB(envelope.NewVault(keys, envelope.NewMemoryKeyStore()))
+	if _, err := db.AddOrEditNote("alice", storage.Note{Title: "doc", Text: "héllo"}); err != nil {
+		t.Fatal(err)
+	}
+	h := NewHub(db)
+
+	var wg sync.WaitGroup
+	join := func() *fakeConn {
+		c := newFakeConn()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := h.Serve(c, "alice", "doc", "alice"); err != nil {
+				t.Errorf("Serve(): %v", err)
+			}
+		}()
+		if m := c.next(t, "init"); m.Rev != 0 || m.Text != "héllo" {
+			t.Fatalf("init = %+v, want revision 0 of the note", m)
+		}
+		return c
+	}
+	c1, c2 := join(), join()
+
+	c1.in <- `{"type": "op", "rev": 0, "op": [5, " wörld"]}`
+	if m := c1.next(t, "ack"); m.Rev != 1 {
+		t.Fatalf("ack = %+v, want revision 1", m)
+	}
+	// Based on revision 0, before the operation of c1.
+	c2.in <- `{"type": "op", "rev": 0, "op": ["Oh, ", 1, "e", -1, 3]}`
+	if m := c2.next(t, "ack"); m.Rev != 2 {
+		t.Fatalf("ack = %+v, want revision 2", m)
+	}
+	// c1 gets the operation rebased on its own.
+	m := c1.next(t, "op")
+	if want := `["Oh, ",1,"e",-1,9]`; m.Rev != 2 || string(m.Op) != want {
+		t.Errorf("c1 got op %s at revision %d, want %s at revision 2", m.Op, m.Rev, want)
+	}
+
+	// Too old to be rebased.
+	h.mu.Lock()
+	d := h.docs["alice"]["doc"]
+	h.mu.Unlock()
+	d.mu.Lock()
+	d.base = 1
+	d.history = d.history[1:]
+	d.mu.Unlock()
+	c2.in <- `{"type": "op", "rev": 0, "op": [5, "!"]}`
+	if m := c2.next(t, "init"); m.Rev != 2 || m.Text != "Oh, hello wörld" {
+		t.Errorf("After an operation too old to rebase, init = %+v, want revision 2 and the current text", m)
+	}
+
+	c1.Close(closeGoingAway)
+	c2.Close(closeGoingAway)
+	wg.Wait()
+	if n, _ := db.GetNote("alice", "doc"); n.Text != "Oh, hello wörld" {
+		t.Errorf("Saved text = %q, want %q", n.Text, "Oh, hello wörld")
+	}
+}
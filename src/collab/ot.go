@@ -0,0 +1,243 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collab
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// Op is an operation on a text, in the format of ot.js
+// (https://github.com/Operational-Transformation/ot.js): a sequence of
+// components that go over the whole text, each retaining, inserting or
+// deleting characters. Lengths are in Unicode code points.
+//
+// The client in static/collab.js implements the same operations.
+type Op struct {
+	comps []component
+	// baseLen is the length of the texts the operation applies to, targetLen
+	// the length of the texts it produces.
+	baseLen, targetLen int
+}
+
+// component retains n characters if n > 0, deletes -n characters if n < 0,
+// and inserts ins otherwise.
+type component struct {
+	n   int
+	ins string
+}
+
+func (c component) isRetain() bool { return c.n > 0 }
+func (c component) isDelete() bool { return c.n < 0 }
+func (c component) isInsert() bool { return c.n == 0 }
+
+// The builder methods below keep operations canonical: no empty components,
+// no consecutive components of the same kind, and inserts before deletes.
+
+func (o *Op) retain(n int) {
+	if n == 0 {
+		return
+	}
+	o.baseLen += n
+	o.targetLen += n
+	if l := len(o.comps); l > 0 && o.comps[l-1].isRetain() {
+		o.comps[l-1].n += n
+		return
+	}
+	o.comps = append(o.comps, component{n: n})
+}
+
+func (o *Op) insert(s string) {
+	if s == "" {
+		return
+	}
+	o.targetLen += utf8.RuneCountInString(s)
+	l := len(o.comps)
+	switch {
+	case l > 0 && o.comps[l-1].isInsert():
+		o.comps[l-1].ins += s
+	case l > 0 && o.comps[l-1].isDelete():
+		// Inserting before or after a delete is the same, always insert first.
+		if l > 1 && o.comps[l-2].isInsert() {
+			o.comps[l-2].ins += s
+			return
+		}
+		o.comps = append(o.comps, o.comps[l-1])
+		o.comps[l-1] = component{ins: s}
+	default:
+		o.comps = append(o.comps, component{ins: s})
+	}
+}
+
+// delete deletes n characters, n > 0.
+func (o *Op) delete(n int) {
+	if n == 0 {
+		return
+	}
+	o.baseLen += n
+	if l := len(o.comps); l > 0 && o.comps[l-1].isDelete() {
+		o.comps[l-1].n -= n
+		return
+	}
+	o.comps = append(o.comps, component{n: -n})
+}
+
+// MarshalJSON encodes o as an array of numbers (retain if positive, delete if
+// negative) and strings (insert).
+func (o Op) MarshalJSON() ([]byte, error) {
+	vs := make([]interface{}, 0, len(o.comps))
+	for _, c := range o.comps {
+		if c.isInsert() {
+			vs = append(vs, c.ins)
+		} else {
+			vs = append(vs, c.n)
+		}
+	}
+	return json.Marshal(vs)
+}
+
+// UnmarshalJSON decodes an operation encoded by MarshalJSON.
+func (o *Op) UnmarshalJSON(b []byte) error {
+	var vs []interface{}
+	if err := json.Unmarshal(b, &vs); err != nil {
+		return err
+	}
+	*o = Op{}
+	for _, v := range vs {
+		switch v := v.(type) {
+		case float64:
+			n := int(v)
+			if float64(n) != v || n == 0 {
+				return fmt.Errorf("invalid component %v", v)
+			}
+			if n > 0 {
+				o.retain(n)
+			} else {
+				o.delete(-n)
+			}
+		case string:
+			if v == "" {
+				return errors.New("empty insert")
+			}
+			o.insert(v)
+		default:
+			return fmt.Errorf("invalid component %v", v)
+		}
+	}
+	return nil
+}
+
+// Apply returns the result of applying o to text.
+func (o Op) Apply(text []rune) ([]rune, error) {
+	if len(text) != o.baseLen {
+		return nil, fmt.Errorf("operation applies to texts of length %d, not %d", o.baseLen, len(text))
+	}
+	out := make([]rune, 0, o.targetLen)
+	i := 0
+	for _, c := range o.comps {
+		switch {
+		case c.isRetain():
+			out = append(out, text[i:i+c.n]...)
+			i += c.n
+		case c.isDelete():
+			i -= c.n
+		default:
+			out = append(out, []rune(c.ins)...)
+		}
+	}
+	return out, nil
+}
+
+// Transform returns a1 and b1 such that applying a then b1 gives the same
+// result as applying b then a1, for a and b concurrent operations on the same
+// text. Inserts of a at the same position as inserts of b come first.
+func Transform(a, b Op) (a1, b1 Op, err error) {
+	if a.baseLen != b.baseLen {
+		return Op{}, Op{}, errors.New("concurrent operations must apply to the same text")
+	}
+	as, bs := a.comps, b.comps
+	var ca, cb component
+	next := func(cs *[]component) (component, bool) {
+		if len(*cs) == 0 {
+			return component{}, false
+		}
+		c := (*cs)[0]
+		*cs = (*cs)[1:]
+		return c, true
+	}
+	okA, okB := false, false
+	ca, okA = next(&as)
+	cb, okB = next(&bs)
+	for okA || okB {
+		if okA && ca.isInsert() {
+			a1.insert(ca.ins)
+			b1.retain(utf8.RuneCountInString(ca.ins))
+			ca, okA = next(&as)
+			continue
+		}
+		if okB && cb.isInsert() {
+			a1.retain(utf8.RuneCountInString(cb.ins))
+			b1.insert(cb.ins)
+			cb, okB = next(&bs)
+			continue
+		}
+		if !okA || !okB {
+			// Cannot happen if the base lengths match.
+			return Op{}, Op{}, errors.New("operations have different lengths")
+		}
+		na, nb := abs(ca.n), abs(cb.n)
+		min := na
+		if nb < min {
+			min = nb
+		}
+		switch {
+		case ca.isRetain() && cb.isRetain():
+			a1.retain(min)
+			b1.retain(min)
+		case ca.isDelete() && cb.isRetain():
+			a1.delete(min)
+		case ca.isRetain() && cb.isDelete():
+			b1.delete(min)
+		}
+		// When both delete the same characters, neither has to do it again.
+		if na == min {
+			ca, okA = next(&as)
+		} else {
+			ca.n = sign(ca.n) * (na - min)
+		}
+		if nb == min {
+			cb, okB = next(&bs)
+		} else {
+			cb.n = sign(cb.n) * (nb - min)
+		}
+	}
+	return a1, b1, nil
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func sign(n int) int {
+	if n < 0 {
+		return -1
+	}
+	return 1
+}
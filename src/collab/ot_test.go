@@ -0,0 +1,179 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collab
+
+import (
+	"encoding/json"
+	"math/rand"
+	"testing"
+	"unicode/utf8"
+)
+
+// mustOp decodes an operation in the JSON format of ot.js.
+func mustOp(t *testing.T, js string) Op {
+	t.Helper()
+	var o Op
+	if err := json.Unmarshal([]byte(js), &o); err != nil {
+		t.Fatalf("Decoding %s: %v", js, err)
+	}
+	return o
+}
+
+func apply(t *testing.T, o Op, text string) string {
+	t.Helper()
+	out, err := o.Apply([]rune(text))
+	if err != nil {
+		t.Fatalf("Applying %s to %q: %v", mustMarshal(o), text, err)
+	}
+	return string(out)
+}
+
+// checkConvergence checks that a then the transformed b gives the same text as
+// b then the transformed a, and returns it.
+func checkConvergence(t *testing.T, text string, a, b Op) string {
+	t.Helper()
+	a1, b1, err := Transform(a, b)
+	if err != nil {
+		t.Fatalf("Transform(%s, %s): %v", mustMarshal(a), mustMarshal(b), err)
+	}
+	ab := apply(t, b1, apply(t, a, text))
+	ba := apply(t, a1, apply(t, b, text))
+	if ab != ba {
+		t.Errorf("On %q, a = %s, b = %s: a then b' gives %q, b then a' gives %q", text, mustMarshal(a), mustMarshal(b), ab, ba)
+	}
+	return ab
+}
+
+func TestTransform(t *testing.T) {
+	for _, tc := range []struct {
+		name, text, a, b, want string
+	}{
+		{"inserts apart", "hello", `["A", 5]`, `[5, "B"]`, "AhelloB"},
+		// Inserts of a come first.
+		{"inserts at the same position", "hello", `[2, "A", 3]`, `[2, "B", 3]`, "heABllo"},
+		{"inserts at the start", "", `["A"]`, `["B"]`, "AB"},
+		{"insert and delete", "hello", `[1, "A", 4]`, `[1, -3, 1]`, "hAo"},
+		{"insert in a deleted range", "hello", `[2, "A", 3]`, `[-5]`, "A"},
+		{"same delete", "hello", `[1, -3, 1]`, `[1, -3, 1]`, "ho"},
+		{"overlapping deletes", "hello", `[-3, 2]`, `[1, -4]`, ""},
+		{"delete and replace", "hello world", `[6, -5]`, `[6, "there", -5]`, "hello there"},
+		{"retains only", "hello", `[5]`, `[5]`, "hello"},
+		{"multi-byte runes", "héllo wörld", `[1, -1, "e", 9]`, `[7, -1, "o", 3]`, "hello world"},
+		{"astral runes", "a😀b", `[1, "🙂", 2]`, `[1, -1, 1]`, "a🙂b"},
+		{"multi-byte inserts at the same position", "日本", `[1, "の", 1]`, `[1, "語", 1]`, "日の語本"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a, b := mustOp(t, tc.a), mustOp(t, tc.b)
+			if got := checkConvergence(t, tc.text, a, b); got != tc.want {
+				t.Errorf("Converged to %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTransformDifferentTexts(t *testing.T) {
+	if _, _, err := Transform(mustOp(t, `[3]`), mustOp(t, `[4]`)); err == nil {
+		t.Error("Transform() of operations on texts of different lengths: got nil error")
+	}
+}
+
+// randomOp returns a random operation on text.
+func randomOp(r *rand.Rand, text []rune) Op {
+	runes := []rune("ab é😀日\n")
+	var o Op
+	for i := 0; i < len(text); {
+		n := 1 + r.Intn(len(text)-i)
+		switch r.Intn(3) {
+		case 0:
+			o.retain(n)
+			i += n
+		case 1:
+			o.delete(n)
+			i += n
+		default:
+			ins := make([]rune, 1+r.Intn(3))
+			for j := range ins {
+				ins[j] = runes[r.Intn(len(runes))]
+			}
+			o.insert(string(ins))
+		}
+	}
+	if r.Intn(2) == 0 {
+		o.insert("z")
+	}
+	return o
+}
+
+func TestTransformConverges(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		text := []rune("héllo 😀 wörld 日本")[:r.Intn(16)]
+		a, b := randomOp(r, text), randomOp(r, text)
+		got := checkConvergence(t, string(text), a, b)
+		// The transformed operations must stay canonical, to be sent again.
+		a1, _, _ := Transform(a, b)
+		if again := mustOp(t, string(mustMarshal(a1))); string(mustMarshal(again)) != string(mustMarshal(a1)) {
+			t.Errorf("Transform() returned %s, which is not canonical", mustMarshal(a1))
+		}
+		if t.Failed() {
+			t.Fatalf("Failed on %q with a = %s, b = %s, got %q", string(text), mustMarshal(a), mustMarshal(b), got)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	o := mustOp(t, `[1, -2, "ü😀", 1]`)
+	if got := apply(t, o, "añoz"); got != "aü😀z" {
+		t.Errorf("Apply() = %q, want %q", got, "aü😀z")
+	}
+	if got := utf8.RuneCountInString("aü😀z"); o.targetLen != got {
+		t.Errorf("targetLen = %d, want %d", o.targetLen, got)
+	}
+	// Lengths are in code points, not bytes.
+	if _, err := o.Apply([]rune("año")); err == nil {
+		t.Error("Apply() to a text of another length: got nil error")
+	}
+}
+
+func TestUnmarshalJSON(t *testing.T) {
+	for _, tc := range []struct {
+		in, want string
+	}{
+		{`[]`, `[]`},
+		{`[3, "a", -2]`, `[3,"a",-2]`},
+		// Operations are made canonical.
+		{`[1, 2]`, `[3]`},
+		{`["a", "b"]`, `["ab"]`},
+		{`[-1, -2]`, `[-3]`},
+		{`[-1, "a", 1]`, `["a",-1,1]`},
+		{`["a", -1, "b"]`, `["ab",-1]`},
+	} {
+		var o Op
+		if err := json.Unmarshal([]byte(tc.in), &o); err != nil {
+			t.Errorf("Unmarshal(%s): %v", tc.in, err)
+			continue
+		}
+		if got := string(mustMarshal(o)); got != tc.want {
+			t.Errorf("Unmarshal(%s) = %s, want %s", tc.in, got, tc.want)
+		}
+	}
+
+	for _, in := range []string{`[0]`, `[1.5]`, `[""]`, `[true]`, `[null]`, `[[1]]`, `{"retain": 1}`, `"a"`} {
+		var o Op
+		if err := json.Unmarshal([]byte(in), &o); err == nil {
+			t.Errorf("Unmarshal(%s) = %s, want an error", in, mustMarshal(o))
+		}
+	}
+}
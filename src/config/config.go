@@ -0,0 +1,461 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package config defines the configuration of the application.
+//
+// The configuration is read from a YAML file, then overridden by environment
+// variables, then validated. See notekeeper.example.yaml at the root of the
+// repository for a documented example.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the configuration of the application.
+type Config struct {
+	// Dev enables the development mode, which relaxes security checks that
+	// cannot work on a local machine. Never enable it in production.
+	Dev bool `yaml:"dev"`
+
+	Server      Server      `yaml:"server"`
+	TLS         TLS         `yaml:"tls"`
+	Compression Compression `yaml:"compression"`
+	Storage     Storage     `yaml:"storage"`
+	Secrets     Secrets     `yaml:"secrets"`
+	Plugins     Plugins     `yaml:"plugins"`
+}
+
+// Server configures the listeners and the HTTP servers.
+type Server struct {
+	// Listen is the listener for the application: "host:port", "unix:PATH",
+	// "systemd" or "systemd:NAME" for socket activation.
+	Listen string `yaml:"listen"`
+	// AdminListen is the listener for the admin endpoints. It must not be
+	// reachable from the internet.
+	AdminListen string `yaml:"admin_listen"`
+	// RedirectListen is an optional plaintext listener that redirects to
+	// HTTPS. It requires TLS.
+	RedirectListen string `yaml:"redirect_listen"`
+
+	// PublicHosts are the host names (with the port, if it is not the default
+	// one) users reach the application at. The first one is canonical. In dev
+	// mode it defaults to localhost, with the port of Listen.
+	PublicHosts []string `yaml:"public_hosts"`
+	// AllowedHosts are accepted as Host header in addition to PublicHosts,
+	// e.g. for internal names.
+	AllowedHosts []string `yaml:"allowed_hosts"`
+	// BehindProxy is set when TLS is terminated by a reverse proxy.
+	BehindProxy bool `yaml:"behind_proxy"`
+
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+	MaxHeaderBytes    int           `yaml:"max_header_bytes"`
+	// MaxBodyBytes bounds the size of request bodies, e.g. uploads.
+	MaxBodyBytes    int64         `yaml:"max_body_bytes"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// AuditLog is the file the audit log is appended to, see package audit.
+	// If empty, it is written to the standard error.
+	AuditLog string `yaml:"audit_log"`
+}
+
+// TLS configures HTTPS. It is enabled when Cert and Key are set.
+type TLS struct {
+	Cert string `yaml:"cert"`
+	Key  string `yaml:"key"`
+	// ClientCA enables authentication with client certificates signed by
+	// these CAs.
+	ClientCA       string        `yaml:"client_ca"`
+	ReloadInterval time.Duration `yaml:"reload_interval"`
+}
+
+// Enabled reports whether the application serves HTTPS.
+func (t TLS) Enabled() bool {
+	return t.Cert != "" || t.Key != ""
+}
+
+// Compression configures the gzip compression of responses.
+type Compression struct {
+	Enabled bool `yaml:"enabled"`
+	// MinSize is the size in bytes under which responses are not compressed.
+	MinSize int `yaml:"min_size"`
+	// Padding enables the compression of responses that contain secrets,
+	// e.g. XSRF tokens, with random padding that makes BREACH attacks slower.
+	// Without it, these responses are never compressed.
+	Padding bool `yaml:"padding"`
+}
+
+// Storage configures the storage.
+type Storage struct {
+	// Backend is the kind of storage. Only "memory" is supported.
+	Backend string `yaml:"backend"`
+	// TrashRetention is how long deleted notes can be restored before they
+	// are purged.
+	TrashRetention time.Duration `yaml:"trash_retention"`
+	// PurgeInterval is how often the trash is checked for notes to purge.
+	PurgeInterval time.Duration `yaml:"purge_interval"`
+	// BlobDir is the directory attachments are stored in. If empty, they are
+	// kept in memory.
+	BlobDir string `yaml:"blob_dir"`
+}
+
+// Secrets holds the keys of the application. Prefer setting them with
+// environment variables over writing them in the configuration file.
+type Secrets struct {
+	// XSRFKey is used to sign XSRF tokens.
+	XSRFKey string `yaml:"xsrf_key"`
+	// ShareLinkKey is used to sign public share links. Changing it invalidates
+	// all of them.
+	ShareLinkKey string `yaml:"share_link_key"`
+	// MasterKeys wrap the keys that encrypt notes at rest. The first one is
+	// current, the others are old ones kept until the notes keys they wrapped
+	// are wrapped by the current one, which happens in the background: this
+	// is how they are rotated. Removing a key that is still in use makes the
+	// notes it protects unreadable.
+	MasterKeys []string `yaml:"master_keys"`
+}
+
+// Plugins toggles the optional safehttp plugins. All of them are enabled by
+// default, and disabling any of them weakens the security of the application.
+type Plugins struct {
+	COOP          bool `yaml:"coop"`
+	CSP           bool `yaml:"csp"`
+	FetchMetadata bool `yaml:"fetch_metadata"`
+	HSTS          bool `yaml:"hsts"`
+	// TrustedTypes is the Trusted Types mode of the CSP: "enforce", or
+	// "report-only" to roll it out without breaking pages. It has no effect if
+	// CSP is disabled.
+	TrustedTypes TrustedTypesMode `yaml:"trusted_types"`
+}
+
+// TrustedTypesMode is how Trusted Types are required by the CSP.
+type TrustedTypesMode string
+
+// Trusted Types modes.
+const (
+	TrustedTypesEnforce    TrustedTypesMode = "enforce"
+	TrustedTypesReportOnly TrustedTypesMode = "report-only"
+)
+
+// devXSRFKey, devShareLinkKey and devMasterKey are only accepted in dev mode.
+const (
+	devXSRFKey      = "dev-xsrf-key-that-must-not-be-used-in-production"
+	devShareLinkKey = "dev-share-link-key-that-must-not-be-used-in-production"
+	devMasterKey    = "dev-master-key-that-must-not-be-used-in-production"
+)
+
+// minSecretLen is the minimum length of secrets.
+const minSecretLen = 32
+
+// Default returns the default configuration.
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			Listen:            "localhost:8080",
+			AdminListen:       "localhost:8081",
+			ReadHeaderTimeout: 5 * time.Second,
+			ReadTimeout:       30 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       2 * time.Minute,
+			MaxHeaderBytes:    64 << 10,
+			MaxBodyBytes:      16 << 20,
+			ShutdownTimeout:   20 * time.Second,
+		},
+		TLS: TLS{
+			ReloadInterval: time.Minute,
+		},
+		Compression: Compression{
+			Enabled: true,
+			MinSize: 1024,
+		},
+		Storage: Storage{
+			Backend:        "memory",
+			TrashRetention: 30 * 24 * time.Hour,
+			PurgeInterval:  time.Hour,
+		},
+		Plugins: Plugins{
+			COOP:          true,
+			CSP:           true,
+			FetchMetadata: true,
+			HSTS:          true,
+			TrustedTypes:  TrustedTypesEnforce,
+		},
+	}
+}
+
+// Load reads the configuration from the YAML file at path, if not empty, and
+// applies the environment overrides on top of it. Missing settings keep their
+// default values.
+//
+// The returned configuration is not validated, see Validate.
+func Load(path string) (*Config, error) {
+	c := Default()
+	if path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		dec := yaml.NewDecoder(bytes.NewReader(b))
+		// Typos in the configuration should not silently be ignored.
+		dec.KnownFields(true)
+		if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("parsing %q: %v", path, err)
+		}
+	}
+	if err := c.applyEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// envPrefix is the prefix of all the environment variables that override the
+// configuration.
+const envPrefix = "NOTEKEEPER_"
+
+// envOverrides maps environment variables, without envPrefix, to the settings
+// they override.
+var envOverrides = map[string]func(c *Config, v string) error{
+	"DEV":             func(c *Config, v string) error { return parseBool(&c.Dev, v) },
+	"LISTEN":          func(c *Config, v string) error { c.Server.Listen = v; return nil },
+	"ADMIN_LISTEN":    func(c *Config, v string) error { c.Server.AdminListen = v; return nil },
+	"REDIRECT_LISTEN": func(c *Config, v string) error { c.Server.RedirectListen = v; return nil },
+	"PUBLIC_HOSTS":    func(c *Config, v string) error { c.Server.PublicHosts = splitList(v); return nil },
+	"ALLOWED_HOSTS":   func(c *Config, v string) error { c.Server.AllowedHosts = splitList(v); return nil },
+	"BEHIND_PROXY":    func(c *Config, v string) error { return parseBool(&c.Server.BehindProxy, v) },
+	"AUDIT_LOG":       func(c *Config, v string) error { c.Server.AuditLog = v; return nil },
+	"TLS_CERT":        func(c *Config, v string) error { c.TLS.Cert = v; return nil },
+	"TLS_KEY":         func(c *Config, v string) error { c.TLS.Key = v; return nil },
+	"TLS_CLIENT_CA":   func(c *Config, v string) error { c.TLS.ClientCA = v; return nil },
+	"STORAGE_BACKEND": func(c *Config, v string) error { c.Storage.Backend = v; return nil },
+	"TRASH_RETENTION": func(c *Config, v string) error { return parseDuration(&c.Storage.TrashRetention, v) },
+	"BLOB_DIR":        func(c *Config, v string) error { c.Storage.BlobDir = v; return nil },
+	"TRUSTED_TYPES":   func(c *Config, v string) error { c.Plugins.TrustedTypes = TrustedTypesMode(v); return nil },
+	"XSRF_KEY":        func(c *Config, v string) error { c.Secrets.XSRFKey = v; return nil },
+	"SHARE_LINK_KEY":  func(c *Config, v string) error { c.Secrets.ShareLinkKey = v; return nil },
+	"MASTER_KEYS":     func(c *Config, v string) error { c.Secrets.MasterKeys = splitList(v); return nil },
+}
+
+func (c *Config) applyEnv(lookup func(string) (string, bool)) error {
+	for name, set := range envOverrides {
+		v, ok := lookup(envPrefix + name)
+		if !ok {
+			continue
+		}
+		if err := set(c, v); err != nil {
+			return fmt.Errorf("%s%s: %v", envPrefix, name, err)
+		}
+	}
+	return nil
+}
+
+func parseBool(dst *bool, v string) error {
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return err
+	}
+	*dst = b
+	return nil
+}
+
+func parseDuration(dst *time.Duration, v string) error {
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return err
+	}
+	*dst = d
+	return nil
+}
+
+// tcpPort returns the port of a "host:port" listener.
+func tcpPort(listen string) (string, bool) {
+	if strings.HasPrefix(listen, "unix:") || listen == "systemd" || strings.HasPrefix(listen, "systemd:") {
+		return "", false
+	}
+	_, port, err := net.SplitHostPort(listen)
+	if err != nil || port == "" || port == "0" {
+		return "", false
+	}
+	return port, true
+}
+
+func splitList(v string) []string {
+	var l []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			l = append(l, s)
+		}
+	}
+	return l
+}
+
+// Validate checks that the configuration is consistent and, unless in dev
+// mode, safe to use in production. It fills in the dev mode defaults.
+func (c *Config) Validate() error {
+	var errs []string
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Sprintf(format, args...))
+	}
+
+	if c.Server.Listen == "" {
+		fail("server.listen must be set")
+	}
+	if c.Server.AdminListen == "" {
+		fail("server.admin_listen must be set")
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		fail("server.shutdown_timeout must be positive")
+	}
+	if c.Server.MaxHeaderBytes <= 0 {
+		fail("server.max_header_bytes must be positive")
+	}
+	if c.Server.MaxBodyBytes <= 0 {
+		fail("server.max_body_bytes must be positive")
+	}
+	if c.Compression.MinSize < 0 {
+		fail("compression.min_size must not be negative")
+	}
+	switch c.Plugins.TrustedTypes {
+	case TrustedTypesEnforce, TrustedTypesReportOnly:
+	default:
+		fail("plugins.trusted_types must be %q or %q", TrustedTypesEnforce, TrustedTypesReportOnly)
+	}
+	if c.Storage.Backend != "memory" {
+		fail("storage.backend %q is not supported", c.Storage.Backend)
+	}
+	if c.Storage.TrashRetention <= 0 {
+		fail("storage.trash_retention must be positive")
+	}
+	if c.Storage.PurgeInterval <= 0 {
+		fail("storage.purge_interval must be positive")
+	}
+
+	if (c.TLS.Cert == "") != (c.TLS.Key == "") {
+		fail("tls.cert and tls.key must be set together")
+	}
+	if c.TLS.ClientCA != "" && !c.TLS.Enabled() {
+		fail("tls.client_ca requires tls.cert and tls.key")
+	}
+	if c.Server.RedirectListen != "" && !c.TLS.Enabled() {
+		fail("server.redirect_listen requires tls.cert and tls.key")
+	}
+	if c.TLS.Enabled() && c.TLS.ReloadInterval <= 0 {
+		fail("tls.reload_interval must be positive")
+	}
+
+	if c.Dev {
+		if len(c.Server.PublicHosts) == 0 {
+			// The listener can be any address, or a socket, but browsers
+			// on the same machine reach it as localhost.
+			if port, ok := tcpPort(c.Server.Listen); ok {
+				c.Server.PublicHosts = []string{"localhost:" + port}
+			} else {
+				fail("server.public_hosts must be set when server.listen is not a TCP address")
+			}
+		}
+		if c.Secrets.XSRFKey == "" {
+			c.Secrets.XSRFKey = devXSRFKey
+		}
+		if c.Secrets.ShareLinkKey == "" {
+			c.Secrets.ShareLinkKey = devShareLinkKey
+		}
+		if len(c.Secrets.MasterKeys) == 0 {
+			c.Secrets.MasterKeys = []string{devMasterKey}
+		}
+	} else {
+		if len(c.Server.PublicHosts) == 0 {
+			fail("server.public_hosts must be set")
+		}
+		switch {
+		case c.Secrets.XSRFKey == devXSRFKey:
+			fail("secrets.xsrf_key must not be the dev mode key")
+		case len(c.Secrets.XSRFKey) < minSecretLen:
+			fail("secrets.xsrf_key must be at least %d characters long", minSecretLen)
+		}
+		switch {
+		case c.Secrets.ShareLinkKey == devShareLinkKey:
+			fail("secrets.share_link_key must not be the dev mode key")
+		case len(c.Secrets.ShareLinkKey) < minSecretLen:
+			fail("secrets.share_link_key must be at least %d characters long", minSecretLen)
+		case c.Secrets.ShareLinkKey == c.Secrets.XSRFKey:
+			fail("secrets.share_link_key must differ from secrets.xsrf_key")
+		}
+		if len(c.Secrets.MasterKeys) == 0 {
+			fail("secrets.master_keys must be set")
+		}
+		for i, k := range c.Secrets.MasterKeys {
+			switch {
+			case k == devMasterKey:
+				fail("secrets.master_keys[%d] must not be the dev mode key", i)
+			case len(k) < minSecretLen:
+				fail("secrets.master_keys[%d] must be at least %d characters long", i, minSecretLen)
+			case k == c.Secrets.XSRFKey || k == c.Secrets.ShareLinkKey:
+				fail("secrets.master_keys[%d] must differ from the other secrets", i)
+			}
+		}
+		if c.Plugins.HSTS && !c.TLS.Enabled() && !c.Server.BehindProxy {
+			fail("HSTS would redirect every request: set tls.cert and tls.key, or server.behind_proxy")
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration:\n\t%s", strings.Join(errs, "\n\t"))
+	}
+	return nil
+}
+
+// Hosts returns all the values accepted for the Host header.
+func (c *Config) Hosts() []string {
+	return append(append([]string(nil), c.Server.PublicHosts...), c.Server.AllowedHosts...)
+}
+
+// Warnings returns the settings that weaken the security of the application.
+func (c *Config) Warnings() []string {
+	var ws []string
+	if c.Dev {
+		ws = append(ws, "dev mode is enabled, this configuration is not valid for production use")
+	}
+	for _, p := range []struct {
+		name    string
+		enabled bool
+	}{
+		{"coop", c.Plugins.COOP},
+		{"csp", c.Plugins.CSP},
+		{"fetch_metadata", c.Plugins.FetchMetadata},
+		{"hsts", c.Plugins.HSTS},
+	} {
+		if !p.enabled {
+			ws = append(ws, fmt.Sprintf("plugin %q is disabled", p.name))
+		}
+	}
+	if c.Plugins.CSP && c.Plugins.TrustedTypes != TrustedTypesEnforce {
+		ws = append(ws, "Trusted Types are not enforced")
+	}
+	if c.Compression.Enabled && c.Compression.Padding {
+		ws = append(ws, "responses containing secrets are compressed, padding only mitigates BREACH")
+	}
+	return ws
+}
@@ -0,0 +1,118 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package health provides the endpoints used by orchestrators to probe the
+// application.
+//
+// They are meant to be served on the admin listener, which performs neither
+// authentication nor host checks.
+package health
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/go-safeweb/safehttp"
+
+	"github.com/empijei/go-safeweb-example-app/src/secure/responses"
+)
+
+// checkTimeout bounds the time spent running all the readiness checks.
+const checkTimeout = 5 * time.Second
+
+// Check reports whether a dependency of the application works.
+type Check func(ctx context.Context) error
+
+// Live returns a handler that reports that the process is up. It never checks
+// dependencies, so that orchestrators do not restart the application when
+// one of them is down.
+func Live() safehttp.Handler {
+	return safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		return w.Write(responses.Text{Body: "ok\n"})
+	})
+}
+
+// Ready returns a handler that reports whether the application can serve
+// traffic, i.e. whether all checks pass. Checks run concurrently.
+func Ready(checks map[string]Check) safehttp.Handler {
+	var names []string
+	for n := range checks {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	return safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+		defer cancel()
+
+		errs := make([]chan error, len(names))
+		for i, n := range names {
+			errs[i] = make(chan error, 1)
+			go func(c Check, errc chan<- error) {
+				errc <- c(ctx)
+			}(checks[n], errs[i])
+		}
+
+		var b strings.Builder
+		ok := true
+		for i, n := range names {
+			var err error
+			select {
+			case err = <-errs[i]:
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+			if err != nil {
+				ok = false
+				fmt.Fprintf(&b, "%s: %v\n", n, err)
+				continue
+			}
+			fmt.Fprintf(&b, "%s: ok\n", n)
+		}
+		if !ok {
+			return w.WriteError(responses.TextError{
+				StatusCode: safehttp.StatusServiceUnavailable,
+				Body:       b.String(),
+			})
+		}
+		return w.Write(responses.Text{Body: b.String()})
+	})
+}
+
+// Version returns a handler that reports how the binary was built.
+func Version() safehttp.Handler {
+	var b strings.Builder
+	fmt.Fprintf(&b, "go: %s\n", runtime.Version())
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		fmt.Fprintf(&b, "path: %s\n", bi.Path)
+		fmt.Fprintf(&b, "module: %s %s\n", bi.Main.Path, bi.Main.Version)
+		for _, s := range bi.Settings {
+			// The VCS settings identify the commit the binary was built from,
+			// the others might leak details about the build environment.
+			if strings.HasPrefix(s.Key, "vcs") {
+				fmt.Fprintf(&b, "%s: %s\n", s.Key, s.Value)
+			}
+		}
+	}
+	version := b.String()
+
+	return safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		return w.Write(responses.Text{Body: version})
+	})
+}
@@ -0,0 +1,194 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package images
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+// ErrMalformed is returned for images whose structure cannot be parsed.
+var ErrMalformed = errors.New("malformed image")
+
+// StripMetadata returns the image in data without the metadata it may carry,
+// like the EXIF location of photos, XMP and comments. The image data is kept
+// as is rather than re-encoded, so that no quality is lost.
+//
+// JPEG, PNG and WebP images are supported. Other types, including GIF which
+// has no standard place for EXIF data, are returned unchanged.
+func StripMetadata(data []byte, contentType string) ([]byte, error) {
+	switch contentType {
+	case "image/jpeg":
+		return stripJPEG(data)
+	case "image/png":
+		return stripPNG(data)
+	case "image/webp":
+		return stripWebP(data)
+	}
+	return data, nil
+}
+
+// stripJPEG keeps the segments needed to display a JPEG image: everything
+// but the application segments other than JFIF, ICC profiles and Adobe color
+// transforms, and comments. Anything after the end of the image, like the
+// secondary images of MPF files, is dropped.
+func stripJPEG(data []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, ErrMalformed
+	}
+	out := append(make([]byte, 0, len(data)), data[:2]...)
+	i := 2
+	for {
+		if i+2 > len(data) || data[i] != 0xff {
+			return nil, ErrMalformed
+		}
+		// Markers can be preceded by any number of fill bytes.
+		for i+2 <= len(data) && data[i+1] == 0xff {
+			i++
+		}
+		if i+2 > len(data) {
+			return nil, ErrMalformed
+		}
+		marker := data[i+1]
+		switch {
+		case marker == 0xd9: // End of image.
+			return append(out, 0xff, 0xd9), nil
+		case marker == 0x01 || marker >= 0xd0 && marker <= 0xd7: // No payload.
+			out = append(out, data[i:i+2]...)
+			i += 2
+			continue
+		}
+		if i+4 > len(data) {
+			return nil, ErrMalformed
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		if end > len(data) || end < i+4 {
+			return nil, ErrMalformed
+		}
+		if keepJPEGSegment(marker, data[i+4:end]) {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+		if marker != 0xda {
+			continue
+		}
+		// A start of scan is followed by entropy-coded data, which runs until
+		// the next marker other than a stuffed 0xff byte or a restart.
+		start := i
+		for ; i+1 < len(data); i++ {
+			if data[i] == 0xff && data[i+1] != 0 && (data[i+1] < 0xd0 || data[i+1] > 0xd7) {
+				break
+			}
+		}
+		out = append(out, data[start:i]...)
+	}
+}
+
+func keepJPEGSegment(marker byte, payload []byte) bool {
+	switch {
+	case marker == 0xfe: // Comment.
+		return false
+	case marker == 0xe0: // JFIF.
+		return true
+	case marker == 0xe2:
+		return bytes.HasPrefix(payload, []byte("ICC_PROFILE\x00"))
+	case marker == 0xee:
+		return bytes.HasPrefix(payload, []byte("Adobe"))
+	case marker >= 0xe1 && marker <= 0xef:
+		return false
+	}
+	return true
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// pngMetadata are the chunks of PNG images that only hold metadata.
+var pngMetadata = map[string]bool{"eXIf": true, "tEXt": true, "zTXt": true, "iTXt": true, "tIME": true}
+
+// stripPNG drops the metadata chunks of a PNG image, and anything after its
+// end.
+func stripPNG(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, ErrMalformed
+	}
+	out := append(make([]byte, 0, len(data)), pngSignature...)
+	for i := len(pngSignature); ; {
+		if i+12 > len(data) {
+			return nil, ErrMalformed
+		}
+		n := binary.BigEndian.Uint32(data[i:])
+		if uint64(n) > uint64(len(data)-i-12) {
+			return nil, ErrMalformed
+		}
+		end := i + 12 + int(n)
+		typ := string(data[i+4 : i+8])
+		if crc32.ChecksumIEEE(data[i+4:end-4]) != binary.BigEndian.Uint32(data[end-4:]) {
+			return nil, ErrMalformed
+		}
+		if !pngMetadata[typ] {
+			out = append(out, data[i:end]...)
+		}
+		if typ == "IEND" {
+			return out, nil
+		}
+		i = end
+	}
+}
+
+// stripWebP drops the EXIF and XMP chunks of a WebP image, clearing the flags
+// announcing them in the VP8X chunk of extended images.
+func stripWebP(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, ErrMalformed
+	}
+	size := int(binary.LittleEndian.Uint32(data[4:]))
+	if size < 4 || size > len(data)-8 {
+		return nil, ErrMalformed
+	}
+	data = data[:8+size]
+	out := append(make([]byte, 0, len(data)), data[:12]...)
+	for i := 12; i < len(data); {
+		if i+8 > len(data) {
+			return nil, ErrMalformed
+		}
+		n := binary.LittleEndian.Uint32(data[i+4:])
+		if uint64(n) > uint64(len(data)-i-8) {
+			return nil, ErrMalformed
+		}
+		// Chunks are padded to an even size.
+		end := i + 8 + int(n) + int(n&1)
+		if end > len(data) {
+			end = len(data)
+		}
+		switch string(data[i : i+4]) {
+		case "EXIF", "XMP ":
+			// Dropped.
+		case "VP8X":
+			chunk := append([]byte(nil), data[i:end]...)
+			if len(chunk) > 8 {
+				const exifFlag, xmpFlag = 0x08, 0x04
+				chunk[8] &^= exifFlag | xmpFlag
+			}
+			out = append(out, chunk...)
+		default:
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out, nil
+}
@@ -0,0 +1,150 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package images makes thumbnails of images and strips their metadata, using
+// only the decoders of the standard library.
+package images
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+)
+
+// ThumbnailSize is the maximum width and height of thumbnails.
+const ThumbnailSize = 256
+
+// maxPixels bounds the size of the images thumbnails are made of, as they
+// take several bytes of memory per pixel once decoded.
+const maxPixels = 40 << 20
+
+// ErrUnsupported is returned for images that thumbnails cannot be made of.
+var ErrUnsupported = errors.New("unsupported image")
+
+// ThumbnailType returns the type of the thumbnails of images of contentType,
+// or "" if there is no decoder for it. Photos keep being JPEG, other images
+// are made PNG to keep their transparency.
+func ThumbnailType(contentType string) string {
+	switch contentType {
+	case "image/jpeg":
+		return "image/jpeg"
+	case "image/png", "image/gif":
+		return "image/png"
+	}
+	return ""
+}
+
+// Thumbnail returns a thumbnail of the image in data, of type
+// ThumbnailType(contentType), that fits in ThumbnailSize. Smaller images are
+// not enlarged. Only the first frame of animated GIFs is used.
+func Thumbnail(data []byte, contentType string) ([]byte, error) {
+	var decodeConfig func([]byte) (image.Config, error)
+	var decode func([]byte) (image.Image, error)
+	switch contentType {
+	case "image/jpeg":
+		decodeConfig = func(b []byte) (image.Config, error) { return jpeg.DecodeConfig(bytes.NewReader(b)) }
+		decode = func(b []byte) (image.Image, error) { return jpeg.Decode(bytes.NewReader(b)) }
+	case "image/png":
+		decodeConfig = func(b []byte) (image.Config, error) { return png.DecodeConfig(bytes.NewReader(b)) }
+		decode = func(b []byte) (image.Image, error) { return png.Decode(bytes.NewReader(b)) }
+	case "image/gif":
+		decodeConfig = func(b []byte) (image.Config, error) { return gif.DecodeConfig(bytes.NewReader(b)) }
+		decode = func(b []byte) (image.Image, error) { return gif.Decode(bytes.NewReader(b)) }
+	default:
+		return nil, ErrUnsupported
+	}
+	// Check the dimensions before decoding, so that small files claiming to
+	// be huge images do not exhaust the memory.
+	cfg, err := decodeConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width > maxPixels/cfg.Height {
+		return nil, ErrUnsupported
+	}
+	src, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	thumb := scale(src)
+	var buf bytes.Buffer
+	if ThumbnailType(contentType) == "image/jpeg" {
+		err = jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 85})
+	} else {
+		err = png.Encode(&buf, thumb)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// scale shrinks img to fit in ThumbnailSize, averaging the pixels each pixel
+// of the thumbnail covers.
+func scale(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	tw, th := w, h
+	if w > ThumbnailSize || h > ThumbnailSize {
+		if w >= h {
+			tw, th = ThumbnailSize, h*ThumbnailSize/w
+		} else {
+			tw, th = w*ThumbnailSize/h, ThumbnailSize
+		}
+		if tw < 1 {
+			tw = 1
+		}
+		if th < 1 {
+			th = 1
+		}
+	}
+
+	// Converting the image first is much faster than calling At for each
+	// pixel, and premultiplied colors average correctly.
+	src := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	if tw == w && th == h {
+		return src
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		y0, y1 := y*h/th, (y+1)*h/th
+		for x := 0; x < tw; x++ {
+			x0, x1 := x*w/tw, (x+1)*w/tw
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					sum[0] += int(p[0])
+					sum[1] += int(p[1])
+					sum[2] += int(p[2])
+					sum[3] += int(p[3])
+				}
+			}
+			n := (y1 - y0) * (x1 - x0)
+			d := dst.Pix[y*dst.Stride+x*4:]
+			for i := range sum {
+				d[i] = uint8(sum[i] / n)
+			}
+		}
+	}
+	return dst
+}
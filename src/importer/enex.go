@@ -0,0 +1,65 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package importer
+
+import (
+	"bytes"
+	"encoding/xml"
+	"regexp"
+)
+
+// enexExport is an Evernote export. The content of notes is ENML, a subset of
+// XHTML.
+type enexExport struct {
+	Notes []struct {
+		Title   string   `xml:"title"`
+		Content string   `xml:"content"`
+		Tags    []string `xml:"tag"`
+	} `xml:"note"`
+}
+
+// enexTodo matches the checkboxes of ENML, which are turned into text before
+// the content is sanitized.
+var enexTodo = regexp.MustCompile(`(?i)<en-todo\b([^>]*)/?>(\s*</en-todo>)?`)
+
+var enexChecked = regexp.MustCompile(`(?i)\bchecked\s*=\s*["']?true`)
+
+// parseENEX reads the notes of an Evernote export, and reports whether data
+// is one. Attachments are dropped.
+func parseENEX(r *Result, name string, data []byte) bool {
+	var export enexExport
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	// ENEX files declare a DTD, which the decoder does not load: entities
+	// other than the predefined ones are errors rather than expanded.
+	if err := dec.Decode(&export); err != nil {
+		return false
+	}
+	for _, en := range export.Notes {
+		content := enexTodo.ReplaceAllStringFunc(en.Content, func(todo string) string {
+			if enexChecked.MatchString(todo) {
+				return "[x] "
+			}
+			return "[ ] "
+		})
+		r.add(Note{
+			Source:   name,
+			Title:    en.Title,
+			Text:     htmlToMarkdown(content),
+			Markdown: true,
+			Tags:     en.Tags,
+		})
+	}
+	return true
+}
@@ -0,0 +1,286 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package importer
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+
+	xhtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+
+	"github.com/empijei/go-safeweb-example-app/src/secure/sanitizer"
+)
+
+// htmlToMarkdown converts untrusted HTML to Markdown. It is sanitized first,
+// so only the elements and attributes of the sanitizer allowlist are left to
+// convert, and the Markdown is sanitized again when it is rendered.
+func htmlToMarkdown(src string) string {
+	w := &mdWriter{}
+	z := xhtml.NewTokenizer(strings.NewReader(sanitizer.Sanitize(src).String()))
+	for {
+		tt := z.Next()
+		if tt == xhtml.ErrorToken {
+			break
+		}
+		t := z.Token()
+		switch tt {
+		case xhtml.TextToken:
+			w.text(t.Data)
+		case xhtml.StartTagToken, xhtml.SelfClosingTagToken:
+			w.start(t)
+		case xhtml.EndTagToken:
+			w.end(t.DataAtom)
+		}
+	}
+	return strings.TrimSpace(w.b.String())
+}
+
+// mdWriter writes Markdown for a stream of sanitized HTML tokens.
+type mdWriter struct {
+	b strings.Builder
+	// breaks is the number of line breaks to write before the next text: 1
+	// for a new line, 2 for a new paragraph.
+	breaks int
+	// quotes is the depth of the blockquotes the text is in, and
+	// blankQuotes the depth of the ones the pending blank line is in.
+	quotes, blankQuotes int
+	// lists are the numbers of the next items of the lists the text is in,
+	// or -1 for unordered lists.
+	lists []int
+	// links are the targets of the links the text is in.
+	links []string
+	pre   bool
+	// cells is the number of cells of the current table row so far, and
+	// rows the number of rows of the current table.
+	cells, rows int
+}
+
+// markdownSpecial are escaped in text, so that it is not read as Markdown.
+var markdownSpecial = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`,
+	"<", `\<`, ">", `\>`, "#", `\#`, "|", `\|`, "~", `\~`,
+)
+
+func (w *mdWriter) block() {
+	if w.b.Len() > 0 {
+		w.breaks = 2
+		w.blankQuotes = w.quotes
+	}
+}
+
+func (w *mdWriter) line() {
+	if w.b.Len() > 0 && w.breaks < 1 {
+		w.breaks = 1
+	}
+}
+
+// flush writes the pending line breaks, indenting the new line by indent
+// list levels.
+func (w *mdWriter) flush(indent int) {
+	if w.b.Len() == 0 {
+		// The first line has no break to write the quotes after.
+		w.b.WriteString(strings.Repeat("> ", w.quotes))
+	}
+	for ; w.breaks > 0; w.breaks-- {
+		w.b.WriteString("\n")
+		if w.breaks > 1 {
+			// Blank lines only keep the quotes.
+			w.b.WriteString(strings.TrimSpace(strings.Repeat("> ", w.blankQuotes)))
+			continue
+		}
+		w.b.WriteString(strings.Repeat("> ", w.quotes))
+		if indent > 0 {
+			w.b.WriteString(strings.Repeat("   ", indent))
+		}
+	}
+}
+
+// raw writes Markdown syntax, after any pending line break.
+func (w *mdWriter) raw(s string) {
+	w.flush(len(w.lists))
+	w.b.WriteString(s)
+}
+
+// atLineStart reports whether the next text starts a line, or follows a
+// space.
+func (w *mdWriter) atLineStart() bool {
+	if w.breaks > 0 || w.b.Len() == 0 {
+		return true
+	}
+	s := w.b.String()
+	return s[len(s)-1] == '\n' || s[len(s)-1] == ' '
+}
+
+func (w *mdWriter) text(s string) {
+	if w.pre {
+		for i, l := range strings.Split(s, "\n") {
+			if i > 0 {
+				w.breaks = 1
+			}
+			w.raw(l)
+		}
+		return
+	}
+	// Runs of spaces are a single space in HTML, and the ones between
+	// elements are significant.
+	collapsed := strings.Join(strings.Fields(s), " ")
+	if collapsed == "" {
+		if s != "" && !w.atLineStart() {
+			w.raw(" ")
+		}
+		return
+	}
+	if strings.TrimLeftFunc(s, unicode.IsSpace) != s && !w.atLineStart() {
+		collapsed = " " + collapsed
+	}
+	if strings.TrimRightFunc(s, unicode.IsSpace) != s {
+		collapsed += " "
+	}
+	w.raw(markdownSpecial.Replace(collapsed))
+}
+
+func (w *mdWriter) start(t xhtml.Token) {
+	switch t.DataAtom {
+	case atom.P, atom.Div, atom.Table, atom.Hr:
+		w.block()
+		if t.DataAtom == atom.Table {
+			w.rows = 0
+		}
+		if t.DataAtom == atom.Hr {
+			w.raw("---")
+			w.block()
+		}
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		w.block()
+		w.raw(strings.Repeat("#", int(t.Data[1]-'0')) + " ")
+	case atom.Br:
+		w.breaks = 1
+		if w.b.Len() == 0 {
+			w.breaks = 0
+		}
+	case atom.Blockquote:
+		w.block()
+		w.quotes++
+	case atom.Ul, atom.Ol:
+		if len(w.lists) == 0 {
+			w.block()
+		} else {
+			w.line()
+		}
+		next := -1
+		if t.DataAtom == atom.Ol {
+			next = 1
+		}
+		w.lists = append(w.lists, next)
+	case atom.Li:
+		w.line()
+		marker := "- "
+		n := len(w.lists)
+		if n > 0 && w.lists[n-1] > 0 {
+			marker = strconv.Itoa(w.lists[n-1]) + ". "
+			w.lists[n-1]++
+		}
+		// Items are indented by the lists they are in, but not their own.
+		w.flush(n - 1)
+		w.b.WriteString(marker)
+	case atom.Pre:
+		w.block()
+		w.raw("```")
+		w.breaks = 1
+		w.pre = true
+	case atom.Code:
+		if !w.pre {
+			w.raw("`")
+		}
+	case atom.Strong, atom.B:
+		w.raw("**")
+	case atom.Em, atom.I:
+		w.raw("*")
+	case atom.Del, atom.S:
+		w.raw("~~")
+	case atom.A:
+		href := ""
+		for _, a := range t.Attr {
+			if a.Key == "href" {
+				href = a.Val
+			}
+		}
+		w.links = append(w.links, href)
+		if href != "" {
+			w.raw("[")
+		}
+	case atom.Tr:
+		w.line()
+		w.cells = 0
+	case atom.Td, atom.Th:
+		if w.cells > 0 {
+			w.raw(" | ")
+		}
+		w.cells++
+	}
+}
+
+func (w *mdWriter) end(a atom.Atom) {
+	switch a {
+	case atom.P, atom.Div, atom.Table, atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		w.block()
+	case atom.Blockquote:
+		if w.quotes > 0 {
+			w.quotes--
+		}
+		w.block()
+	case atom.Ul, atom.Ol:
+		if len(w.lists) > 0 {
+			w.lists = w.lists[:len(w.lists)-1]
+		}
+		if len(w.lists) == 0 {
+			w.block()
+		} else {
+			w.line()
+		}
+	case atom.Pre:
+		w.pre = false
+		w.breaks = 1
+		w.raw("```")
+		w.block()
+	case atom.Code:
+		if !w.pre {
+			w.raw("`")
+		}
+	case atom.Strong, atom.B:
+		w.raw("**")
+	case atom.Em, atom.I:
+		w.raw("*")
+	case atom.Del, atom.S:
+		w.raw("~~")
+	case atom.Tr:
+		// The first row is the header of the table, which Markdown needs
+		// to be followed by a delimiter row.
+		if w.rows == 0 && w.cells > 0 {
+			w.line()
+			w.raw(strings.Repeat("--- | ", w.cells-1) + "---")
+		}
+		w.rows++
+	case atom.A:
+		if n := len(w.links); n > 0 {
+			if href := w.links[n-1]; href != "" {
+				w.raw("](" + strings.NewReplacer(" ", "%20", "(", "%28", ")", "%29").Replace(href) + ")")
+			}
+			w.links = w.links[:n-1]
+		}
+	}
+}
@@ -0,0 +1,256 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package importer reads notes exported by this and other note taking
+// applications: zips of Markdown files with front matter, Google Keep notes
+// from Takeout and Evernote ENEX files.
+//
+// The notes are returned for review rather than stored, and none of their
+// content is trusted: HTML is sanitized and converted to Markdown.
+package importer
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+	"unicode"
+
+	"github.com/empijei/go-safeweb-example-app/src/notefile"
+	"github.com/empijei/go-safeweb-example-app/src/storage"
+)
+
+const (
+	// MaxNotes is the maximum number of notes imported at once.
+	MaxNotes = 1000
+	// MaxTextSize is the maximum size of the text of a note.
+	MaxTextSize = 1 << 20
+	// maxUncompressed bounds the total size of the files read from a zip, so
+	// that small zips cannot expand to exhaust the memory.
+	maxUncompressed = 64 << 20
+	// maxTitleLen is the length, in runes, of the titles made of the first
+	// line of notes without one.
+	maxTitleLen = 80
+)
+
+// ErrUnsupported is returned for files that are not in a supported format.
+var ErrUnsupported = errors.New("unsupported file format")
+
+// Note is an imported note.
+type Note struct {
+	// Source is the name of the file the note comes from.
+	Source   string
+	Title    string
+	Text     string
+	Markdown bool
+	// Notebook is the path of the notebook of the note, from the top level.
+	Notebook []string
+	Tags     []string
+	Pinned   bool
+	Archived bool
+	Color    string
+}
+
+// Result is the content of an imported file.
+type Result struct {
+	Notes []Note
+	// Skipped describes the files and notes that could not be imported.
+	Skipped []string
+}
+
+func (r *Result) add(n Note) {
+	if len(r.Notes) == MaxNotes {
+		r.skip(n.Source, fmt.Sprintf("only %d notes can be imported at once", MaxNotes))
+		return
+	}
+	if len(n.Text) > MaxTextSize {
+		r.skip(n.Source, "the note is too large")
+		return
+	}
+	n.Title = cleanTitle(n.Title, n.Text)
+	n.Tags = cleanTags(n.Tags)
+	if !validColor(n.Color) {
+		n.Color = ""
+	}
+	r.Notes = append(r.Notes, n)
+}
+
+func (r *Result) skip(source, reason string) {
+	r.Skipped = append(r.Skipped, source+": "+reason)
+}
+
+// Parse reads the notes in a file named name: a zip of files in the formats
+// below, a Markdown or text file, a Google Keep JSON file or an Evernote ENEX
+// file. Files in a zip that are not notes, e.g. images, are ignored.
+func Parse(name string, data []byte) (*Result, error) {
+	r := &Result{}
+	switch strings.ToLower(path.Ext(name)) {
+	case ".zip":
+		if err := parseZip(r, data); err != nil {
+			return nil, err
+		}
+	default:
+		if !parseFile(r, name, data) {
+			return nil, ErrUnsupported
+		}
+	}
+	return r, nil
+}
+
+func parseZip(r *Result, data []byte) error {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return ErrUnsupported
+	}
+	budget := int64(maxUncompressed)
+	for _, f := range zr.File {
+		name := f.Name
+		base := path.Base(name)
+		if f.FileInfo().IsDir() || strings.HasPrefix(base, ".") || strings.HasPrefix(name, "__MACOSX/") || !supported(name) {
+			continue
+		}
+		if name == "manifest.json" {
+			// The manifest of exports describes the notes next to it.
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			r.skip(name, "the file cannot be read")
+			continue
+		}
+		b, err := ioutil.ReadAll(io.LimitReader(rc, budget+1))
+		rc.Close()
+		if err != nil {
+			r.skip(name, "the file cannot be read")
+			continue
+		}
+		if budget -= int64(len(b)); budget < 0 {
+			return fmt.Errorf("the files in the zip are larger than %d MiB", maxUncompressed>>20)
+		}
+		if !parseFile(r, name, b) {
+			r.skip(name, "the file is not in a supported format")
+		}
+	}
+	return nil
+}
+
+// supported reports whether name has the extension of a supported format.
+func supported(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".md", ".markdown", ".txt", ".json", ".enex":
+		return true
+	}
+	return false
+}
+
+// parseFile adds the notes in a file to r, and reports whether its format
+// is supported.
+func parseFile(r *Result, name string, data []byte) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".md", ".markdown", ".txt":
+		parseNoteFile(r, name, data)
+		return true
+	case ".json":
+		return parseKeep(r, name, data)
+	case ".enex":
+		return parseENEX(r, name, data)
+	}
+	return false
+}
+
+// parseNoteFile reads a Markdown or text file, with front matter as written
+// by exports, or without. Notes without a title in front matter get the name
+// of the file.
+func parseNoteFile(r *Result, name string, data []byte) {
+	fm, text, ok, err := notefile.Parse(data)
+	if err != nil {
+		r.skip(name, "the front matter is not valid")
+		return
+	}
+	ext := path.Ext(name)
+	if !ok {
+		fm.Title = strings.TrimSuffix(path.Base(name), ext)
+		fm.Markdown = !strings.EqualFold(ext, ".txt")
+	}
+	r.add(Note{
+		Source:   name,
+		Title:    fm.Title,
+		Text:     text,
+		Markdown: fm.Markdown,
+		Notebook: fm.Notebook,
+		Tags:     fm.Tags,
+		Pinned:   fm.Pinned,
+		Archived: fm.Archived,
+		Color:    fm.Color,
+	})
+}
+
+// cleanTitle strips control characters from a title, and uses the first line
+// of the text for notes without one.
+func cleanTitle(title, text string) string {
+	title = strings.TrimSpace(strings.Map(dropControl, title))
+	if title != "" {
+		return title
+	}
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(strings.Map(dropControl, line))
+		line = strings.TrimLeft(line, "#>*-+ ")
+		if line == "" {
+			continue
+		}
+		if rs := []rune(line); len(rs) > maxTitleLen {
+			line = string(rs[:maxTitleLen]) + "…"
+		}
+		return line
+	}
+	return "Untitled"
+}
+
+func dropControl(r rune) rune {
+	if unicode.IsControl(r) {
+		return -1
+	}
+	return r
+}
+
+// cleanTags turns labels of other applications into valid tags, replacing
+// spaces with '-', and drops the ones that cannot be.
+func cleanTags(labels []string) []string {
+	seen := map[string]bool{}
+	var tags []string
+	for _, l := range labels {
+		t, err := storage.NormalizeTag(strings.Join(strings.Fields(l), "-"))
+		if err != nil || seen[t] || len(tags) == storage.MaxTags {
+			continue
+		}
+		seen[t] = true
+		tags = append(tags, t)
+	}
+	// Sorts them, they are all valid.
+	tags, _ = storage.NormalizeTags(tags)
+	return tags
+}
+
+func validColor(c string) bool {
+	for _, nc := range storage.NoteColors {
+		if c == nc {
+			return true
+		}
+	}
+	return false
+}
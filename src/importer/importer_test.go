@@ -0,0 +1,213 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package importer
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/empijei/go-safeweb-example-app/src/markdown"
+)
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	b, err := ioutil.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+var (
+	wantKeepNote = Note{
+		Source:   "keep-note.json",
+		Title:    "Groceries",
+		Text:     `Buy \*milk\* & \[eggs\]` + "\n\nSee [the list](https://example.com/a%20list)",
+		Markdown: true,
+		Tags:     []string{"errands", "home-stuff"},
+		Pinned:   true,
+		Color:    "green",
+	}
+	wantKeepList = Note{
+		Source:   "keep-list.json",
+		Title:    "[x] Pack",
+		Text:     "[x] Pack\n[ ] Leave\n",
+		Archived: true,
+	}
+	wantTrip = Note{
+		Source:   "notes.enex",
+		Title:    "Trip",
+		Text:     "Pack:\n\n\\[x\\] passport\n\n\\[ \\] tickets\n\n- Day 1\n   - Museum\n   - Park\n- Day 2",
+		Markdown: true,
+		Tags:     []string{"summer-plans", "travel"},
+	}
+	wantBudget = Note{
+		Source:   "notes.enex",
+		Title:    "Budget",
+		Text:     "Item | Cost\n--- | ---\nHotel \\| 2 nights | 200\n\n> Spend less\n>\n> > really",
+		Markdown: true,
+	}
+)
+
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		file        string
+		want        []Note
+		wantSkipped []string
+	}{
+		{"keep-note.json", []Note{wantKeepNote}, nil},
+		{"keep-list.json", []Note{wantKeepList}, nil},
+		{"keep-trashed.json", nil, []string{"keep-trashed.json: the note is in the trash"}},
+		{"notes.enex", []Note{wantTrip, wantBudget}, nil},
+	} {
+		t.Run(tc.file, func(t *testing.T) {
+			r, err := Parse(tc.file, readFixture(t, tc.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(r.Notes, tc.want) {
+				t.Errorf("Notes = %+v, want %+v", r.Notes, tc.want)
+			}
+			if !reflect.DeepEqual(r.Skipped, tc.wantSkipped) {
+				t.Errorf("Skipped = %q, want %q", r.Skipped, tc.wantSkipped)
+			}
+		})
+	}
+}
+
+func TestParseZip(t *testing.T) {
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	for _, f := range []struct{ name, fixture string }{
+		{"Takeout/Keep/keep-note.json", "keep-note.json"},
+		{"Takeout/Keep/keep-trashed.json", "keep-trashed.json"},
+		{"Takeout/Keep/photo.png", "keep-note.json"},
+		{"__MACOSX/Takeout/Keep/keep-note.json", "keep-note.json"},
+		{"notes.enex", "notes.enex"},
+	} {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(readFixture(t, f.fixture))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := Parse("takeout.zip", b.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var titles []string
+	for _, n := range r.Notes {
+		titles = append(titles, n.Title)
+	}
+	if got, want := strings.Join(titles, ", "), "Groceries, Trip, Budget"; got != want {
+		t.Errorf("Imported %q, want %q", got, want)
+	}
+	if want := []string{"Takeout/Keep/keep-trashed.json: the note is in the trash"}; !reflect.DeepEqual(r.Skipped, want) {
+		t.Errorf("Skipped = %q, want %q", r.Skipped, want)
+	}
+}
+
+func TestParseUnsupported(t *testing.T) {
+	for _, tc := range []struct{ name, data string }{
+		{"note.json", `{"unrelated": true}`},
+		{"note.json", `not json`},
+		{"notes.enex", `<en-export><note><title>&xxe;</title></note></en-export>`},
+		{"photo.png", "\x89PNG"},
+		{"notes.zip", "not a zip"},
+	} {
+		if _, err := Parse(tc.name, []byte(tc.data)); err != ErrUnsupported {
+			t.Errorf("Parse(%q, %q): got err %v, want ErrUnsupported", tc.name, tc.data, err)
+		}
+	}
+}
+
+func TestHTMLToMarkdown(t *testing.T) {
+	for _, tc := range []struct {
+		name, html, want string
+	}{
+		{
+			"special characters",
+			`<p>1*2_3 [a](b) &lt;x&gt; #h |p| ~s~ \ ` + "`c`" + `</p>`,
+			`1\*2\_3 \[a\](b) \<x\> \#h \|p\| \~s\~ \\ ` + "\\`c\\`",
+		},
+		{"heading marker", `<p># not a heading</p>`, `\# not a heading`},
+		{"script", `<p>a<script>alert(1)</script>b</p>`, `ab`},
+		{"link with spaces", `<a href="https://example.com/a (b)">x</a>`, `[x](https://example.com/a%20%28b%29)`},
+		{"unsafe link", `<a href="javascript:alert(1)">x</a>`, `x`},
+		{
+			"nested lists",
+			`<ol><li>a<ol><li>b</li><li>c<ul><li>d</li></ul></li></ol></li><li>e</li></ol><p>f</p>`,
+			"1. a\n   1. b\n   2. c\n      - d\n2. e\n\nf",
+		},
+		{
+			"list in a quote",
+			`<blockquote><ul><li>a<ul><li>b</li></ul></li></ul></blockquote>`,
+			"> - a\n>    - b",
+		},
+		{
+			"quotes",
+			`<blockquote><p>a</p><p>b</p><blockquote>c</blockquote></blockquote><p>d</p>`,
+			"> a\n>\n> b\n>\n> > c\n\nd",
+		},
+		{
+			"table",
+			`<table><tr><th>A</th><th>B</th><th>C</th></tr><tr><td>1</td><td>x|y</td><td></td></tr></table><p>after</p>`,
+			"A | B | C\n--- | --- | ---\n1 | x\\|y | \n\nafter",
+		},
+		{"code block", "<pre>a *b*\n  c</pre>", "```\na *b*\n  c\n```"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := htmlToMarkdown(tc.html); got != tc.want {
+				t.Errorf("htmlToMarkdown(%q) = %q, want %q", tc.html, got, tc.want)
+			}
+		})
+	}
+}
+
+// TestHTMLToMarkdownRenders checks that the Markdown reads back as the HTML
+// it came from.
+func TestHTMLToMarkdownRenders(t *testing.T) {
+	for _, tc := range []struct {
+		html string
+		want []string
+		not  []string
+	}{
+		{`<p>*a* [b](c) &lt;i&gt;</p>`, []string{"*a* [b](c) &lt;i&gt;"}, []string{"<em>", "<a ", "<i>"}},
+		{`<table><tr><th>A</th><th>B</th></tr><tr><td>1</td><td>2</td></tr></table>`, []string{"<table>", "<th>A</th>", "<td>2</td>"}, nil},
+		{`<blockquote><blockquote>a</blockquote></blockquote>`, []string{"<blockquote>\n<blockquote>"}, nil},
+		{`<ul><li>a<ol><li>b</li></ol></li></ul>`, []string{"<ul>\n<li>a\n<ol>\n<li>b</li>"}, nil},
+	} {
+		md := htmlToMarkdown(tc.html)
+		got := markdown.Render(md).String()
+		for _, w := range tc.want {
+			if !strings.Contains(got, w) {
+				t.Errorf("%q converted to %q renders as %q, want it to contain %q", tc.html, md, got, w)
+			}
+		}
+		for _, n := range tc.not {
+			if strings.Contains(got, n) {
+				t.Errorf("%q converted to %q renders as %q, want no %q", tc.html, md, got, n)
+			}
+		}
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package importer
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// keepNote is a note exported by Google Keep with Takeout, which makes a JSON
+// file per note.
+type keepNote struct {
+	Title           string `json:"title"`
+	TextContent     string `json:"textContent"`
+	TextContentHTML string `json:"textContentHtml"`
+	ListContent     []struct {
+		Text      string `json:"text"`
+		IsChecked bool   `json:"isChecked"`
+	} `json:"listContent"`
+	Labels []struct {
+		Name string `json:"name"`
+	} `json:"labels"`
+	Color      string `json:"color"`
+	IsPinned   bool   `json:"isPinned"`
+	IsArchived bool   `json:"isArchived"`
+	IsTrashed  bool   `json:"isTrashed"`
+}
+
+// keepColors maps the colors of Keep to the closest NoteColors.
+var keepColors = map[string]string{
+	"RED":      "red",
+	"ORANGE":   "orange",
+	"YELLOW":   "yellow",
+	"GREEN":    "green",
+	"TEAL":     "green",
+	"BLUE":     "blue",
+	"CERULEAN": "blue",
+	"PURPLE":   "purple",
+	"PINK":     "purple",
+}
+
+// parseKeep reads a Google Keep note, and reports whether data is one.
+// Notes in the trash of Keep are skipped.
+func parseKeep(r *Result, name string, data []byte) bool {
+	var kn keepNote
+	if err := json.Unmarshal(data, &kn); err != nil {
+		return false
+	}
+	if kn.Title == "" && kn.TextContent == "" && kn.TextContentHTML == "" && len(kn.ListContent) == 0 {
+		return false
+	}
+	if kn.IsTrashed {
+		r.skip(name, "the note is in the trash")
+		return true
+	}
+
+	n := Note{
+		Source:   name,
+		Title:    kn.Title,
+		Text:     kn.TextContent,
+		Pinned:   kn.IsPinned,
+		Archived: kn.IsArchived,
+		Color:    keepColors[kn.Color],
+	}
+	switch {
+	case len(kn.ListContent) > 0:
+		var b strings.Builder
+		for _, item := range kn.ListContent {
+			if item.IsChecked {
+				b.WriteString("[x] ")
+			} else {
+				b.WriteString("[ ] ")
+			}
+			b.WriteString(item.Text + "\n")
+		}
+		n.Text = b.String()
+	case kn.TextContentHTML != "":
+		n.Text, n.Markdown = htmlToMarkdown(kn.TextContentHTML), true
+	}
+	for _, l := range kn.Labels {
+		n.Tags = append(n.Tags, l.Name)
+	}
+	r.add(n)
+	return true
+}
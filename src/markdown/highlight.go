@@ -0,0 +1,187 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package markdown
+
+import (
+	"html"
+	"io"
+	"strings"
+)
+
+// language describes the tokens of a programming language well enough to
+// highlight keywords, strings, comments and numbers.
+type language struct {
+	keywords     map[string]bool
+	lineComments []string
+	// blockComment is the start and end of block comments, if any.
+	blockComment [2]string
+	// quotes are the characters that delimit strings. Strings delimited by
+	// backticks can span lines and have no escapes.
+	quotes string
+}
+
+func words(s string) map[string]bool {
+	m := map[string]bool{}
+	for _, w := range strings.Fields(s) {
+		m[w] = true
+	}
+	return m
+}
+
+var (
+	golang = &language{
+		keywords: words(`break case chan const continue default defer else fallthrough for func go goto if
+			import interface map package range return select struct switch type var true false nil iota`),
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       "\"'`",
+	}
+	javascript = &language{
+		keywords: words(`async await break case catch class const continue debugger default delete do else
+			export extends false finally for function if import in instanceof let new null of return
+			static super switch this throw true try typeof undefined var void while yield`),
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       "\"'`",
+	}
+	python = &language{
+		keywords: words(`False None True and as assert async await break class continue def del elif else
+			except finally for from global if import in is lambda nonlocal not or pass raise return try
+			while with yield`),
+		lineComments: []string{"#"},
+		quotes:       "\"'",
+	}
+	shell = &language{
+		keywords:     words(`case do done elif else esac export fi for function if in local return then until while`),
+		lineComments: []string{"#"},
+		quotes:       "\"'",
+	}
+	json = &language{
+		keywords: words(`true false null`),
+		quotes:   "\"",
+	}
+)
+
+// languages maps the names of fenced code blocks to languages.
+var languages = map[string]*language{
+	"go":         golang,
+	"golang":     golang,
+	"js":         javascript,
+	"javascript": javascript,
+	"ts":         javascript,
+	"typescript": javascript,
+	"py":         python,
+	"python":     python,
+	"sh":         shell,
+	"bash":       shell,
+	"shell":      shell,
+	"json":       json,
+}
+
+// highlight writes code as HTML, with the tokens of lang in spans of class
+// hl-kw, hl-str, hl-com and hl-num. Code in unknown languages is only
+// escaped.
+func highlight(w io.Writer, lang, code string) {
+	l := languages[lang]
+	if l == nil {
+		io.WriteString(w, html.EscapeString(code))
+		return
+	}
+	span := func(class, s string) {
+		io.WriteString(w, `<span class="`+class+`">`+html.EscapeString(s)+`</span>`)
+	}
+	for i := 0; i < len(code); {
+		rest := code[i:]
+		if n := l.comment(rest); n > 0 {
+			span("hl-com", rest[:n])
+			i += n
+			continue
+		}
+		c := rest[0]
+		switch {
+		case strings.IndexByte(l.quotes, c) >= 0:
+			n := stringLen(rest)
+			span("hl-str", rest[:n])
+			i += n
+		case isDigit(c) && (i == 0 || !isIdent(code[i-1])):
+			n := 1
+			for n < len(rest) && (isIdent(rest[n]) || rest[n] == '.') {
+				n++
+			}
+			span("hl-num", rest[:n])
+			i += n
+		case isIdent(c):
+			n := 1
+			for n < len(rest) && isIdent(rest[n]) {
+				n++
+			}
+			if l.keywords[rest[:n]] {
+				span("hl-kw", rest[:n])
+			} else {
+				io.WriteString(w, html.EscapeString(rest[:n]))
+			}
+			i += n
+		default:
+			io.WriteString(w, html.EscapeString(rest[:1]))
+			i++
+		}
+	}
+}
+
+// comment returns the length of the comment s starts with, if any.
+func (l *language) comment(s string) int {
+	for _, c := range l.lineComments {
+		if strings.HasPrefix(s, c) {
+			if n := strings.IndexByte(s, '\n'); n >= 0 {
+				return n
+			}
+			return len(s)
+		}
+	}
+	if start, end := l.blockComment[0], l.blockComment[1]; start != "" && strings.HasPrefix(s, start) {
+		if n := strings.Index(s[len(start):], end); n >= 0 {
+			return len(start) + n + len(end)
+		}
+		return len(s)
+	}
+	return 0
+}
+
+// stringLen returns the length of the string literal s starts with. Unclosed
+// strings end with the line.
+func stringLen(s string) int {
+	q := s[0]
+	for i := 1; i < len(s); i++ {
+		switch {
+		case s[i] == q:
+			return i + 1
+		case s[i] == '\\' && q != '`':
+			i++
+		case s[i] == '\n' && q != '`':
+			return i
+		}
+	}
+	return len(s)
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// isIdent reports whether c can be part of an identifier. Bytes of multi-byte
+// characters are, so that they are never split.
+func isIdent(c byte) bool {
+	return c == '_' || isDigit(c) || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c >= 0x80
+}
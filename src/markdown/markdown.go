@@ -0,0 +1,87 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package markdown renders the notes written in Markdown.
+package markdown
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+
+	"github.com/google/safehtml"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/util"
+
+	"github.com/empijei/go-safeweb-example-app/src/secure/sanitizer"
+)
+
+// md renders GitHub Flavored Markdown, without task lists. Raw HTML is not
+// rendered, but the output goes through the sanitizer anyway: it is the only
+// thing the safety of the result relies on.
+var md = goldmark.New(
+	goldmark.WithExtensions(
+		extension.NewTable(extension.WithTableCellAlignMethod(extension.TableCellAlignAttribute)),
+		extension.Strikethrough,
+		extension.Linkify,
+	),
+	goldmark.WithRendererOptions(
+		// Replaces the renderer of fenced code blocks of the default one,
+		// which has priority 1000.
+		renderer.WithNodeRenderers(util.Prioritized(codeRenderer{}, 100)),
+	),
+)
+
+// Render renders src as sanitized HTML.
+func Render(src string) safehtml.HTML {
+	var b bytes.Buffer
+	if err := md.Convert([]byte(src), &b); err != nil {
+		return safehtml.HTMLEscaped(src)
+	}
+	return sanitizer.Sanitize(b.String())
+}
+
+// codeRenderer renders fenced code blocks with syntax highlighting.
+type codeRenderer struct{}
+
+func (codeRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(ast.KindFencedCodeBlock, renderFencedCodeBlock)
+}
+
+var languageName = regexp.MustCompile(`^[a-z0-9+#-]{1,32}$`)
+
+func renderFencedCodeBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*ast.FencedCodeBlock)
+	var code strings.Builder
+	lines := n.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		seg := lines.At(i)
+		code.Write(seg.Value(source))
+	}
+	lang := strings.ToLower(string(n.Language(source)))
+	w.WriteString("<pre><code")
+	if languageName.MatchString(lang) {
+		w.WriteString(` class="language-` + lang + `"`)
+	}
+	w.WriteString(">")
+	highlight(w, lang, code.String())
+	w.WriteString("</code></pre>\n")
+	return ast.WalkSkipChildren, nil
+}
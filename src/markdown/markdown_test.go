@@ -0,0 +1,50 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package markdown
+
+import (
+	"testing"
+
+	"github.com/empijei/go-safeweb-example-app/src/secure/sanitizer/sanitizertest"
+)
+
+func FuzzRender(f *testing.F) {
+	for _, s := range []string{
+		`<script>alert(1)</script>`,
+		"<script>\nalert(1)",
+		`<svg onload=alert(1)>`,
+		"[x](java\tscript:alert(1))",
+		`[x](javascript:alert(1))`,
+		`[x](JAVASCRIPT:alert(1) "title")`,
+		`[x]: javascript:alert(1)` + "\n\n[x]",
+		`<javascript:alert(1)>`,
+		`![x](javascript:alert(1))`,
+		`[<a href="javascript:alert(1)">x</a>](https://example.com)`,
+		`[[x](javascript:alert(1))](https://example.com)`,
+		"<style>\n* { background: red }",
+		"<textarea>\n<script>alert(1)</script>",
+		"```html\n<script>alert(1)</script>\n```",
+		"```\"><script>alert(1)</script>\nx\n```",
+		"| <script>alert(1)</script> |\n|---|\n| x |",
+		`www.example.com/"onmouseover="alert(1)`,
+	} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, src string) {
+		if err := sanitizertest.CheckNoScript(Render(src).String()); err != nil {
+			t.Errorf("Render(%q): %v", src, err)
+		}
+	})
+}
@@ -0,0 +1,80 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package notefile reads and writes notes as Markdown files, with their
+// metadata in YAML front matter, as in exports.
+package notefile
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FrontMatter is the metadata of a note, before its text. Notebook is the
+// path of the notebook of the note, from the top level. Attachments are the
+// paths of its attachments, relative to the file.
+type FrontMatter struct {
+	Title       string   `yaml:"title"`
+	Notebook    []string `yaml:"notebook,omitempty"`
+	Tags        []string `yaml:"tags,omitempty"`
+	Markdown    bool     `yaml:"markdown,omitempty"`
+	Pinned      bool     `yaml:"pinned,omitempty"`
+	Archived    bool     `yaml:"archived,omitempty"`
+	Color       string   `yaml:"color,omitempty"`
+	Attachments []string `yaml:"attachments,omitempty"`
+}
+
+const delimiter = "---\n"
+
+// Write writes a note with its front matter to w.
+func Write(w io.Writer, fm FrontMatter, text string) error {
+	var b bytes.Buffer
+	b.WriteString(delimiter)
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(2)
+	if err := enc.Encode(fm); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+	b.WriteString(delimiter)
+	// Parse drops this newline, so that texts are read back exactly.
+	b.WriteString(text + "\n")
+	_, err := b.WriteTo(w)
+	return err
+}
+
+// Parse returns the front matter and the text of a note written by Write.
+// Files without front matter are returned whole as text, with an empty
+// FrontMatter: ok reports whether there was one.
+func Parse(data []byte) (fm FrontMatter, text string, ok bool, err error) {
+	s := strings.ReplaceAll(string(data), "\r\n", "\n")
+	if !strings.HasPrefix(s, delimiter) {
+		return FrontMatter{}, s, false, nil
+	}
+	end := strings.Index(s[len(delimiter)-1:], "\n"+delimiter)
+	if end < 0 {
+		return FrontMatter{}, "", false, errors.New("front matter is not terminated")
+	}
+	end += len(delimiter) - 1
+	if err := yaml.Unmarshal([]byte(s[len(delimiter):end+1]), &fm); err != nil {
+		return FrontMatter{}, "", false, err
+	}
+	return fm, strings.TrimSuffix(s[end+1+len(delimiter):], "\n"), true, nil
+}
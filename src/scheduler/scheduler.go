@@ -0,0 +1,135 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package scheduler runs background jobs periodically.
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Job is a periodic job. It should return soon after ctx is done, which
+// happens when the scheduler is closed.
+type Job func(ctx context.Context) error
+
+// Scheduler runs jobs periodically, from Start until Close.
+//
+// Jobs do not overlap with themselves: a run that takes longer than the
+// interval delays the next one.
+type Scheduler struct {
+	jobs []*job
+
+	mu      sync.Mutex
+	started bool
+	ctx     context.Context
+	cancel  func()
+	wg      sync.WaitGroup
+}
+
+type job struct {
+	name     string
+	interval time.Duration
+	run      Job
+
+	mu sync.Mutex
+	// err is the error of the last run, if it failed.
+	err error
+}
+
+// New returns a scheduler without jobs.
+func New() *Scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Scheduler{ctx: ctx, cancel: cancel}
+}
+
+// Every adds a job that runs when the scheduler starts and then every
+// interval. Jobs must be added before Start.
+func (s *Scheduler) Every(name string, interval time.Duration, run Job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		panic("scheduler: Every called after Start")
+	}
+	if interval <= 0 {
+		panic(fmt.Sprintf("scheduler: job %q has a non-positive interval", name))
+	}
+	s.jobs = append(s.jobs, &job{name: name, interval: interval, run: run})
+}
+
+// Start starts running the jobs in the background.
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return
+	}
+	s.started = true
+	for _, j := range s.jobs {
+		s.wg.Add(1)
+		go s.loop(j)
+	}
+}
+
+// Close stops scheduling the jobs and waits for the running ones to return.
+func (s *Scheduler) Close() error {
+	s.cancel()
+	s.wg.Wait()
+	return nil
+}
+
+// Check reports whether the scheduler is running and the last run of every
+// job succeeded.
+func (s *Scheduler) Check(ctx context.Context) error {
+	if s.ctx.Err() != nil {
+		return errors.New("scheduler stopped")
+	}
+	for _, j := range s.jobs {
+		j.mu.Lock()
+		err := j.err
+		j.mu.Unlock()
+		if err != nil {
+			return fmt.Errorf("job %q: %v", j.name, err)
+		}
+	}
+	return nil
+}
+
+func (s *Scheduler) loop(j *job) {
+	defer s.wg.Done()
+	t := time.NewTicker(j.interval)
+	defer t.Stop()
+	for {
+		err := j.run(s.ctx)
+		if s.ctx.Err() != nil {
+			// The job was interrupted, its error does not matter.
+			return
+		}
+		if err != nil {
+			log.Printf("Running %s: %v", j.name, err)
+		}
+		j.mu.Lock()
+		j.err = err
+		j.mu.Unlock()
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
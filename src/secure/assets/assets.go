@@ -0,0 +1,244 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package assets serves static files under URLs that contain a hash of their
+// content, so that browsers can cache them forever and still pick up changes
+// as soon as a new version is deployed.
+//
+// A file named "styles.css" is served both as "styles.css" and as, e.g.,
+// "styles.0123456789ab.css". Pages should always link the hashed URL, which
+// they can obtain with the "static" template function.
+package assets
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/safehtml"
+	"github.com/google/safehtml/uncheckedconversions"
+
+	"github.com/empijei/go-safeweb-example-app/src/secure/responses"
+)
+
+const (
+	// hashLen is the number of hex digits of the content hash in a URL.
+	hashLen = 12
+
+	cacheImmutable = "public, max-age=31536000, immutable"
+	// cacheRevalidate is used for the unhashed URLs, which might point to a
+	// different content after the next deployment.
+	cacheRevalidate = "no-cache"
+)
+
+// cssURL matches the references to other assets in a stylesheet.
+var cssURL = regexp.MustCompile(`url\(\s*(["']?)([^"')\s]+)(["']?)\s*\)`)
+
+type asset struct {
+	name        string
+	hashedName  string
+	contentType string
+	etag        string
+	content     []byte
+}
+
+// Manifest maps the files of a file system to their hashed URLs.
+type Manifest struct {
+	prefix string
+	// byName maps logical names, e.g. "styles.css", to assets.
+	byName map[string]*asset
+	// byHashedName maps hashed names, e.g. "styles.0123456789ab.css", to assets.
+	byHashedName map[string]*asset
+}
+
+// New fingerprints all the files in fsys, which will be served under the
+// prefix URL path, e.g. "/static/".
+//
+// Stylesheets must not load resources from other origins, see CheckSameOrigin.
+// References to other files of fsys in stylesheets, e.g.
+// url('/static/fonts/font.ttf'), are rewritten to their hashed URLs. This is
+// not supported for references between stylesheets.
+func New(fsys fs.FS, prefix string) (*Manifest, error) {
+	if !strings.HasPrefix(prefix, "/") || !strings.HasSuffix(prefix, "/") {
+		return nil, fmt.Errorf("prefix %q must start and end with a slash", prefix)
+	}
+	m := &Manifest{
+		prefix:       prefix,
+		byName:       map[string]*asset{},
+		byHashedName: map[string]*asset{},
+	}
+	var stylesheets []string
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		// Stylesheets reference the other assets, so they are hashed last.
+		if path.Ext(name) == ".css" {
+			stylesheets = append(stylesheets, name)
+			return nil
+		}
+		b, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		m.add(name, b)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckSameOrigin(fsys, stylesheets...); err != nil {
+		return nil, err
+	}
+	for _, name := range stylesheets {
+		b, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		if b, err = m.rewriteCSS(b); err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		m.add(name, b)
+	}
+	return m, nil
+}
+
+// MustNew is like New but panics on errors. It is meant to be used to
+// initialize package variables.
+func MustNew(fsys fs.FS, prefix string) *Manifest {
+	m, err := New(fsys, prefix)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+func (m *Manifest) add(name string, content []byte) {
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+	ext := path.Ext(name)
+	a := &asset{
+		name:        name,
+		hashedName:  strings.TrimSuffix(name, ext) + "." + hash[:hashLen] + ext,
+		contentType: mime.TypeByExtension(ext),
+		etag:        `"` + hash + `"`,
+		content:     content,
+	}
+	if a.contentType == "" {
+		a.contentType = http.DetectContentType(content)
+	}
+	m.byName[a.name] = a
+	m.byHashedName[a.hashedName] = a
+}
+
+func (m *Manifest) rewriteCSS(css []byte) ([]byte, error) {
+	var err error
+	out := cssURL.ReplaceAllFunc(css, func(ref []byte) []byte {
+		sub := cssURL.FindSubmatch(ref)
+		u := string(sub[2])
+		if !strings.HasPrefix(u, m.prefix) {
+			return ref
+		}
+		a, ok := m.byName[strings.TrimPrefix(u, m.prefix)]
+		if !ok {
+			err = fmt.Errorf("reference to unknown asset %q", u)
+			return ref
+		}
+		return []byte("url(" + string(sub[1]) + m.prefix + a.hashedName + string(sub[3]) + ")")
+	})
+	return out, err
+}
+
+// URL returns the hashed URL of the named file.
+func (m *Manifest) URL(name string) (safehtml.TrustedResourceURL, error) {
+	a, ok := m.byName[name]
+	if !ok {
+		return safehtml.TrustedResourceURL{}, fmt.Errorf("unknown asset %q", name)
+	}
+	// The URL is made of a prefix provided by the application and the name of
+	// a file it embeds, so it is under application control.
+	return uncheckedconversions.TrustedResourceURLFromStringKnownToSatisfyTypeContract(m.prefix + a.hashedName), nil
+}
+
+// FuncMap returns the template functions that resolve asset names:
+//
+//   - static: returns the hashed URL of a file, e.g. {{static "styles.css"}}.
+func (m *Manifest) FuncMap() map[string]interface{} {
+	return map[string]interface{}{
+		"static": m.URL,
+	}
+}
+
+// Handler serves the files. It must be registered for the prefix path of the
+// manifest.
+//
+// Hashed URLs are cacheable forever, the others have to be revalidated. Both
+// support conditional requests with ETags.
+func (m *Manifest) Handler() safehttp.Handler {
+	return safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		name := strings.TrimPrefix(r.URL.Path(), m.prefix)
+		cache := cacheImmutable
+		a, ok := m.byHashedName[name]
+		if !ok {
+			cache = cacheRevalidate
+			a, ok = m.byName[name]
+		}
+		if !ok {
+			return w.WriteError(safehttp.StatusNotFound)
+		}
+		h := w.Header()
+		h.Set("Cache-Control", cache)
+		h.Set("ETag", a.etag)
+		if etagMatches(r.Header.Get("If-None-Match"), a.etag) {
+			return w.Write(responses.NotModified{})
+		}
+		return w.Write(Response{asset: a})
+	})
+}
+
+// etagMatches reports whether an If-None-Match header matches etag, using the
+// weak comparison of RFC 7232, section 2.3.2.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, t := range strings.Split(ifNoneMatch, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// Response is the content of an asset (as recognized by the
+// secure.dispatcher).
+type Response struct {
+	// private, to only allow serving the files of a Manifest.
+	asset *asset
+}
+
+// ContentType is the Content-Type of the response.
+func (r Response) ContentType() string {
+	return r.asset.contentType
+}
+
+// Content is the body of the response.
+func (r Response) Content() []byte {
+	return r.asset.content
+}
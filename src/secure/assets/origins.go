@@ -0,0 +1,55 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package assets
+
+import (
+	"fmt"
+	"io/fs"
+	"regexp"
+)
+
+var (
+	htmlComment = regexp.MustCompile(`(?s)<!--.*?-->`)
+	cssComment  = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	// externalRef matches URL attributes and CSS urls that point to another
+	// origin, either with an absolute or a scheme-relative URL.
+	externalRef = regexp.MustCompile(`(?i)(?:\b(?:src|href|action|formaction|poster|srcset|data)\s*=\s*|url\()\s*["']?\s*(?:[a-z][a-z0-9+.-]*:)?//[^"'\s)>]*`)
+)
+
+// CheckSameOrigin returns an error if a file in fsys matching one of patterns
+// loads resources from another origin.
+//
+// Pages must work offline, not leak visits to third parties and comply with a
+// strict CSP, so every resource has to be served by the application itself.
+func CheckSameOrigin(fsys fs.FS, patterns ...string) error {
+	for _, p := range patterns {
+		names, err := fs.Glob(fsys, p)
+		if err != nil {
+			return err
+		}
+		for _, n := range names {
+			b, err := fs.ReadFile(fsys, n)
+			if err != nil {
+				return err
+			}
+			// Comments are not loaded, and contain links to the license.
+			src := cssComment.ReplaceAll(htmlComment.ReplaceAll(b, nil), nil)
+			if ref := externalRef.Find(src); ref != nil {
+				return fmt.Errorf("%s references another origin: %s", n, ref)
+			}
+		}
+	}
+	return nil
+}
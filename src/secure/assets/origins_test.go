@@ -0,0 +1,61 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package assets
+
+import (
+	"io/fs"
+	"os"
+	"testing"
+	"testing/fstest"
+)
+
+func TestSameOrigin(t *testing.T) {
+	tests := []struct {
+		name     string
+		dir      string
+		patterns []string
+	}{
+		{"server templates", "../../server", []string{"templates/*.tpl.html"}},
+		{"secure templates", "../templates", []string{"*.tpl.html"}},
+		{"static files", "../../static", []string{"*.css", "*.js"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := os.DirFS(tt.dir)
+			for _, p := range tt.patterns {
+				// A pattern that no longer matches would silently check nothing.
+				if names, err := fs.Glob(fsys, p); err != nil || len(names) == 0 {
+					t.Fatalf("fs.Glob(%q, %q) = %v, %v, want some files", tt.dir, p, names, err)
+				}
+			}
+			if err := CheckSameOrigin(fsys, tt.patterns...); err != nil {
+				t.Errorf("The files must only load resources from this origin: %v", err)
+			}
+		})
+	}
+}
+
+func TestSameOriginRejects(t *testing.T) {
+	for _, src := range []string{
+		`<script src="https://cdn.example.com/x.js"></script>`,
+		`<link href='//fonts.example.com/css' rel=stylesheet>`,
+		`body { background: url(https://example.com/bg.png) }`,
+	} {
+		fsys := fstest.MapFS{"page.tpl.html": {Data: []byte(src)}}
+		if err := CheckSameOrigin(fsys, "*.tpl.html"); err == nil {
+			t.Errorf("CheckSameOrigin(%q) = nil, want an error", src)
+		}
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"github.com/google/go-safeweb/safehttp"
 	"github.com/google/safehtml/template"
 
+	"github.com/empijei/go-safeweb-example-app/src/secure/metrics"
 	"github.com/empijei/go-safeweb-example-app/src/secure/responses"
 	"github.com/empijei/go-safeweb-example-app/src/storage"
 )
@@ -35,6 +36,11 @@ var unauthMsg = template.MustParseAndExecuteToHTML(`Please <a href="/">login</a>
 // E.g. to clear a user session call ClearSession.
 type Interceptor struct {
 	DB *storage.DB
+
+	// ClientCerts enables authentication with TLS client certificates. The
+	// Common Name of a verified certificate identifies the user, who must
+	// already exist. Session cookies take precedence over certificates.
+	ClientCerts bool
 }
 
 // Before runs before the request is passed to the handler.
@@ -44,6 +50,9 @@ type Interceptor struct {
 func (ip Interceptor) Before(w safehttp.ResponseWriter, r *safehttp.IncomingRequest, cfg safehttp.InterceptorConfig) safehttp.Result {
 	// Identify the user.
 	user := ip.userFromCookie(r)
+	if user == "" && ip.ClientCerts {
+		user = ip.userFromCert(r)
+	}
 	if user != "" {
 		r.SetContext(ctxWithUser(r.Context(), user))
 	}
@@ -55,6 +64,7 @@ func (ip Interceptor) Before(w safehttp.ResponseWriter, r *safehttp.IncomingRequ
 
 	if user == "" {
 		// We have to perform auth, and the user was not identified, bail out.
+		metrics.CountRejection("auth")
 		return w.WriteError(responses.Error{
 			StatusCode: safehttp.StatusUnauthorized,
 			Message:    unauthMsg,
@@ -100,6 +110,18 @@ func (ip Interceptor) userFromCookie(r *safehttp.IncomingRequest) string {
 	return user
 }
 
+func (ip Interceptor) userFromCert(r *safehttp.IncomingRequest) string {
+	// Only chains verified against the configured client CAs can be trusted.
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return ""
+	}
+	user := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	if user == "" || !ip.DB.HasUser(user) {
+		return ""
+	}
+	return user
+}
+
 // ClearSession clears the session.
 //
 // Implementation details: to interact with the interceptor, passes data through
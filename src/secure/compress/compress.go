@@ -0,0 +1,302 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package compress compresses responses with gzip.
+//
+// Compressing a response that contains both a secret and data controlled by an
+// attacker leaks the secret through the size of the response, see
+// http://breachattack.com/. Responses that contain XSRF tokens, and those of
+// handlers configured with Secret, are therefore only compressed when
+// length-hiding padding is enabled.
+package compress
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/go-safeweb/safehttp/plugins/htmlinject"
+)
+
+// maxPadding is the maximum number of bytes added to hide the length of
+// compressed responses that contain secrets.
+const maxPadding = 256
+
+// compressible are the content types worth compressing. Images, fonts other
+// than TrueType and archives are already compressed.
+var compressible = map[string]bool{
+	"application/javascript": true,
+	"application/json":       true,
+	"font/ttf":               true,
+	"image/svg+xml":          true,
+	"text/css":               true,
+	"text/html":              true,
+	"text/javascript":        true,
+	"text/plain":             true,
+}
+
+// Options configures the compression.
+type Options struct {
+	// MinSize is the size under which responses are not compressed, as the
+	// savings would not be worth the overhead.
+	MinSize int
+	// Padding enables the compression of responses that contain secrets, with
+	// a random amount of padding to hide their length. This makes BREACH
+	// attacks slower, not impossible.
+	Padding bool
+}
+
+type ctxKey struct{}
+
+// decision is shared between Handler, which compresses the response, and
+// Interceptor, which knows what the response contains.
+type decision struct {
+	secret bool
+}
+
+// Handler compresses the responses of h for clients that accept it.
+//
+// Secret responses are only detected if h is a safehttp.ServeMux that has an
+// Interceptor installed. Without it, all the responses are considered secret.
+func Handler(h http.Handler, opts Options) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			w.Header().Add("Vary", "Accept-Encoding")
+			h.ServeHTTP(w, r)
+			return
+		}
+		d := &decision{secret: true}
+		cw := &compressWriter{ResponseWriter: w, opts: opts, decision: d, code: http.StatusOK}
+		defer cw.close()
+		h.ServeHTTP(cw, r.WithContext(context.WithValue(r.Context(), ctxKey{}, d)))
+	})
+}
+
+// acceptsGzip reports whether an Accept-Encoding header accepts gzip.
+func acceptsGzip(acceptEncoding string) bool {
+	for _, e := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := mime.ParseMediaType(strings.TrimSpace(e))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		q, ok := params["q"]
+		if !ok {
+			return true
+		}
+		// A weight of 0 means "not acceptable".
+		w, err := strconv.ParseFloat(q, 64)
+		return err == nil && w > 0
+	}
+	return false
+}
+
+// compressWriter buffers the beginning of a response until it knows whether
+// to compress it.
+type compressWriter struct {
+	http.ResponseWriter
+	opts     Options
+	decision *decision
+
+	code        int
+	wroteHeader bool
+	// decided is set once the headers have been sent.
+	decided bool
+	buf     []byte
+	gz      *gzip.Writer
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+	cw.code = code
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	cw.WriteHeader(http.StatusOK)
+	if !cw.decided {
+		if len(cw.buf)+len(b) < cw.opts.MinSize && cw.eligible() {
+			cw.buf = append(cw.buf, b...)
+			return len(b), nil
+		}
+		if err := cw.decide(cw.eligible()); err != nil {
+			return 0, err
+		}
+	}
+	if cw.gz != nil {
+		return cw.gz.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// Flush sends what was written so far, e.g. for streamed responses.
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		cw.WriteHeader(http.StatusOK)
+		if err := cw.decide(cw.eligible()); err != nil {
+			return
+		}
+	}
+	if cw.gz != nil {
+		cw.gz.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets the handler take over the connection, e.g. for WebSockets.
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := cw.ResponseWriter.(http.Hijacker)
+	if !ok || cw.decided {
+		return nil, nil, errors.New("hijacking is not supported")
+	}
+	cw.decided = true
+	return hj.Hijack()
+}
+
+// eligible reports whether the response could be compressed, regardless of
+// its size.
+func (cw *compressWriter) eligible() bool {
+	h := cw.Header()
+	ct, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	switch {
+	case cw.code < 200 || cw.code == http.StatusNoContent || cw.code == http.StatusNotModified:
+		return false
+	case h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "":
+		return false
+	case !compressible[ct]:
+		return false
+	case cw.decision.secret && !cw.opts.Padding:
+		return false
+	}
+	return true
+}
+
+// decide sends the headers, and sets up the compression if compress is set.
+func (cw *compressWriter) decide(compress bool) error {
+	cw.decided = true
+	h := cw.Header()
+	ct, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	if compressible[ct] {
+		// The response could be compressed for another request.
+		h.Add("Vary", "Accept-Encoding")
+	}
+	if compress {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			// The compressed content is not byte-for-byte the same.
+			h.Set("ETag", "W/"+etag)
+		}
+		gz := gzip.NewWriter(cw.ResponseWriter)
+		if cw.decision.secret {
+			pad, err := padding()
+			if err != nil {
+				return err
+			}
+			// Clients skip the comment of the gzip header, so it pads the
+			// response without changing its content.
+			gz.Header.Comment = pad
+		}
+		cw.gz = gz
+	}
+	cw.ResponseWriter.WriteHeader(cw.code)
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	buf := cw.buf
+	cw.buf = nil
+	var err error
+	if cw.gz != nil {
+		_, err = cw.gz.Write(buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+func (cw *compressWriter) close() {
+	if !cw.decided {
+		if !cw.wroteHeader {
+			// The handler panicked or did not write anything, let net/http
+			// handle it.
+			return
+		}
+		// Responses smaller than MinSize are sent as they are.
+		cw.decide(false)
+	}
+	if cw.gz != nil {
+		cw.gz.Close()
+	}
+}
+
+// padding returns between 1 and maxPadding random bytes.
+func padding() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(maxPadding))
+	if err != nil {
+		return "", err
+	}
+	return strings.Repeat(" ", int(n.Int64())+1), nil
+}
+
+// Interceptor detects the responses that contain secrets, so that Handler
+// does not compress them.
+//
+// It must be installed before the XSRF interceptor, as Commit runs in the
+// reverse order and has to see the XSRF token of the response.
+type Interceptor struct{}
+
+var _ safehttp.Interceptor = Interceptor{}
+
+// Before runs before the request is passed to the handler.
+func (Interceptor) Before(w safehttp.ResponseWriter, r *safehttp.IncomingRequest, cfg safehttp.InterceptorConfig) safehttp.Result {
+	return safehttp.NotWritten()
+}
+
+// Commit runs after the handler commited to a response.
+func (Interceptor) Commit(w safehttp.ResponseHeadersWriter, r *safehttp.IncomingRequest, resp safehttp.Response, cfg safehttp.InterceptorConfig) {
+	d, ok := r.Context().Value(ctxKey{}).(*decision)
+	if !ok {
+		return
+	}
+	if _, ok := cfg.(Secret); ok {
+		d.secret = true
+		return
+	}
+	d.secret = false
+	if tr, ok := resp.(*safehttp.TemplateResponse); ok {
+		_, d.secret = tr.FuncMap[htmlinject.XSRFTokensDefaultFuncName]
+	}
+}
+
+// Secret marks the responses of a handler as containing secrets, so that they
+// are only compressed with padding.
+type Secret struct{}
+
+// Match matches the compression interceptor.
+func (Secret) Match(i safehttp.Interceptor) bool {
+	_, ok := i.(Interceptor)
+	return ok
+}
@@ -0,0 +1,33 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secure
+
+import (
+	"github.com/google/go-safeweb/safehttp"
+)
+
+// corpInterceptor sets the Cross-Origin-Resource-Policy header, so that other
+// origins cannot embed our responses, e.g. to leak them with side channels.
+// See https://resourcepolicy.fyi/.
+type corpInterceptor struct{}
+
+func (corpInterceptor) Before(w safehttp.ResponseWriter, r *safehttp.IncomingRequest, cfg safehttp.InterceptorConfig) safehttp.Result {
+	set := w.Header().Claim("Cross-Origin-Resource-Policy")
+	set([]string{"same-origin"})
+	return safehttp.NotWritten()
+}
+
+func (corpInterceptor) Commit(w safehttp.ResponseHeadersWriter, r *safehttp.IncomingRequest, resp safehttp.Response, cfg safehttp.InterceptorConfig) {
+}
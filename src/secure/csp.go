@@ -0,0 +1,124 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secure
+
+import (
+	"strings"
+
+	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/go-safeweb/safehttp/plugins/csp"
+
+	"github.com/empijei/go-safeweb-example-app/src/config"
+)
+
+const (
+	// cspReportPath is where browsers send CSP violation reports.
+	cspReportPath = "/csp-report"
+	// cspReportGroup is the name of the Reporting API endpoint for cspReportPath.
+	cspReportGroup = "csp-endpoint"
+	// ttPolicyName is the only Trusted Types policy that scripts are
+	// allowed to create. It is defined in src/static/trusted-types.js.
+	ttPolicyName = "notekeeper"
+)
+
+// newCSPInterceptor creates a CSP interceptor that enforces a strict and a
+// framing policy, requires Trusted Types according to ttMode, and asks
+// browsers to report violations to cspReportPath. PublicPage endpoints get
+// publicPagePolicy instead, and Download endpoints downloadPolicy: these allow
+// no script at all, but still require Trusted Types so that every response
+// does.
+func newCSPInterceptor(ttMode config.TrustedTypesMode) cspInterceptor {
+	it := csp.Interceptor{
+		Enforce: []csp.Policy{
+			reportToPolicy{csp.StrictPolicy{ReportURI: cspReportPath}},
+			reportToPolicy{csp.FramingPolicy{ReportURI: cspReportPath}},
+		},
+	}
+	withTT := func(it csp.Interceptor) csp.Interceptor {
+		tt := reportToPolicy{trustedTypesPolicy{}}
+		if ttMode == config.TrustedTypesReportOnly {
+			it.ReportOnly = append(it.ReportOnly, tt)
+		} else {
+			it.Enforce = append(it.Enforce, tt)
+		}
+		return it
+	}
+	return cspInterceptor{
+		Interceptor: withTT(it),
+		public: withTT(csp.Interceptor{
+			Enforce: []csp.Policy{reportToPolicy{publicPagePolicy{}}},
+		}),
+		download: withTT(csp.Interceptor{
+			Enforce: []csp.Policy{reportToPolicy{downloadPolicy{}}},
+		}),
+	}
+}
+
+// cspInterceptor wraps the CSP interceptor, which cannot be configured per
+// handler, so that PublicPage and Download endpoints get stricter policies.
+type cspInterceptor struct {
+	csp.Interceptor
+	public   csp.Interceptor
+	download csp.Interceptor
+}
+
+func (it cspInterceptor) Before(w safehttp.ResponseWriter, r *safehttp.IncomingRequest, cfg safehttp.InterceptorConfig) safehttp.Result {
+	switch cfg.(type) {
+	case PublicPage:
+		return it.public.Before(w, r, nil)
+	case Download:
+		return it.download.Before(w, r, nil)
+	}
+	return it.Interceptor.Before(w, r, nil)
+}
+
+func (it cspInterceptor) Commit(w safehttp.ResponseHeadersWriter, r *safehttp.IncomingRequest, resp safehttp.Response, cfg safehttp.InterceptorConfig) {
+	// Both set the nonce, which templates use regardless of the policy.
+	it.Interceptor.Commit(w, r, resp, nil)
+}
+
+// trustedTypesPolicy requires Trusted Types for DOM XSS sinks, and only allows
+// to create the ttPolicyName policy. See
+// https://w3c.github.io/trusted-types/dist/spec/.
+//
+// Unlike csp.TrustedTypesPolicy it restricts policy names, so that scripts
+// cannot create a permissive policy to bypass the checks.
+type trustedTypesPolicy struct{}
+
+func (trustedTypesPolicy) Serialize(nonce string) string {
+	return "require-trusted-types-for 'script'; trusted-types " + ttPolicyName + "; report-uri " + cspReportPath
+}
+
+// reportToPolicy adds the report-to directive to a policy, so that browsers
+// that support the Reporting API use it instead of the deprecated report-uri.
+type reportToPolicy struct {
+	csp.Policy
+}
+
+func (p reportToPolicy) Serialize(nonce string) string {
+	return strings.TrimSuffix(p.Policy.Serialize(nonce), ";") + "; report-to " + cspReportGroup
+}
+
+// reportingEndpoints declares the Reporting API endpoints used by the
+// policies of newCSPInterceptor.
+type reportingEndpoints struct{}
+
+func (reportingEndpoints) Before(w safehttp.ResponseWriter, r *safehttp.IncomingRequest, cfg safehttp.InterceptorConfig) safehttp.Result {
+	w.Header().Set("Reporting-Endpoints", cspReportGroup+`="`+cspReportPath+`"`)
+	return safehttp.NotWritten()
+}
+
+func (reportingEndpoints) Commit(w safehttp.ResponseHeadersWriter, r *safehttp.IncomingRequest, resp safehttp.Response, cfg safehttp.InterceptorConfig) {
+}
@@ -15,27 +15,80 @@
 package secure
 
 import (
+	"io"
+	"log"
+	"mime"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/google/go-safeweb/safehttp"
 
+	"github.com/empijei/go-safeweb-example-app/src/secure/assets"
 	"github.com/empijei/go-safeweb-example-app/src/secure/responses"
+	"github.com/empijei/go-safeweb-example-app/src/secure/sse"
 	"github.com/empijei/go-safeweb-example-app/src/secure/templates"
+	"github.com/empijei/go-safeweb-example-app/src/secure/websocket"
 )
 
 // dispatcher is a custom dispatcher implementation. See
 // https://pkg.go.dev/github.com/google/go-safeweb/safehttp#hdr-Dispatcher.
 type dispatcher struct {
-	// No need for a Write method, the default dispatcher knows how to write all
-	// non-error responses we use in this project.
 	safehttp.DefaultDispatcher
+	// maxStream is how long event streams last, 0 for no limit.
+	maxStream time.Duration
+}
+
+func (d dispatcher) Write(rw http.ResponseWriter, resp safehttp.Response) error {
+	switch x := resp.(type) {
+	case responses.Text:
+		rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, err := io.WriteString(rw, x.Body)
+		return err
+	case responses.NotModified:
+		rw.WriteHeader(http.StatusNotModified)
+		return nil
+	case sse.Stream:
+		return x.Serve(rw, d.maxStream)
+	case websocket.Upgrade:
+		return x.Serve(rw)
+	case assets.Response:
+		rw.Header().Set("Content-Type", x.ContentType())
+		_, err := rw.Write(x.Content())
+		return err
+	case responses.Download:
+		defer x.Body.Close()
+		h := rw.Header()
+		h.Set("Content-Type", x.ContentType)
+		// FormatMediaType quotes the name, and encodes it if it is not ASCII.
+		h.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": x.Name}))
+		if x.Size > 0 {
+			h.Set("Content-Length", strconv.FormatInt(x.Size, 10))
+		}
+		// Downloads fail midway when the client goes away, or the blob store
+		// fails: the headers are written already, so there is no error to
+		// send, and errors returned to safehttp make it panic.
+		if _, err := io.Copy(rw, x.Body); err != nil {
+			log.Printf("Interrupted download of %q: %v", x.Name, err)
+		}
+		return nil
+	}
+	// The default dispatcher knows how to write all the other non-error
+	// responses we use in this project.
+	return d.DefaultDispatcher.Write(rw, resp)
 }
 
 func (d dispatcher) Error(rw http.ResponseWriter, resp safehttp.ErrorResponse) error {
-	if ce, ok := resp.(responses.Error); ok {
+	switch ce := resp.(type) {
+	case responses.Error:
 		rw.Header().Set("Content-Type", "text/html; charset=utf-8")
 		rw.WriteHeader(int(ce.Code()))
 		return templates.All.ExecuteTemplate(rw, "error.tpl.html", ce.Message)
+	case responses.TextError:
+		rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		rw.WriteHeader(int(ce.Code()))
+		_, err := io.WriteString(rw, ce.Body)
+		return err
 	}
 	// Calling the default dispatcher in case we have no custom responses that match.
 	// This is strongly advised.
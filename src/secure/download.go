@@ -0,0 +1,62 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secure
+
+import (
+	"github.com/google/go-safeweb/safehttp"
+)
+
+// Download marks an endpoint that serves files uploaded by users, see
+// responses.Download. Uploads might be HTML or SVG despite the checks on
+// their content, so in case a browser renders one:
+//   - it gets a CSP that sandboxes it in a unique origin and denies loading
+//     anything (see downloadPolicy), so it cannot run scripts in this origin;
+//   - it is not cached, so that losing access to the note takes effect;
+//   - it does not send its URL as the referrer.
+//
+// X-Content-Type-Options: nosniff is set on all responses by the
+// staticheaders plugin.
+type Download struct{}
+
+func (Download) Match(i safehttp.Interceptor) bool {
+	switch i.(type) {
+	case downloadInterceptor, cspInterceptor:
+		return true
+	}
+	return false
+}
+
+// downloadInterceptor sets the headers of Download endpoints.
+type downloadInterceptor struct{}
+
+func (downloadInterceptor) Before(w safehttp.ResponseWriter, r *safehttp.IncomingRequest, cfg safehttp.InterceptorConfig) safehttp.Result {
+	if _, ok := cfg.(Download); !ok {
+		return safehttp.NotWritten()
+	}
+	h := w.Header()
+	h.Set("Referrer-Policy", "no-referrer")
+	h.Set("Cache-Control", "private, no-store")
+	return safehttp.NotWritten()
+}
+
+func (downloadInterceptor) Commit(w safehttp.ResponseHeadersWriter, r *safehttp.IncomingRequest, resp safehttp.Response, cfg safehttp.InterceptorConfig) {
+}
+
+// downloadPolicy is the CSP of Download endpoints.
+type downloadPolicy struct{}
+
+func (downloadPolicy) Serialize(nonce string) string {
+	return "sandbox; default-src 'none'; base-uri 'none'; form-action 'none'; frame-ancestors 'none'; report-uri " + cspReportPath
+}
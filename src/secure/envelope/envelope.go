@@ -0,0 +1,230 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package envelope encrypts data at rest with envelope encryption.
+//
+// Data is encrypted with AES-GCM under per-user data keys, which are stored
+// with it encrypted ("wrapped") by a master key. Master keys come from the
+// configuration and are never stored with the data, so a copy of the storage
+// alone reveals nothing. Deleting the wrapped data key of a user makes all
+// their data unreadable, even in backups ("crypto-shredding").
+package envelope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrUnknownMaster is returned for data keys wrapped by a master key that is
+// not in the Keyring.
+var ErrUnknownMaster = errors.New("data key wrapped by an unknown master key")
+
+// ErrDecrypt is returned for data that cannot be decrypted: it was encrypted
+// with another key or for another purpose, or tampered with.
+var ErrDecrypt = errors.New("cannot decrypt data")
+
+// Domains separate the uses of keys.
+const (
+	masterDomain   = "notekeeper master key\x00"
+	masterIDDomain = "notekeeper master key id\x00"
+	wrapDomain     = "notekeeper data key\x00"
+	encryptDomain  = "encrypt"
+	indexDomain    = "index"
+)
+
+// dataKeyLen is the length of data keys, in bytes.
+const dataKeyLen = 32
+
+type masterKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// Keyring holds the master keys. The first one wraps new data keys, the
+// others only unwrap the data keys they wrapped until these are wrapped again
+// by the first one, see Rewrap. This lets master keys be rotated.
+type Keyring struct {
+	current *masterKey
+	byID    map[string]*masterKey
+}
+
+// NewKeyring returns a Keyring of master keys derived from secrets, the
+// current one first.
+func NewKeyring(secrets []string) (*Keyring, error) {
+	if len(secrets) == 0 {
+		return nil, errors.New("no master key")
+	}
+	k := &Keyring{byID: map[string]*masterKey{}}
+	for _, s := range secrets {
+		key := sha256.Sum256([]byte(masterDomain + s))
+		aead, err := newAEAD(key[:])
+		if err != nil {
+			return nil, err
+		}
+		id := sha256.Sum256([]byte(masterIDDomain + s))
+		mk := &masterKey{id: hex.EncodeToString(id[:8]), aead: aead}
+		if _, ok := k.byID[mk.id]; ok {
+			return nil, errors.New("duplicate master key")
+		}
+		k.byID[mk.id] = mk
+		if k.current == nil {
+			k.current = mk
+		}
+	}
+	return k, nil
+}
+
+// WrappedKey is a data key encrypted by a master key, safe to store with the
+// data it encrypts.
+type WrappedKey struct {
+	// Master is the ID of the master key that wrapped the data key.
+	Master string
+	Sealed []byte
+}
+
+// NewDataKey returns a new random data key for owner, and the key wrapped by
+// the current master key.
+func (k *Keyring) NewDataKey(owner string) (*DataKey, WrappedKey, error) {
+	raw := make([]byte, dataKeyLen)
+	if _, err := io.ReadFull(rand.Reader, raw); err != nil {
+		return nil, WrappedKey{}, err
+	}
+	dk, err := newDataKey(raw)
+	if err != nil {
+		return nil, WrappedKey{}, err
+	}
+	w := WrappedKey{Master: k.current.id, Sealed: seal(k.current.aead, raw, wrapAD(owner))}
+	return dk, w, nil
+}
+
+// Unwrap returns the data key of owner wrapped in w.
+func (k *Keyring) Unwrap(owner string, w WrappedKey) (*DataKey, error) {
+	raw, err := k.unwrap(owner, w)
+	if err != nil {
+		return nil, err
+	}
+	return newDataKey(raw)
+}
+
+// Rewrap returns w wrapped by the current master key, and whether it was
+// wrapped by another one.
+func (k *Keyring) Rewrap(owner string, w WrappedKey) (WrappedKey, bool, error) {
+	if w.Master == k.current.id {
+		return w, false, nil
+	}
+	raw, err := k.unwrap(owner, w)
+	if err != nil {
+		return w, false, err
+	}
+	return WrappedKey{Master: k.current.id, Sealed: seal(k.current.aead, raw, wrapAD(owner))}, true, nil
+}
+
+func (k *Keyring) unwrap(owner string, w WrappedKey) ([]byte, error) {
+	mk, ok := k.byID[w.Master]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownMaster, w.Master)
+	}
+	raw, err := open(mk.aead, w.Sealed, wrapAD(owner))
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) != dataKeyLen {
+		return nil, ErrDecrypt
+	}
+	return raw, nil
+}
+
+// wrapAD binds wrapped keys to their owner, so that they cannot be swapped
+// between users.
+func wrapAD(owner string) []byte {
+	return []byte(wrapDomain + owner)
+}
+
+// DataKey encrypts the data of a user.
+type DataKey struct {
+	aead  cipher.AEAD
+	index []byte
+}
+
+func newDataKey(raw []byte) (*DataKey, error) {
+	aead, err := newAEAD(derive(raw, encryptDomain))
+	if err != nil {
+		return nil, err
+	}
+	return &DataKey{aead: aead, index: derive(raw, indexDomain)}, nil
+}
+
+// Seal encrypts plaintext. The additional data ad is authenticated but not
+// encrypted: the same must be passed to Open, e.g. to bind the ciphertext to
+// the record and field it is stored in.
+func (d *DataKey) Seal(plaintext, ad []byte) []byte {
+	return seal(d.aead, plaintext, ad)
+}
+
+// Open decrypts data encrypted by Seal.
+func (d *DataKey) Open(sealed, ad []byte) ([]byte, error) {
+	return open(d.aead, sealed, ad)
+}
+
+// Index returns a blind index of s: a keyed hash that can be stored to look
+// up data by s, e.g. in the keys of a map, without revealing s.
+func (d *DataKey) Index(s string) string {
+	m := hmac.New(sha256.New, d.index)
+	m.Write([]byte(s))
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil))
+}
+
+func derive(key []byte, domain string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(domain))
+	return m.Sum(nil)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plaintext with a random nonce, which is prepended to the
+// ciphertext.
+func seal(aead cipher.AEAD, plaintext, ad []byte) []byte {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		panic("envelope: cannot generate a nonce: " + err.Error())
+	}
+	return aead.Seal(nonce, nonce, plaintext, ad)
+}
+
+func open(aead cipher.AEAD, sealed, ad []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	n := aead.NonceSize()
+	plaintext, err := aead.Open(nil, sealed[:n], sealed[n:], ad)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,171 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envelope
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// streamChunk is the size of the chunks of plaintext that streams are
+// sealed in.
+const streamChunk = 64 << 10
+
+// StreamKey encrypts a single stream of data that is too large to be sealed
+// at once, like a file, in chunks of streamChunk bytes. Chunks are numbered,
+// and the last one is marked, so that they cannot be reordered, dropped or
+// truncated undetected.
+//
+// A StreamKey is random and must encrypt a single stream, as the nonces of
+// chunks are their numbers. It can decrypt it any number of times.
+type StreamKey struct {
+	aead cipher.AEAD
+}
+
+// NewStreamKey returns a new random StreamKey. It is not wrapped by a master
+// key: data encrypted by it can only be read as long as it is kept, e.g. in
+// memory for temporary files.
+func NewStreamKey() (*StreamKey, error) {
+	raw := make([]byte, dataKeyLen)
+	if _, err := io.ReadFull(rand.Reader, raw); err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &StreamKey{aead: aead}, nil
+}
+
+func (k *StreamKey) nonce(n uint64, last bool) []byte {
+	nonce := make([]byte, k.aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce, n)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+// Encrypt returns a writer that encrypts data to w. It must be closed to
+// write the last chunk.
+func (k *StreamKey) Encrypt(w io.Writer) io.WriteCloser {
+	return &streamWriter{k: k, w: w, buf: make([]byte, 0, streamChunk)}
+}
+
+type streamWriter struct {
+	k   *StreamKey
+	w   io.Writer
+	buf []byte
+	n   uint64
+}
+
+func (s *streamWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		// A full chunk is only sealed once more data follows, as the last one
+		// is marked.
+		if len(s.buf) == streamChunk {
+			if err := s.seal(false); err != nil {
+				return written, err
+			}
+		}
+		c := copy(s.buf[len(s.buf):streamChunk], p)
+		s.buf = s.buf[:len(s.buf)+c]
+		p = p[c:]
+		written += c
+	}
+	return written, nil
+}
+
+func (s *streamWriter) seal(last bool) error {
+	_, err := s.w.Write(s.k.aead.Seal(nil, s.k.nonce(s.n, last), s.buf, nil))
+	s.buf = s.buf[:0]
+	s.n++
+	return err
+}
+
+// Close writes the last chunk, which may be empty. It does not close the
+// underlying writer.
+func (s *streamWriter) Close() error {
+	return s.seal(true)
+}
+
+// Decrypt returns a reader that decrypts data encrypted by Encrypt from r. It
+// returns ErrDecrypt if the data was not encrypted by k, or was tampered with
+// or truncated.
+func (k *StreamKey) Decrypt(r io.Reader) io.Reader {
+	return &streamReader{k: k, r: r, buf: make([]byte, 0, streamChunk+k.aead.Overhead()+1)}
+}
+
+type streamReader struct {
+	k *StreamKey
+	r io.Reader
+	// buf holds the next chunk as read, and the first byte of the one after
+	// it, if any.
+	buf       []byte
+	plaintext []byte
+	n         uint64
+	done      bool
+	err       error
+}
+
+func (s *streamReader) Read(p []byte) (int, error) {
+	for len(s.plaintext) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+		if s.done {
+			return 0, io.EOF
+		}
+		s.err = s.next()
+	}
+	n := copy(p, s.plaintext)
+	s.plaintext = s.plaintext[n:]
+	return n, nil
+}
+
+// next decrypts the next chunk. A chunk is the last one if no data follows
+// it.
+func (s *streamReader) next() error {
+	full := streamChunk + s.k.aead.Overhead()
+	read, err := io.ReadFull(s.r, s.buf[len(s.buf):full+1])
+	s.buf = s.buf[:len(s.buf)+read]
+	last := false
+	switch {
+	case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+		last = true
+	case err != nil:
+		return err
+	}
+	sealed := s.buf
+	if !last {
+		sealed = s.buf[:full]
+	}
+	plaintext, err := s.k.aead.Open(nil, s.k.nonce(s.n, last), sealed, nil)
+	if err != nil {
+		return ErrDecrypt
+	}
+	if last {
+		s.done = true
+	} else {
+		s.buf = append(s.buf[:0], s.buf[full])
+	}
+	s.plaintext = plaintext
+	s.n++
+	return nil
+}
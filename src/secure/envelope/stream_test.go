@@ -0,0 +1,95 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envelope
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func encryptStream(t *testing.T, k *StreamKey, plaintext []byte) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	w := k.Encrypt(&b)
+	// Small writes, to cross chunk boundaries within writes.
+	for p := plaintext; len(p) > 0; {
+		n := 1000
+		if n > len(p) {
+			n = len(p)
+		}
+		if _, err := w.Write(p[:n]); err != nil {
+			t.Fatal(err)
+		}
+		p = p[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	k, err := NewStreamKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, size := range []int{0, 1, streamChunk - 1, streamChunk, streamChunk + 1, 3*streamChunk + 5} {
+		plaintext := make([]byte, size)
+		rand.Read(plaintext)
+		sealed := encryptStream(t, k, plaintext)
+		got, err := ioutil.ReadAll(k.Decrypt(bytes.NewReader(sealed)))
+		if err != nil || !bytes.Equal(got, plaintext) {
+			t.Errorf("Decrypting %d bytes: got %d bytes, err %v", size, len(got), err)
+		}
+	}
+}
+
+func TestStreamRejectsTampering(t *testing.T) {
+	k, err := NewStreamKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext := make([]byte, 2*streamChunk+10)
+	sealed := encryptStream(t, k, plaintext)
+	chunk := streamChunk + k.aead.Overhead()
+	other, err := NewStreamKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flipped := append([]byte{}, sealed...)
+	flipped[chunk+10] ^= 1
+	for _, tc := range []struct {
+		name   string
+		k      *StreamKey
+		sealed []byte
+	}{
+		{"other key", other, sealed},
+		{"flipped bit", k, flipped},
+		{"truncated at a chunk", k, sealed[:2*chunk]},
+		{"truncated in a chunk", k, sealed[:len(sealed)-1]},
+		{"dropped chunk", k, append(append([]byte{}, sealed[:chunk]...), sealed[2*chunk:]...)},
+		{"swapped chunks", k, append(append(append([]byte{}, sealed[chunk:2*chunk]...), sealed[:chunk]...), sealed[2*chunk:]...)},
+		{"empty", k, nil},
+	} {
+		if _, err := io.Copy(ioutil.Discard, tc.k.Decrypt(bytes.NewReader(tc.sealed))); !errors.Is(err, ErrDecrypt) {
+			t.Errorf("Decrypting with %s: got err %v, want ErrDecrypt", tc.name, err)
+		}
+	}
+}
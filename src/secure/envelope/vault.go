@@ -0,0 +1,231 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envelope
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrNoKey is returned for users that have no data key, and thus no data.
+var ErrNoKey = errors.New("no data key")
+
+// KeyStore stores the wrapped data keys of users, usually in the storage that
+// holds the data they encrypt. It never sees unwrapped keys.
+type KeyStore interface {
+	// GetKey returns the wrapped data key of user, if any.
+	GetKey(user string) (w WrappedKey, ok bool, err error)
+	// PutKey stores the wrapped data key of user, replacing any other.
+	PutKey(user string, w WrappedKey) error
+	// DeleteKey deletes the wrapped data key of user, if any.
+	DeleteKey(user string) error
+	// Users returns the users that have a data key.
+	Users() ([]string, error)
+}
+
+// Vault encrypts the data of users with their data keys, which it creates,
+// unwraps and wraps again as needed. Data is encrypted by field, and looked up
+// by blind indexes called refs, so that any storage can hold it without being
+// able to read it.
+//
+// It is safe for concurrent use.
+type Vault struct {
+	keys  *Keyring
+	store KeyStore
+
+	mu sync.Mutex
+	// user -> data key, cached
+	unwrapped map[string]*DataKey
+}
+
+// NewVault returns a Vault that stores in store the data keys of users,
+// wrapped by keys.
+func NewVault(keys *Keyring, store KeyStore) *Vault {
+	return &Vault{keys: keys, store: store, unwrapped: map[string]*DataKey{}}
+}
+
+// dataKey returns the data key of user. If user has none, it creates one if
+// create is set, and returns ErrNoKey otherwise.
+func (v *Vault) dataKey(user string, create bool) (*DataKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if dk, ok := v.unwrapped[user]; ok {
+		return dk, nil
+	}
+	w, ok, err := v.store.GetKey(user)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		dk, err := v.keys.Unwrap(user, w)
+		if err != nil {
+			return nil, err
+		}
+		v.unwrapped[user] = dk
+		return dk, nil
+	}
+	if !create {
+		return nil, ErrNoKey
+	}
+	dk, w, err := v.keys.NewDataKey(user)
+	if err != nil {
+		return nil, err
+	}
+	if err := v.store.PutKey(user, w); err != nil {
+		return nil, err
+	}
+	v.unwrapped[user] = dk
+	return dk, nil
+}
+
+// Ref returns the blind index of s for user, to look up their data by s. It
+// returns ErrNoKey if user has no data key.
+func (v *Vault) Ref(user, s string) (string, error) {
+	dk, err := v.dataKey(user, false)
+	if err != nil {
+		return "", err
+	}
+	return dk.Index(s), nil
+}
+
+// NewRef is like Ref, but creates the data key of user if they have none. It
+// is used to store the first data of a user.
+func (v *Vault) NewRef(user, s string) (string, error) {
+	dk, err := v.dataKey(user, true)
+	if err != nil {
+		return "", err
+	}
+	return dk.Index(s), nil
+}
+
+// Seal encrypts a field of the data of user stored under ref. The ciphertext
+// is bound to ref and field, so that it cannot be moved to another record or
+// field of the storage. It returns ErrNoKey if user has no data key, see
+// NewRef.
+func (v *Vault) Seal(user, ref, field string, plaintext []byte) ([]byte, error) {
+	dk, err := v.dataKey(user, false)
+	if err != nil {
+		return nil, err
+	}
+	return dk.Seal(plaintext, fieldAD(ref, field)), nil
+}
+
+// Open decrypts a field sealed by Seal. It returns ErrDecrypt if it was not
+// sealed by user for ref and field, or was tampered with.
+func (v *Vault) Open(user, ref, field string, sealed []byte) ([]byte, error) {
+	dk, err := v.dataKey(user, false)
+	if err != nil {
+		return nil, err
+	}
+	return dk.Open(sealed, fieldAD(ref, field))
+}
+
+// fieldAD is the additional data of a field stored under ref.
+func fieldAD(ref, field string) []byte {
+	return []byte(ref + "\x00" + field)
+}
+
+// Forget deletes the data key of user. This makes all their data unreadable
+// in any copy of the storage, like backups, even if it is not deleted
+// ("crypto-shredding").
+func (v *Vault) Forget(user string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.unwrapped, user)
+	return v.store.DeleteKey(user)
+}
+
+// RewrapKeys wraps the data keys that were wrapped by an older master key
+// with the current one, see Keyring. It returns the number of keys wrapped
+// again and the last error, if any: the other keys are still processed.
+func (v *Vault) RewrapKeys() (int, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	users, err := v.store.Users()
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	var lastErr error
+	for _, user := range users {
+		changed, err := v.rewrapLocked(user)
+		if err != nil {
+			lastErr = fmt.Errorf("rewrapping the data key of %q: %w", user, err)
+			continue
+		}
+		if changed {
+			n++
+		}
+	}
+	return n, lastErr
+}
+
+// rewrapLocked wraps the data key of user with the current master key, and
+// reports whether it was wrapped by another one.
+func (v *Vault) rewrapLocked(user string) (bool, error) {
+	w, ok, err := v.store.GetKey(user)
+	if err != nil || !ok {
+		return false, err
+	}
+	w, changed, err := v.keys.Rewrap(user, w)
+	if err != nil || !changed {
+		return false, err
+	}
+	return true, v.store.PutKey(user, w)
+}
+
+// MemoryKeyStore is a KeyStore that keeps keys in memory, for storages that
+// are in memory too.
+type MemoryKeyStore struct {
+	mu   sync.Mutex
+	keys map[string]WrappedKey
+}
+
+// NewMemoryKeyStore returns an empty MemoryKeyStore.
+func NewMemoryKeyStore() *MemoryKeyStore {
+	return &MemoryKeyStore{keys: map[string]WrappedKey{}}
+}
+
+func (m *MemoryKeyStore) GetKey(user string) (WrappedKey, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w, ok := m.keys[user]
+	return w, ok, nil
+}
+
+func (m *MemoryKeyStore) PutKey(user string, w WrappedKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys[user] = w
+	return nil
+}
+
+func (m *MemoryKeyStore) DeleteKey(user string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.keys, user)
+	return nil
+}
+
+func (m *MemoryKeyStore) Users() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	users := make([]string, 0, len(m.keys))
+	for u := range m.keys {
+		users = append(users, u)
+	}
+	return users, nil
+}
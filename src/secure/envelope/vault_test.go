@@ -0,0 +1,134 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envelope
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func newVault(t *testing.T, store KeyStore, secrets ...string) *Vault {
+	t.Helper()
+	keys, err := NewKeyring(secrets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewVault(keys, store)
+}
+
+// sealFirst stores the first data of user under the ref of s.
+func sealFirst(t *testing.T, v *Vault, user, s, field string, plaintext []byte) (ref string, sealed []byte) {
+	t.Helper()
+	ref, err := v.NewRef(user, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err = v.Seal(user, ref, field, plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ref, sealed
+}
+
+func TestVaultRoundTrip(t *testing.T) {
+	v := newVault(t, NewMemoryKeyStore(), "master")
+	if _, err := v.Ref("alice", "note"); !errors.Is(err, ErrNoKey) {
+		t.Errorf("Ref() before any data: got err %v, want ErrNoKey", err)
+	}
+	if _, err := v.Seal("alice", "ref", "text", []byte("x")); !errors.Is(err, ErrNoKey) {
+		t.Errorf("Seal() before NewRef: got err %v, want ErrNoKey", err)
+	}
+
+	ref, sealed := sealFirst(t, v, "alice", "note", "text", []byte("secret"))
+	if bytes.Contains(sealed, []byte("secret")) {
+		t.Errorf("Seal() = %q, contains the plaintext", sealed)
+	}
+	if got, err := v.Ref("alice", "note"); err != nil || got != ref {
+		t.Errorf("Ref() = %q, %v, want %q, nil", got, err, ref)
+	}
+	got, err := v.Open("alice", ref, "text", sealed)
+	if err != nil || string(got) != "secret" {
+		t.Errorf("Open() = %q, %v, want %q, nil", got, err, "secret")
+	}
+}
+
+func TestVaultRejectsSwappedData(t *testing.T) {
+	v := newVault(t, NewMemoryKeyStore(), "master")
+	ref, sealed := sealFirst(t, v, "alice", "note", "text", []byte("secret"))
+	otherRef, _ := sealFirst(t, v, "alice", "other note", "text", []byte("other"))
+	sealFirst(t, v, "bob", "note", "text", []byte("bob's"))
+
+	for _, tc := range []struct {
+		name             string
+		user, ref, field string
+	}{
+		{"other field", "alice", ref, "title"},
+		{"other record", "alice", otherRef, "text"},
+		{"other user", "bob", ref, "text"},
+	} {
+		if _, err := v.Open(tc.user, tc.ref, tc.field, sealed); !errors.Is(err, ErrDecrypt) {
+			t.Errorf("Open() in %s: got err %v, want ErrDecrypt", tc.name, err)
+		}
+	}
+}
+
+func TestVaultRewrapKeys(t *testing.T) {
+	store := NewMemoryKeyStore()
+	v := newVault(t, store, "old")
+	ref, sealed := sealFirst(t, v, "alice", "note", "text", []byte("secret"))
+
+	// The new master key is added first, the old one kept until the keys are
+	// wrapped again.
+	v = newVault(t, store, "new", "old")
+	n, err := v.RewrapKeys()
+	if err != nil || n != 1 {
+		t.Fatalf("RewrapKeys() = %d, %v, want 1, nil", n, err)
+	}
+	if n, err := v.RewrapKeys(); err != nil || n != 0 {
+		t.Errorf("RewrapKeys() again = %d, %v, want 0, nil", n, err)
+	}
+
+	v = newVault(t, store, "new")
+	if got, err := v.Open("alice", ref, "text", sealed); err != nil || string(got) != "secret" {
+		t.Errorf("Open() without the old master key = %q, %v, want %q, nil", got, err, "secret")
+	}
+
+	// Keys that were not wrapped again are lost with the old master key.
+	sealFirst(t, newVault(t, store, "old"), "bob", "note", "text", []byte("bob's"))
+	if _, err := newVault(t, store, "new").RewrapKeys(); !errors.Is(err, ErrUnknownMaster) {
+		t.Errorf("RewrapKeys() without the old master key: got err %v, want ErrUnknownMaster", err)
+	}
+}
+
+func TestVaultForget(t *testing.T) {
+	store := NewMemoryKeyStore()
+	v := newVault(t, store, "master")
+	ref, sealed := sealFirst(t, v, "alice", "note", "text", []byte("secret"))
+	if err := v.Forget("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if users, _ := store.Users(); len(users) != 0 {
+		t.Errorf("Users() after Forget() = %q, want none", users)
+	}
+	if _, err := v.Open("alice", ref, "text", sealed); !errors.Is(err, ErrNoKey) {
+		t.Errorf("Open() after Forget(): got err %v, want ErrNoKey", err)
+	}
+	// A new account with the same name gets another key.
+	sealFirst(t, v, "alice", "note", "text", []byte("new"))
+	if _, err := v.Open("alice", ref, "text", sealed); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Open() with a new key: got err %v, want ErrDecrypt", err)
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-safeweb/safehttp"
+
+	"github.com/empijei/go-safeweb-example-app/src/secure/responses"
+)
+
+var (
+	requests = NewCounterVec(
+		"notekeeper_http_requests_total",
+		"Number of HTTP requests served, by route pattern and status code.",
+		"route", "code",
+	)
+	latency = NewHistogramVec(
+		"notekeeper_http_request_duration_seconds",
+		"Time spent serving HTTP requests, by route pattern and status code.",
+		DefBuckets,
+		"route", "code",
+	)
+	rejections = NewCounterVec(
+		"notekeeper_interceptor_rejections_total",
+		"Number of requests rejected by an interceptor before reaching the handler.",
+		"interceptor",
+	)
+)
+
+// unmatchedRoute is the route label of requests that were not served by a
+// handler registered with a Route, e.g. the method not allowed handler.
+const unmatchedRoute = "none"
+
+type ctxKey struct{}
+
+// observation is shared between Handler, which measures the request, and
+// Interceptor, which knows what it was routed to.
+type observation struct {
+	route string
+}
+
+// Handler instruments h, recording the number and latency of requests.
+//
+// The route label is only known if h is a safehttp.ServeMux that has an
+// Interceptor installed.
+func Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		o := &observation{route: unmatchedRoute}
+		sw := &statusWriter{ResponseWriter: w, code: http.StatusOK}
+		h.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), ctxKey{}, o)))
+
+		code := strconv.Itoa(sw.code)
+		requests.Inc(o.route, code)
+		latency.Observe(time.Since(start).Seconds(), o.route, code)
+	})
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	code        int
+	wroteHeader bool
+}
+
+func (sw *statusWriter) WriteHeader(code int) {
+	if !sw.wroteHeader {
+		sw.code = code
+		sw.wroteHeader = true
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	sw.wroteHeader = true
+	return sw.ResponseWriter.Write(b)
+}
+
+// Flush sends what was written so far, e.g. for streamed responses.
+func (sw *statusWriter) Flush() {
+	if f, ok := sw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets the handler take over the connection, e.g. for WebSockets.
+func (sw *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := sw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijacking is not supported")
+	}
+	sw.code = http.StatusSwitchingProtocols
+	sw.wroteHeader = true
+	return hj.Hijack()
+}
+
+// Interceptor labels the requests measured by Handler with the pattern of the
+// handler they were routed to.
+//
+// It should be installed before any other interceptor, so that it runs even
+// for requests that other interceptors reject.
+type Interceptor struct{}
+
+var _ safehttp.Interceptor = Interceptor{}
+
+// Before runs before the request is passed to the handler.
+func (Interceptor) Before(w safehttp.ResponseWriter, r *safehttp.IncomingRequest, cfg safehttp.InterceptorConfig) safehttp.Result {
+	rt, ok := cfg.(Route)
+	if !ok {
+		return safehttp.NotWritten()
+	}
+	if o, ok := r.Context().Value(ctxKey{}).(*observation); ok {
+		o.route = string(rt)
+	}
+	return safehttp.NotWritten()
+}
+
+// Commit runs after the handler commited to a response.
+func (Interceptor) Commit(w safehttp.ResponseHeadersWriter, r *safehttp.IncomingRequest, resp safehttp.Response, cfg safehttp.InterceptorConfig) {
+}
+
+// Route is the pattern a handler was registered with. It is used as the route
+// label of the requests it serves.
+type Route string
+
+// Match matches the metrics interceptor.
+func (Route) Match(i safehttp.Interceptor) bool {
+	_, ok := i.(Interceptor)
+	return ok
+}
+
+// CountRejection records that the named interceptor rejected a request.
+func CountRejection(interceptor string) {
+	rejections.Inc(interceptor)
+}
+
+// CountRejections wraps it so that every response it writes is counted as a
+// rejection by the named interceptor.
+//
+// Interceptor configs match against the returned interceptor, not it, so only
+// wrap interceptors that are not configured per handler.
+func CountRejections(name string, it safehttp.Interceptor) safehttp.Interceptor {
+	return rejectionCounter{name: name, Interceptor: it}
+}
+
+type rejectionCounter struct {
+	safehttp.Interceptor
+	name string
+}
+
+func (rc rejectionCounter) Before(w safehttp.ResponseWriter, r *safehttp.IncomingRequest, cfg safehttp.InterceptorConfig) safehttp.Result {
+	return rc.Interceptor.Before(rejectionWriter{ResponseWriter: w, name: rc.name}, r, cfg)
+}
+
+type rejectionWriter struct {
+	safehttp.ResponseWriter
+	name string
+}
+
+func (rw rejectionWriter) Write(resp safehttp.Response) safehttp.Result {
+	CountRejection(rw.name)
+	return rw.ResponseWriter.Write(resp)
+}
+
+func (rw rejectionWriter) WriteError(resp safehttp.ErrorResponse) safehttp.Result {
+	CountRejection(rw.name)
+	return rw.ResponseWriter.WriteError(resp)
+}
+
+// Serve returns a handler that exposes all the registered metrics in the
+// Prometheus text format.
+func Serve() safehttp.Handler {
+	return safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		var b strings.Builder
+		WriteText(&b)
+		return w.Write(responses.Text{Body: b.String()})
+	})
+}
@@ -0,0 +1,284 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metrics collects application metrics and exposes them in the
+// Prometheus text format. See
+// https://prometheus.io/docs/instrumenting/exposition_formats/.
+//
+// This is a deliberately small implementation that only supports the metric
+// types this application needs. All metrics are registered in a single,
+// package-level registry when they are created.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type collector interface {
+	name() string
+	write(w io.Writer)
+}
+
+var registry = struct {
+	mu         sync.Mutex
+	collectors []collector
+}{}
+
+func register(c collector) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	for _, rc := range registry.collectors {
+		if rc.name() == c.name() {
+			panic(fmt.Sprintf("metric %q registered twice", c.name()))
+		}
+	}
+	registry.collectors = append(registry.collectors, c)
+}
+
+// WriteText writes all the registered metrics to w in the Prometheus text
+// format.
+func WriteText(w io.Writer) {
+	registry.mu.Lock()
+	cs := make([]collector, len(registry.collectors))
+	copy(cs, registry.collectors)
+	registry.mu.Unlock()
+
+	for _, c := range cs {
+		c.write(w)
+	}
+}
+
+type desc struct {
+	n, help, typ string
+	labels       []string
+}
+
+func (d desc) name() string {
+	return d.n
+}
+
+func (d desc) writeHeader(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.n, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(d.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.n, d.typ)
+}
+
+func (d desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metric %q: got %d label values, want %d", d.n, len(values), len(d.labels)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labelPairs formats names and values as a Prometheus label set, with extra
+// appended verbatim (it is used for the histogram "le" label).
+func labelPairs(names, values []string, extra string) string {
+	if len(names) == 0 && extra == "" {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, n := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, n, labelEscaper.Replace(values[i]))
+	}
+	if extra != "" {
+		if len(names) > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(extra)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, +1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// CounterVec is a set of monotonically increasing counters partitioned by
+// label values.
+type CounterVec struct {
+	desc
+	mu     sync.Mutex
+	values map[string]*counterValue
+}
+
+type counterValue struct {
+	labels []string
+	v      float64
+}
+
+// NewCounterVec creates and registers a counter with the given label names.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		desc:   desc{n: name, help: help, typ: "counter", labels: labels},
+		values: map[string]*counterValue{},
+	}
+	register(c)
+	return c
+}
+
+// Inc increments the counter identified by labelValues.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v, which must not be negative, to the counter identified by
+// labelValues.
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic(fmt.Sprintf("metric %q: counters cannot decrease", c.n))
+	}
+	k := c.key(labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cv, ok := c.values[k]
+	if !ok {
+		cv = &counterValue{labels: append([]string(nil), labelValues...)}
+		c.values[k] = cv
+	}
+	cv.v += v
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeHeader(w)
+	ks := make([]string, 0, len(c.values))
+	for k := range c.values {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	for _, k := range ks {
+		cv := c.values[k]
+		fmt.Fprintf(w, "%s%s %s\n", c.n, labelPairs(c.labels, cv.labels, ""), formatFloat(cv.v))
+	}
+}
+
+// DefBuckets are the default latency buckets, in seconds.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// HistogramVec is a set of histograms partitioned by label values.
+type HistogramVec struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	values  map[string]*histogramValue
+}
+
+type histogramValue struct {
+	labels []string
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// NewHistogramVec creates and registers a histogram with the given upper
+// bucket bounds, which must be sorted, and label names.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if !sort.Float64sAreSorted(buckets) {
+		panic(fmt.Sprintf("metric %q: buckets are not sorted", name))
+	}
+	h := &HistogramVec{
+		desc:    desc{n: name, help: help, typ: "histogram", labels: labels},
+		buckets: buckets,
+		values:  map[string]*histogramValue{},
+	}
+	register(h)
+	return h
+}
+
+// Observe adds v to the histogram identified by labelValues.
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	k := h.key(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hv, ok := h.values[k]
+	if !ok {
+		hv = &histogramValue{
+			labels: append([]string(nil), labelValues...),
+			counts: make([]uint64, len(h.buckets)),
+		}
+		h.values[k] = hv
+	}
+	for i, b := range h.buckets {
+		if v <= b {
+			hv.counts[i]++
+		}
+	}
+	hv.sum += v
+	hv.count++
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.writeHeader(w)
+	ks := make([]string, 0, len(h.values))
+	for k := range h.values {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	for _, k := range ks {
+		hv := h.values[k]
+		for i, b := range h.buckets {
+			le := fmt.Sprintf(`le="%s"`, formatFloat(b))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.n, labelPairs(h.labels, hv.labels, le), hv.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.n, labelPairs(h.labels, hv.labels, `le="+Inf"`), hv.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.n, labelPairs(h.labels, hv.labels, ""), formatFloat(hv.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.n, labelPairs(h.labels, hv.labels, ""), hv.count)
+	}
+}
+
+// GaugeFunc is a gauge whose value is computed when metrics are collected.
+type GaugeFunc struct {
+	desc
+	f func() float64
+}
+
+// NewGaugeFunc creates and registers a gauge that reports the value of f.
+//
+// f is called on every scrape, so it should be cheap.
+func NewGaugeFunc(name, help string, f func() float64) *GaugeFunc {
+	g := &GaugeFunc{
+		desc: desc{n: name, help: help, typ: "gauge"},
+		f:    f,
+	}
+	register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	g.writeHeader(w)
+	fmt.Fprintf(w, "%s %s\n", g.n, formatFloat(g.f()))
+}
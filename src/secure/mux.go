@@ -15,29 +15,150 @@
 package secure
 
 import (
+	"net/http"
+
 	"github.com/google/go-safeweb/safehttp"
 	"github.com/google/go-safeweb/safehttp/plugins/coop"
-	"github.com/google/go-safeweb/safehttp/plugins/csp"
 	"github.com/google/go-safeweb/safehttp/plugins/fetchmetadata"
 	"github.com/google/go-safeweb/safehttp/plugins/hostcheck"
 	"github.com/google/go-safeweb/safehttp/plugins/hsts"
 	"github.com/google/go-safeweb/safehttp/plugins/staticheaders"
 	"github.com/google/go-safeweb/safehttp/plugins/xsrf/xsrfhtml"
 
+	"github.com/empijei/go-safeweb-example-app/src/config"
+	"github.com/empijei/go-safeweb-example-app/src/health"
 	"github.com/empijei/go-safeweb-example-app/src/secure/auth"
+	"github.com/empijei/go-safeweb-example-app/src/secure/compress"
+	"github.com/empijei/go-safeweb-example-app/src/secure/metrics"
+	"github.com/empijei/go-safeweb-example-app/src/secure/reports"
 	"github.com/empijei/go-safeweb-example-app/src/storage"
 )
 
+// MuxConfig is a safe ServeMuxConfig that instruments all of its handlers.
+type MuxConfig struct {
+	*safehttp.ServeMuxConfig
+	// compression is nil if responses are not compressed.
+	compression *compress.Options
+	// maxBodyBytes is the maximum size of request bodies.
+	maxBodyBytes int64
+	routes       []Route
+}
+
+// Route is a registered pattern and method.
+type Route struct {
+	Pattern, Method string
+}
+
+// Handle registers a handler like safehttp.ServeMuxConfig.Handle does, and
+// labels its metrics with pattern.
+func (c *MuxConfig) Handle(pattern string, method string, h safehttp.Handler, cfgs ...safehttp.InterceptorConfig) {
+	c.ServeMuxConfig.Handle(pattern, method, h, append(cfgs, metrics.Route(pattern))...)
+	c.routes = append(c.routes, Route{Pattern: pattern, Method: method})
+}
+
+// Routes returns the routes registered so far, e.g. for tests to check every
+// endpoint.
+func (c *MuxConfig) Routes() []Route {
+	return append([]Route(nil), c.routes...)
+}
+
+// Mux builds the instrumented handler to serve.
+func (c *MuxConfig) Mux() http.Handler {
+	var h http.Handler = limitBody(c.ServeMuxConfig.Mux(), c.maxBodyBytes)
+	if c.compression != nil {
+		h = compress.Handler(h, *c.compression)
+	}
+	return metrics.Handler(h)
+}
+
+// limitBody limits the size of request bodies. Bodies are parsed by
+// interceptors before any handler can limit them, e.g. multipart forms are
+// parsed by the XSRF one and spill to temporary files.
+func limitBody(h http.Handler, max int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, max)
+		h.ServeHTTP(w, r)
+	})
+}
+
 // NewMuxConfig creates a safe ServeMuxConfig.
-func NewMuxConfig(db *storage.DB, addr string) *safehttp.ServeMuxConfig {
+//
+// conf must have been validated. CSP violations are reported to cspReports.
+func NewMuxConfig(db *storage.DB, conf *config.Config, cspReports *reports.Collector) *MuxConfig {
+	// Event streams end before the server would time out writing them, and
+	// browsers reconnect.
+	c := safehttp.NewServeMuxConfig(dispatcher{maxStream: conf.Server.WriteTimeout * 3 / 4})
+	c.Intercept(metrics.Interceptor{})
+	mc := &MuxConfig{ServeMuxConfig: c, maxBodyBytes: conf.Server.MaxBodyBytes}
+	if conf.Compression.Enabled {
+		// Installed before the XSRF interceptor, see compress.Interceptor.
+		c.Intercept(compress.Interceptor{})
+		mc.compression = &compress.Options{
+			MinSize: conf.Compression.MinSize,
+			Padding: conf.Compression.Padding,
+		}
+	}
+	if conf.Plugins.COOP {
+		c.Intercept(coop.Default(""))
+	}
+	if conf.Plugins.CSP {
+		c.Intercept(newCSPInterceptor(conf.Plugins.TrustedTypes))
+		c.Intercept(reportingEndpoints{})
+	}
+	if conf.Plugins.FetchMetadata {
+		c.Intercept(metrics.CountRejections("fetchmetadata", fetchmetadata.NewInterceptor()))
+	}
+	c.Intercept(metrics.CountRejections("hostcheck", hostcheck.New(conf.Hosts()...)))
+	if conf.Plugins.HSTS {
+		it := hsts.Default()
+		it.BehindProxy = conf.Server.BehindProxy
+		c.Intercept(it)
+	}
+	c.Intercept(staticheaders.Interceptor{})
+	c.Intercept(corpInterceptor{})
+	c.Intercept(publicPageInterceptor{})
+	c.Intercept(downloadInterceptor{})
+	c.Intercept(xsrfInterceptor{metrics.CountRejections("xsrf", &xsrfhtml.Interceptor{SecretAppKey: conf.Secrets.XSRFKey})})
+	c.Intercept(auth.Interceptor{DB: db, ClientCerts: conf.TLS.ClientCA != ""})
+
+	// Browsers send reports without credentials nor XSRF tokens.
+	mc.Handle(cspReportPath, safehttp.MethodPost, cspReports.Handler(), auth.Skip{}, SkipXSRF{})
+	return mc
+}
+
+// NewAdminMuxConfig creates the ServeMuxConfig for the admin endpoints, which
+// expose operational data and must only be served on a private listener.
+//
+// These endpoints are used by monitoring systems and orchestrators, which do
+// not authenticate and use arbitrary Host headers, so neither auth nor
+// hostcheck are installed. checks are the readiness checks, in addition to
+// the storage one.
+func NewAdminMuxConfig(db *storage.DB, checks map[string]health.Check, cspReports *reports.Collector) *safehttp.ServeMuxConfig {
+	registerStorageMetrics(db)
+
+	readyChecks := map[string]health.Check{"storage": db.Ping}
+	for n, c := range checks {
+		readyChecks[n] = c
+	}
+
 	c := safehttp.NewServeMuxConfig(dispatcher{})
-	c.Intercept(coop.Default(""))
-	c.Intercept(csp.Default(""))
-	c.Intercept(fetchmetadata.NewInterceptor())
-	c.Intercept(hostcheck.New(addr))
-	c.Intercept(hsts.Default())
 	c.Intercept(staticheaders.Interceptor{})
-	c.Intercept(&xsrfhtml.Interceptor{SecretAppKey: "secret-key-that-should-not-be-in-sources"})
-	c.Intercept(auth.Interceptor{DB: db})
+	c.Handle("/metrics", safehttp.MethodGet, metrics.Serve())
+	c.Handle("/healthz", safehttp.MethodGet, health.Live())
+	c.Handle("/readyz", safehttp.MethodGet, health.Ready(readyChecks))
+	c.Handle("/version", safehttp.MethodGet, health.Version())
+	c.Handle("/csp-reports", safehttp.MethodGet, cspReports.Page())
 	return c
 }
+
+func registerStorageMetrics(db *storage.DB) {
+	metrics.NewGaugeFunc("notekeeper_storage_users", "Number of registered users.", func() float64 {
+		return float64(db.Stats().Users)
+	})
+	metrics.NewGaugeFunc("notekeeper_storage_notes", "Number of stored notes.", func() float64 {
+		return float64(db.Stats().Notes)
+	})
+	metrics.NewGaugeFunc("notekeeper_storage_sessions", "Number of active sessions.", func() float64 {
+		return float64(db.Stats().Sessions)
+	})
+}
@@ -0,0 +1,65 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secure
+
+import (
+	"github.com/google/go-safeweb/safehttp"
+)
+
+// PublicPage marks an endpoint that serves user content to anyone with its
+// unguessable URL, e.g. share links. Such pages:
+//   - are not indexed by search engines;
+//   - do not send their URL as the referrer of the requests they make;
+//   - are not cached, so that revoking the URL takes effect;
+//   - get a CSP that only allows stylesheets, fonts and images of this origin
+//     (see publicPagePolicy), so they cannot run any script.
+//
+// It is meant to be used together with auth.Skip.
+type PublicPage struct{}
+
+func (PublicPage) Match(i safehttp.Interceptor) bool {
+	switch i.(type) {
+	case publicPageInterceptor, cspInterceptor:
+		return true
+	}
+	return false
+}
+
+// publicPageInterceptor sets the headers of PublicPage endpoints.
+type publicPageInterceptor struct{}
+
+func (publicPageInterceptor) Before(w safehttp.ResponseWriter, r *safehttp.IncomingRequest, cfg safehttp.InterceptorConfig) safehttp.Result {
+	if _, ok := cfg.(PublicPage); !ok {
+		return safehttp.NotWritten()
+	}
+	h := w.Header()
+	h.Set("X-Robots-Tag", "noindex, nofollow")
+	h.Set("Referrer-Policy", "no-referrer")
+	h.Set("Cache-Control", "no-store")
+	return safehttp.NotWritten()
+}
+
+func (publicPageInterceptor) Commit(w safehttp.ResponseHeadersWriter, r *safehttp.IncomingRequest, resp safehttp.Response, cfg safehttp.InterceptorConfig) {
+}
+
+// publicPagePolicy is the CSP of PublicPage endpoints. Unlike the strict
+// policy, which allows nonced scripts, it denies everything that the pages
+// do not need.
+type publicPagePolicy struct{}
+
+func (publicPagePolicy) Serialize(nonce string) string {
+	return "default-src 'none'; style-src 'self'; font-src 'self'; img-src 'self'; " +
+		"base-uri 'none'; form-action 'none'; frame-ancestors 'none'; report-uri " + cspReportPath
+}
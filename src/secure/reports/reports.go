@@ -0,0 +1,311 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package reports collects the Content Security Policy violations reported by
+// browsers, and aggregates them so that they can be reviewed.
+//
+// Both the deprecated report-uri format and the Reporting API format are
+// accepted. See https://w3c.github.io/webappsec-csp/#reporting and
+// https://w3c.github.io/reporting/.
+package reports
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-safeweb/safehttp"
+
+	"github.com/empijei/go-safeweb-example-app/src/secure/metrics"
+	"github.com/empijei/go-safeweb-example-app/src/secure/templates"
+)
+
+const (
+	// maxBodySize is the maximum size of a report request. Browsers batch
+	// reports, but a legitimate batch is much smaller than this.
+	maxBodySize = 64 << 10
+	// maxAggregates caps the number of distinct violations kept in memory.
+	// Reports for new violations are dropped once it is reached.
+	maxAggregates = 1000
+	// maxSampleLen caps the size of the strings kept from a report.
+	maxSampleLen = 256
+)
+
+var reportsTotal = metrics.NewCounterVec(
+	"notekeeper_csp_reports_total",
+	"Number of CSP violation reports received, by outcome.",
+	"outcome",
+)
+
+// Aggregate is a set of reports for the same violation.
+type Aggregate struct {
+	// Directive is the directive that was violated.
+	Directive string
+	// Blocked is the blocked resource: an origin, or a keyword like "inline"
+	// or "eval".
+	Blocked string
+	// Disposition is "enforce" or "report".
+	Disposition string
+
+	Count               int
+	FirstSeen, LastSeen time.Time
+	// Sample is the document of the most recent report.
+	Sample string
+}
+
+type key struct {
+	directive, blocked, disposition string
+}
+
+// Collector receives and aggregates CSP reports.
+type Collector struct {
+	limiter *limiter
+
+	mu         sync.Mutex
+	aggregates map[key]*Aggregate
+}
+
+// NewCollector creates a collector that accepts up to rate report requests
+// per second, with bursts of up to burst requests.
+func NewCollector(rate float64, burst int) *Collector {
+	return &Collector{
+		limiter:    newLimiter(rate, burst),
+		aggregates: map[key]*Aggregate{},
+	}
+}
+
+// violation is the subset of a CSP report that we keep.
+type violation struct {
+	directive, blocked, disposition, document string
+}
+
+// Handler returns the handler browsers send reports to.
+func (c *Collector) Handler() safehttp.Handler {
+	return safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		if !c.limiter.allow(time.Now()) {
+			reportsTotal.Inc("rate_limited")
+			return w.WriteError(safehttp.StatusTooManyRequests)
+		}
+		ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		var parse func([]byte) ([]violation, error)
+		switch ct {
+		case "application/csp-report", "application/json":
+			parse = parseReportURI
+		case "application/reports+json":
+			parse = parseReportingAPI
+		default:
+			reportsTotal.Inc("invalid")
+			return w.WriteError(safehttp.StatusUnsupportedMediaType)
+		}
+
+		b, err := ioutil.ReadAll(io.LimitReader(r.Body(), maxBodySize+1))
+		if err != nil {
+			reportsTotal.Inc("invalid")
+			return w.WriteError(safehttp.StatusBadRequest)
+		}
+		if len(b) > maxBodySize {
+			reportsTotal.Inc("too_large")
+			return w.WriteError(safehttp.StatusRequestEntityTooLarge)
+		}
+		vs, err := parse(b)
+		if err != nil {
+			reportsTotal.Inc("invalid")
+			return w.WriteError(safehttp.StatusBadRequest)
+		}
+		for _, v := range vs {
+			if c.add(v, time.Now()) {
+				reportsTotal.Inc("accepted")
+			} else {
+				reportsTotal.Inc("dropped")
+			}
+		}
+		return w.NoContent()
+	})
+}
+
+func (c *Collector) add(v violation, now time.Time) bool {
+	k := key{
+		directive:   truncate(v.directive),
+		blocked:     normalizeBlocked(v.blocked),
+		disposition: truncate(v.disposition),
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	a, ok := c.aggregates[k]
+	if !ok {
+		if len(c.aggregates) >= maxAggregates {
+			return false
+		}
+		a = &Aggregate{
+			Directive:   k.directive,
+			Blocked:     k.blocked,
+			Disposition: k.disposition,
+			FirstSeen:   now,
+		}
+		c.aggregates[k] = a
+	}
+	a.Count++
+	a.LastSeen = now
+	a.Sample = truncate(stripQuery(v.document))
+	return true
+}
+
+// Aggregates returns the violations reported so far, most frequent first.
+func (c *Collector) Aggregates() []Aggregate {
+	c.mu.Lock()
+	as := make([]Aggregate, 0, len(c.aggregates))
+	for _, a := range c.aggregates {
+		as = append(as, *a)
+	}
+	c.mu.Unlock()
+
+	sort.Slice(as, func(i, j int) bool {
+		if as[i].Count != as[j].Count {
+			return as[i].Count > as[j].Count
+		}
+		return as[i].LastSeen.After(as[j].LastSeen)
+	})
+	return as
+}
+
+// parseReportURI parses a report sent to a report-uri.
+func parseReportURI(b []byte) ([]violation, error) {
+	var r struct {
+		Report struct {
+			BlockedURI         string `json:"blocked-uri"`
+			Disposition        string `json:"disposition"`
+			DocumentURI        string `json:"document-uri"`
+			EffectiveDirective string `json:"effective-directive"`
+			ViolatedDirective  string `json:"violated-directive"`
+		} `json:"csp-report"`
+	}
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, err
+	}
+	directive := r.Report.EffectiveDirective
+	if directive == "" {
+		// Older browsers only send the violated directive, with its value.
+		directive = strings.SplitN(r.Report.ViolatedDirective, " ", 2)[0]
+	}
+	return []violation{{
+		directive:   directive,
+		blocked:     r.Report.BlockedURI,
+		disposition: r.Report.Disposition,
+		document:    r.Report.DocumentURI,
+	}}, nil
+}
+
+// parseReportingAPI parses a batch of reports sent with the Reporting API.
+// Reports other than CSP violations are ignored.
+func parseReportingAPI(b []byte) ([]violation, error) {
+	var rs []struct {
+		Type string `json:"type"`
+		Body struct {
+			BlockedURL         string `json:"blockedURL"`
+			Disposition        string `json:"disposition"`
+			DocumentURL        string `json:"documentURL"`
+			EffectiveDirective string `json:"effectiveDirective"`
+		} `json:"body"`
+	}
+	if err := json.Unmarshal(b, &rs); err != nil {
+		return nil, err
+	}
+	var vs []violation
+	for _, r := range rs {
+		if r.Type != "csp-violation" {
+			continue
+		}
+		vs = append(vs, violation{
+			directive:   r.Body.EffectiveDirective,
+			blocked:     r.Body.BlockedURL,
+			disposition: r.Body.Disposition,
+			document:    r.Body.DocumentURL,
+		})
+	}
+	return vs, nil
+}
+
+// normalizeBlocked reduces a blocked URI to its origin, so that violations
+// caused by the same resource in different pages are aggregated together.
+// Keywords like "inline" or "eval" are kept as they are.
+func normalizeBlocked(blocked string) string {
+	u, err := url.Parse(blocked)
+	if err != nil || u.Host == "" {
+		return truncate(blocked)
+	}
+	return truncate(u.Scheme + "://" + u.Host)
+}
+
+// stripQuery removes the query and fragment from a URL, as they might contain
+// user data.
+func stripQuery(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return ""
+	}
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String()
+}
+
+func truncate(s string) string {
+	if len(s) > maxSampleLen {
+		return s[:maxSampleLen]
+	}
+	return s
+}
+
+// limiter is a token bucket rate limiter.
+type limiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newLimiter(rate float64, burst int) *limiter {
+	return &limiter{rate: rate, burst: float64(burst), tokens: float64(burst)}
+}
+
+func (l *limiter) allow(now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// Page returns a handler that shows the aggregated reports. It must only be
+// served on the admin listener.
+func (c *Collector) Page() safehttp.Handler {
+	return safehttp.HandlerFunc(func(w safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		return safehttp.ExecuteNamedTemplate(w, templates.All, "csp-reports.tpl.html", c.Aggregates())
+	})
+}
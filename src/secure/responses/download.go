@@ -0,0 +1,33 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package responses
+
+import "io"
+
+// Download is a file to download (as recognized by the secure.dispatcher).
+//
+// It is always served with Content-Disposition: attachment, so browsers save
+// it rather than render it. Handlers serving downloads should also be
+// registered with secure.Download, in case a browser renders it anyway.
+type Download struct {
+	// Name is the name browsers save the file as.
+	Name        string
+	ContentType string
+	// Size is the length of Body, or 0 if it is not known in advance, e.g.
+	// for content generated while it is sent.
+	Size int64
+	// Body is closed once written.
+	Body io.ReadCloser
+}
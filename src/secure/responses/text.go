@@ -0,0 +1,41 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package responses
+
+import "github.com/google/go-safeweb/safehttp"
+
+// Text is a plain text response (as recognized by the secure.dispatcher).
+//
+// It is always served as text/plain, so browsers will not interpret it as
+// markup regardless of its content.
+type Text struct {
+	Body string
+}
+
+// TextError is a plain text error response (as recognized by the
+// secure.dispatcher). Like Text, it is always served as text/plain.
+type TextError struct {
+	StatusCode safehttp.StatusCode
+	Body       string
+}
+
+// Code returns the HTTP response code.
+func (e TextError) Code() safehttp.StatusCode {
+	return e.StatusCode
+}
+
+// NotModified tells the client that the copy it has is still current (as
+// recognized by the secure.dispatcher). It has no body.
+type NotModified struct{}
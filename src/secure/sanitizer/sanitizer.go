@@ -0,0 +1,199 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sanitizer turns untrusted HTML into safehtml.HTML.
+//
+// It only keeps the elements and attributes of an allowlist, which covers
+// formatted text, and re-serializes everything else as text. Its output is
+// always well formed and cannot run scripts, load resources, or submit forms.
+package sanitizer
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/google/safehtml"
+	"github.com/google/safehtml/uncheckedconversions"
+	xhtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// attrFilter returns the value to output for an attribute, if it is allowed.
+type attrFilter func(value string) (string, bool)
+
+// allowed maps the allowed elements to their allowed attributes.
+var allowed = map[atom.Atom]map[string]attrFilter{
+	atom.A:          {"href": safeURL, "title": anyText},
+	atom.Blockquote: nil,
+	atom.Br:         nil,
+	atom.Code:       {"class": matching(`language-[a-z0-9+#-]{1,32}`)},
+	atom.Del:        nil,
+	atom.Div:        nil,
+	atom.Em:         nil,
+	atom.H1:         nil,
+	atom.H2:         nil,
+	atom.H3:         nil,
+	atom.H4:         nil,
+	atom.H5:         nil,
+	atom.H6:         nil,
+	atom.Hr:         nil,
+	atom.Li:         nil,
+	atom.Ol:         {"start": matching(`[0-9]{1,9}`)},
+	atom.P:          nil,
+	atom.Pre:        nil,
+	atom.S:          nil,
+	atom.Span:       {"class": matching(`hl-(kw|str|com|num)`)},
+	atom.Strong:     nil,
+	atom.Sub:        nil,
+	atom.Sup:        nil,
+	atom.Table:      nil,
+	atom.Tbody:      nil,
+	atom.Td:         {"align": matching(`left|center|right`)},
+	atom.Th:         {"align": matching(`left|center|right`)},
+	atom.Thead:      nil,
+	atom.Tr:         nil,
+	atom.U:          nil,
+	atom.Ul:         nil,
+}
+
+// void elements have no end tag.
+var void = map[atom.Atom]bool{atom.Br: true, atom.Hr: true}
+
+// dropContent are the elements whose content is not meant to be shown as
+// text, so it is dropped along with them.
+var dropContent = map[atom.Atom]bool{
+	atom.Script: true, atom.Style: true, atom.Template: true, atom.Noscript: true,
+	atom.Iframe: true, atom.Object: true, atom.Embed: true, atom.Noembed: true,
+	atom.Noframes: true, atom.Svg: true, atom.Math: true, atom.Textarea: true,
+	atom.Select: true, atom.Title: true, atom.Xmp: true, atom.Plaintext: true,
+}
+
+// allowedSchemes are the URL schemes links can have. Relative URLs are
+// allowed too.
+var allowedSchemes = map[string]bool{"http": true, "https": true, "mailto": true}
+
+func safeURL(v string) (string, bool) {
+	// url.Parse rejects control characters, which browsers would strip, e.g.
+	// in "java\tscript:".
+	u, err := url.Parse(strings.TrimSpace(v))
+	if err != nil {
+		return "", false
+	}
+	if u.Scheme != "" && !allowedSchemes[strings.ToLower(u.Scheme)] {
+		return "", false
+	}
+	return u.String(), true
+}
+
+func anyText(v string) (string, bool) {
+	return v, true
+}
+
+func matching(pattern string) attrFilter {
+	re := regexp.MustCompile(`^(?:` + pattern + `)$`)
+	return func(v string) (string, bool) {
+		return v, re.MatchString(v)
+	}
+}
+
+// Sanitize returns the allowed elements and attributes of src, and its text.
+func Sanitize(src string) safehtml.HTML {
+	var b strings.Builder
+	z := xhtml.NewTokenizer(strings.NewReader(src))
+	// open are the allowed elements that were output and not closed yet.
+	var open []atom.Atom
+	// skip counts the dropContent elements we are in.
+	skip := 0
+	for {
+		tt := z.Next()
+		if tt == xhtml.ErrorToken {
+			break
+		}
+		t := z.Token()
+		switch tt {
+		case xhtml.TextToken:
+			if skip == 0 {
+				b.WriteString(html.EscapeString(t.Data))
+			}
+		case xhtml.StartTagToken, xhtml.SelfClosingTagToken:
+			if dropContent[t.DataAtom] {
+				if tt == xhtml.StartTagToken {
+					skip++
+				}
+				continue
+			}
+			attrs, ok := allowed[t.DataAtom]
+			if !ok || skip > 0 {
+				continue
+			}
+			writeStartTag(&b, t, attrs)
+			if !void[t.DataAtom] {
+				if tt == xhtml.SelfClosingTagToken {
+					b.WriteString("</" + t.DataAtom.String() + ">")
+				} else {
+					open = append(open, t.DataAtom)
+				}
+			}
+		case xhtml.EndTagToken:
+			if dropContent[t.DataAtom] {
+				if skip > 0 {
+					skip--
+				}
+				continue
+			}
+			// Close the element and the ones left open inside of it, so that
+			// the output stays balanced.
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i] != t.DataAtom {
+					continue
+				}
+				for j := len(open) - 1; j >= i; j-- {
+					b.WriteString("</" + open[j].String() + ">")
+				}
+				open = open[:i]
+				break
+			}
+		}
+		// Comments and doctypes are dropped.
+	}
+	for i := len(open) - 1; i >= 0; i-- {
+		b.WriteString("</" + open[i].String() + ">")
+	}
+	return uncheckedconversions.HTMLFromStringKnownToSatisfyTypeContract(b.String())
+}
+
+func writeStartTag(b *strings.Builder, t xhtml.Token, attrs map[string]attrFilter) {
+	b.WriteString("<" + t.DataAtom.String())
+	seen := map[string]bool{}
+	for _, a := range t.Attr {
+		filter, ok := attrs[a.Key]
+		if !ok || a.Namespace != "" || seen[a.Key] {
+			continue
+		}
+		v, ok := filter(a.Val)
+		if !ok {
+			continue
+		}
+		seen[a.Key] = true
+		b.WriteString(" " + a.Key + `="` + html.EscapeString(v) + `"`)
+	}
+	if t.DataAtom == atom.A {
+		// Links open in a new tab, which must not get a reference to this
+		// page nor learn its URL, e.g. a share link.
+		b.WriteString(` target="_blank" rel="noopener noreferrer nofollow"`)
+	}
+	b.WriteString(">")
+}
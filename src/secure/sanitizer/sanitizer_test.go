@@ -0,0 +1,62 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sanitizer
+
+import (
+	"testing"
+
+	"github.com/empijei/go-safeweb-example-app/src/secure/sanitizer/sanitizertest"
+)
+
+// seeds are inputs that ran scripts through other sanitizers.
+var seeds = []string{
+	`<script>alert(1)</script>`,
+	`<SCRIPT SRC=//evil.example></SCRIPT>`,
+	`<svg onload=alert(1)>`,
+	`<svg><script>alert(1)</script></svg>`,
+	`<img src=x onerror=alert(1)>`,
+	`<a href="javascript:alert(1)">x</a>`,
+	"<a href=\"java\tscript:alert(1)\">x</a>",
+	`<a href="java&#x09;script:alert(1)">x</a>`,
+	`<a href=" JaVaScRiPt:alert(1)">x</a>`,
+	`<a href="data:text/html,<script>alert(1)</script>">x</a>`,
+	`<a href="vbscript:msgbox(1)">x</a>`,
+	`<a href="https://example.com" onclick="alert(1)">x</a>`,
+	`<a href="x"><a href="javascript:alert(1)">y</a></a>`,
+	`<a <a href=javascript:alert(1)>x</a>`,
+	`<script>`,
+	`<style>`,
+	`<textarea><script>alert(1)</script>`,
+	`<title></title><script>alert(1)</script>`,
+	`<noscript><p title="</noscript><img src=x onerror=alert(1)>">`,
+	`<xmp><script>alert(1)</script></xmp>`,
+	`<iframe srcdoc="<script>alert(1)</script>"></iframe>`,
+	`<style><img src=x onerror=alert(1)></style>`,
+	`<math><mtext><table><mglyph><style><img src=x onerror=alert(1)>`,
+	`<p style="background:url(javascript:alert(1))">x</p>`,
+	`<!--<script>alert(1)</script>-->`,
+	`<![CDATA[<script>alert(1)</script>]]>`,
+}
+
+func FuzzSanitize(f *testing.F) {
+	for _, s := range seeds {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, src string) {
+		if err := sanitizertest.CheckNoScript(Sanitize(src).String()); err != nil {
+			t.Errorf("Sanitize(%q): %v", src, err)
+		}
+	})
+}
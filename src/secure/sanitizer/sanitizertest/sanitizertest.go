@@ -0,0 +1,90 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sanitizertest checks that HTML cannot run scripts, for the tests of
+// the code that relies on the sanitizer.
+package sanitizertest
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// schemes are the URL schemes links may have.
+var schemes = map[string]bool{"http": true, "https": true, "mailto": true}
+
+// CheckNoScript parses src as a browser would in a body element, and returns
+// an error if it has script, style or iframe elements, event handler
+// attributes, or links with schemes other than http, https and mailto.
+func CheckNoScript(src string) error {
+	nodes, err := html.ParseFragment(strings.NewReader(src), &html.Node{
+		Type:     html.ElementNode,
+		Data:     "body",
+		DataAtom: atom.Body,
+	})
+	if err != nil {
+		return fmt.Errorf("parsing %q: %v", src, err)
+	}
+	for _, n := range nodes {
+		if err := checkNode(n); err != nil {
+			return fmt.Errorf("%v in %q", err, src)
+		}
+	}
+	return nil
+}
+
+func checkNode(n *html.Node) error {
+	if n.Type == html.ElementNode {
+		switch n.DataAtom {
+		case atom.Script, atom.Style, atom.Iframe:
+			return fmt.Errorf("<%s> element", n.Data)
+		}
+		for _, a := range n.Attr {
+			key := strings.ToLower(a.Key)
+			if strings.HasPrefix(key, "on") {
+				return fmt.Errorf("%s attribute", key)
+			}
+			if key == "href" && !schemes[scheme(a.Val)] {
+				return fmt.Errorf("link to %q", a.Val)
+			}
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if err := checkNode(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scheme returns the scheme browsers see in u, "http" for relative URLs.
+func scheme(u string) string {
+	// Browsers remove tabs and newlines anywhere, and leading and trailing C0
+	// controls and spaces.
+	u = strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\n' || r == '\r' {
+			return -1
+		}
+		return r
+	}, u)
+	u = strings.TrimFunc(u, func(r rune) bool { return r <= ' ' })
+	i := strings.IndexAny(u, ":/?#")
+	if i < 0 || u[i] != ':' {
+		return "http"
+	}
+	return strings.ToLower(u[:i])
+}
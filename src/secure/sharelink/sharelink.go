@@ -0,0 +1,90 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sharelink signs the tokens of public share links.
+//
+// A token carries the random ID of a link, which the storage maps to a note,
+// and its expiry. The signature lets forged and expired tokens be rejected
+// without a lookup, and makes sure the expiry cannot be tampered with. Links
+// are revoked by deleting them from the storage.
+package sharelink
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalid is returned for tokens that were not signed by the Signer.
+var ErrInvalid = errors.New("invalid share link")
+
+// ErrExpired is returned for tokens past their expiry.
+var ErrExpired = errors.New("expired share link")
+
+// domain separates the signatures of share links from other uses of the key.
+const domain = "notekeeper share link\x00"
+
+// Signer signs and verifies tokens.
+type Signer struct {
+	key []byte
+}
+
+// NewSigner returns a Signer using key, which must be secret.
+func NewSigner(key string) *Signer {
+	return &Signer{key: []byte(key)}
+}
+
+// Token returns the token of the link with the given ID, which must be URL
+// safe and not contain dots. A zero expires never expires.
+func (s *Signer) Token(id string, expires time.Time) string {
+	payload := id + "." + formatExpiry(expires)
+	return payload + "." + s.sign(payload)
+}
+
+// Verify returns the ID of the link of token.
+func (s *Signer) Verify(token string, now time.Time) (id string, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", ErrInvalid
+	}
+	payload := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(parts[2]), []byte(s.sign(payload))) {
+		return "", ErrInvalid
+	}
+	exp, err := strconv.ParseInt(parts[1], 36, 64)
+	if err != nil {
+		return "", ErrInvalid
+	}
+	if exp != 0 && now.Unix() >= exp {
+		return "", ErrExpired
+	}
+	return parts[0], nil
+}
+
+func (s *Signer) sign(payload string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(domain + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func formatExpiry(t time.Time) string {
+	if t.IsZero() {
+		return "0"
+	}
+	return strconv.FormatInt(t.Unix(), 36)
+}
@@ -0,0 +1,127 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sse streams events to browsers with Server-Sent Events. See
+// https://html.spec.whatwg.org/multipage/server-sent-events.html.
+package sse
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	// heartbeatInterval is how often a comment is sent on idle streams, so
+	// that proxies do not close them and dead clients are detected.
+	heartbeatInterval = 15 * time.Second
+	// retryDelay is how long browsers wait before reconnecting.
+	retryDelay = 3 * time.Second
+)
+
+// Event is a message sent to the client.
+type Event struct {
+	// Name is the type of the event, which clients listen to. It must be a
+	// constant without line breaks.
+	Name string
+	// Data is sent encoded as JSON.
+	Data interface{}
+}
+
+// Stream is a stream of events (as recognized by the secure.dispatcher).
+//
+// The stream ends when Context is done or Events is closed. Browsers then
+// reconnect, so Initial should contain everything a client needs to catch up.
+type Stream struct {
+	Context context.Context
+	// Initial are sent as soon as the stream starts.
+	Initial []Event
+	Events  <-chan Event
+}
+
+// Serve writes the stream to w, ending it after maxDuration if it is not 0.
+//
+// maxDuration should be lower than the WriteTimeout of the server, so that
+// streams end cleanly rather than being cut in the middle of an event. Serve
+// only returns an error if the stream cannot start.
+func (s Stream) Serve(w http.ResponseWriter, maxDuration time.Duration) error {
+	f, ok := w.(http.Flusher)
+	if !ok {
+		return errors.New("streaming is not supported by the http.ResponseWriter")
+	}
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-store")
+	// Disable the response buffering of reverse proxies like nginx.
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	ctx := s.Context
+	if maxDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, maxDuration)
+		defer cancel()
+	}
+
+	// Once the headers are written, writes only fail when the client went
+	// away, which ends the stream normally: errors returned to the
+	// dispatcher would make safehttp panic.
+	if _, err := fmt.Fprintf(w, "retry: %d\n\n", retryDelay.Milliseconds()); err != nil {
+		return nil
+	}
+	for _, e := range s.Initial {
+		if err := writeEvent(w, e); err != nil {
+			return nil
+		}
+	}
+	f.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case e, ok := <-s.Events:
+			if !ok {
+				return nil
+			}
+			if err := writeEvent(w, e); err != nil {
+				return nil
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ":\n\n"); err != nil {
+				return nil
+			}
+		}
+		f.Flush()
+	}
+}
+
+func writeEvent(w http.ResponseWriter, e Event) error {
+	// encoding/json escapes line breaks in strings, so the data is one line
+	// and cannot inject fields or events.
+	data, err := json.Marshal(e.Data)
+	if err != nil {
+		// A bug rather than a client that went away, the event is skipped.
+		log.Printf("Encoding event %q: %v", e.Name, err)
+		return nil
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Name, data)
+	return err
+}
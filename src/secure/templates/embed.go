@@ -19,6 +19,8 @@ import (
 
 	"github.com/google/go-safeweb/safehttp/plugins/htmlinject"
 	"github.com/google/safehtml/template"
+
+	"github.com/empijei/go-safeweb-example-app/src/static"
 )
 
 //go:embed *.tpl.html
@@ -29,7 +31,7 @@ var All *template.Template
 func init() {
 	tplSrc := template.TrustedSourceFromConstant("*.tpl.html")
 	var err error
-	All, err = htmlinject.LoadGlobEmbed(nil, htmlinject.LoadConfig{}, tplSrc, templatesFS)
+	All, err = htmlinject.LoadGlobEmbed(template.New("").Funcs(static.Assets.FuncMap()), htmlinject.LoadConfig{}, tplSrc, templatesFS)
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,192 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secure
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// NewTLSConfig creates a TLS configuration that only allows TLS 1.2 or newer
+// with forward secret AEAD cipher suites, and serves the certificate from certs.
+//
+// If clientCAs is not nil, clients may authenticate with a certificate signed
+// by one of them. Certificates are optional, so that users can still log in
+// with a password.
+func NewTLSConfig(certs *CertReloader, clientCAs *x509.CertPool) *tls.Config {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// Only used for TLS 1.2, TLS 1.3 suites are not configurable and all
+		// satisfy this policy.
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+		},
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+		GetCertificate:   certs.GetCertificate,
+	}
+	if clientCAs != nil {
+		cfg.ClientCAs = clientCAs
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return cfg
+}
+
+// LoadCertPool loads the PEM encoded certificates in file.
+func LoadCertPool(file string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %q", file)
+	}
+	return pool, nil
+}
+
+// CertReloader serves a certificate loaded from a pair of PEM files, and
+// reloads it whenever the files change. This allows to rotate certificates
+// without restarting the server.
+type CertReloader struct {
+	certFile, keyFile string
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	modTimes [2]time.Time
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewCertReloader loads the certificate in certFile and keyFile, and checks
+// them for changes every interval until Close is called.
+func NewCertReloader(certFile, keyFile string, interval time.Duration) (*CertReloader, error) {
+	c := &CertReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if _, err := c.reload(); err != nil {
+		return nil, err
+	}
+	go c.watch(interval)
+	return c, nil
+}
+
+// GetCertificate returns the current certificate. It can be used as
+// tls.Config.GetCertificate.
+func (c *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+// Close stops watching the files for changes.
+func (c *CertReloader) Close() error {
+	close(c.stop)
+	<-c.done
+	return nil
+}
+
+// Check reports whether a valid certificate is being served and its files are
+// still watched for changes.
+func (c *CertReloader) Check(ctx context.Context) error {
+	select {
+	case <-c.done:
+		return errors.New("not watching the certificate files")
+	default:
+	}
+	c.mu.RLock()
+	leaf := c.cert.Leaf
+	c.mu.RUnlock()
+	if now := time.Now(); now.After(leaf.NotAfter) || now.Before(leaf.NotBefore) {
+		return fmt.Errorf("certificate is only valid from %v to %v", leaf.NotBefore, leaf.NotAfter)
+	}
+	return nil
+}
+
+func (c *CertReloader) watch(interval time.Duration) {
+	defer close(c.done)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-t.C:
+		}
+		reloaded, err := c.reload()
+		if err != nil {
+			// The files might be in the middle of being replaced, keep serving
+			// the previous certificate and try again later.
+			log.Printf("Reloading TLS certificate: %v", err)
+			continue
+		}
+		if reloaded {
+			log.Printf("Reloaded TLS certificate from %q", c.certFile)
+		}
+	}
+}
+
+// reload loads the certificate if the files changed since the last time. It
+// reports whether a new certificate was loaded.
+func (c *CertReloader) reload() (bool, error) {
+	var modTimes [2]time.Time
+	for i, f := range []string{c.certFile, c.keyFile} {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return false, err
+		}
+		modTimes[i] = fi.ModTime()
+	}
+
+	c.mu.RLock()
+	unchanged := c.cert != nil && modTimes == c.modTimes
+	c.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return false, err
+	}
+	if cert.Leaf == nil {
+		// Only populated by LoadX509KeyPair since Go 1.23.
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return false, err
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cert = &cert
+	c.modTimes = modTimes
+	return true, nil
+}
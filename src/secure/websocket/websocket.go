@@ -0,0 +1,352 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package websocket implements the server side of the WebSocket protocol, see
+// https://tools.ietf.org/html/rfc6455. Only text messages are supported.
+//
+// The opening handshake is a regular request, so it goes through all the
+// interceptors, e.g. auth. WebSockets are not subject to the Same Origin
+// Policy, so Accept also rejects handshakes from other origins.
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/go-safeweb/safehttp"
+)
+
+const (
+	// MaxMessageSize is the maximum size of a message sent by clients.
+	MaxMessageSize = 1 << 20
+
+	// pingInterval is how often clients are pinged, so that dead connections
+	// are detected and proxies do not close idle ones.
+	pingInterval = 25 * time.Second
+	// readTimeout closes connections that did not send anything, not even a
+	// pong, for this long.
+	readTimeout  = 2 * pingInterval
+	writeTimeout = 10 * time.Second
+)
+
+// The GUID used to compute Sec-WebSocket-Accept, see RFC 6455, section 1.3.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Opcodes, see RFC 6455, section 5.2.
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// Close status codes, see RFC 6455, section 7.4.1.
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	CloseInvalidData     = 1007
+	ClosePolicyViolation = 1008
+	CloseTooBig          = 1009
+)
+
+// ErrClosed is returned when reading from or writing to a closed connection.
+var ErrClosed = errors.New("websocket: connection closed")
+
+// ErrCrossOrigin is returned by Accept for handshakes from other origins.
+var ErrCrossOrigin = errors.New("websocket: cross-origin handshake")
+
+// Upgrade switches the connection of a request to the WebSocket protocol (as
+// recognized by the secure.dispatcher).
+type Upgrade struct {
+	// private, to only allow upgrades that passed the checks of Accept.
+	accept string
+	serve  func(*Conn)
+}
+
+// Accept checks that r is a WebSocket opening handshake sent by a page of the
+// same origin. The returned response upgrades the connection and calls serve
+// with it. The connection is closed when serve returns.
+func Accept(r *safehttp.IncomingRequest, serve func(*Conn)) (Upgrade, error) {
+	h := r.Header
+	if r.Method() != safehttp.MethodGet ||
+		!headerContains(h.Get("Connection"), "upgrade") ||
+		!headerContains(h.Get("Upgrade"), "websocket") {
+		return Upgrade{}, errors.New("not a WebSocket handshake")
+	}
+	if v := h.Get("Sec-WebSocket-Version"); v != "13" {
+		return Upgrade{}, fmt.Errorf("unsupported WebSocket version %q", v)
+	}
+	key := h.Get("Sec-WebSocket-Key")
+	if b, err := base64.StdEncoding.DecodeString(key); err != nil || len(b) != 16 {
+		return Upgrade{}, errors.New("invalid Sec-WebSocket-Key")
+	}
+	// Browsers always send an Origin header with WebSocket handshakes.
+	origin, err := url.Parse(h.Get("Origin"))
+	if err != nil || origin.Host != r.Host() {
+		return Upgrade{}, ErrCrossOrigin
+	}
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return Upgrade{accept: base64.StdEncoding.EncodeToString(sum[:]), serve: serve}, nil
+}
+
+func headerContains(header, token string) bool {
+	for _, t := range strings.Split(header, ",") {
+		if strings.EqualFold(strings.TrimSpace(t), token) {
+			return true
+		}
+	}
+	return false
+}
+
+// Serve takes over the connection of w, completes the handshake and serves
+// the WebSocket connection until the serve function of Accept returns.
+func (u Upgrade) Serve(w http.ResponseWriter) error {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return errors.New("the http.ResponseWriter does not support hijacking")
+	}
+	nc, brw, err := hj.Hijack()
+	if err != nil {
+		return err
+	}
+	// Clear the deadlines set by the http.Server for regular requests.
+	if err := nc.SetDeadline(time.Time{}); err != nil {
+		nc.Close()
+		return err
+	}
+	c := &Conn{nc: nc, r: brw.Reader, done: make(chan struct{})}
+	c.mu.Lock()
+	nc.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err = fmt.Fprintf(nc, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", u.accept)
+	c.mu.Unlock()
+	if err != nil {
+		nc.Close()
+		return err
+	}
+	go c.ping()
+	u.serve(c)
+	c.Close(CloseNormal)
+	return nil
+}
+
+// Conn is a WebSocket connection. Messages can be written concurrently, but
+// only one goroutine may read them.
+type Conn struct {
+	nc net.Conn
+	r  *bufio.Reader
+
+	// mu serializes writes.
+	mu     sync.Mutex
+	closed bool
+	done   chan struct{}
+}
+
+// ReadMessage returns the next text message. It answers pings and handles
+// the closing handshake, in which case it returns ErrClosed.
+func (c *Conn) ReadMessage() (string, error) {
+	var msg []byte
+	started := false
+	for {
+		c.nc.SetReadDeadline(time.Now().Add(readTimeout))
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			c.Close(CloseProtocolError)
+			return "", err
+		}
+		switch op {
+		case opPing:
+			if err := c.write(opPong, payload); err != nil {
+				return "", err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			// Complete the closing handshake.
+			c.Close(CloseNormal)
+			return "", ErrClosed
+		case opBinary:
+			c.Close(CloseUnsupportedData)
+			return "", errors.New("binary messages are not supported")
+		case opText:
+			if started {
+				c.Close(CloseProtocolError)
+				return "", errors.New("new message in the middle of a fragmented one")
+			}
+			started = true
+		case opContinuation:
+			if !started {
+				c.Close(CloseProtocolError)
+				return "", errors.New("continuation frame without a message")
+			}
+		default:
+			c.Close(CloseProtocolError)
+			return "", fmt.Errorf("unknown opcode %#x", op)
+		}
+		if len(msg)+len(payload) > MaxMessageSize {
+			c.Close(CloseTooBig)
+			return "", errors.New("message too big")
+		}
+		msg = append(msg, payload...)
+		if fin {
+			break
+		}
+	}
+	if !utf8.Valid(msg) {
+		c.Close(CloseInvalidData)
+		return "", errors.New("text message is not valid UTF-8")
+	}
+	return string(msg), nil
+}
+
+// readFrame reads a frame sent by the client, see RFC 6455, section 5.2.
+func (c *Conn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(c.r, hdr[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin = hdr[0]&0x80 != 0
+	if hdr[0]&0x70 != 0 {
+		return false, 0, nil, errors.New("reserved bits set without an extension")
+	}
+	op = hdr[0] & 0x0F
+	if hdr[1]&0x80 == 0 {
+		return false, 0, nil, errors.New("unmasked frame from client")
+	}
+	n := uint64(hdr[1] & 0x7F)
+	switch n {
+	case 126:
+		var b [2]byte
+		if _, err := io.ReadFull(c.r, b[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err := io.ReadFull(c.r, b[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(b[:])
+	}
+	if op >= opClose && (n > 125 || !fin) {
+		return false, 0, nil, errors.New("invalid control frame")
+	}
+	if n > MaxMessageSize {
+		return false, 0, nil, errors.New("frame too big")
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(c.r, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, op, payload, nil
+}
+
+// WriteMessage sends a text message.
+func (c *Conn) WriteMessage(msg string) error {
+	return c.write(opText, []byte(msg))
+}
+
+// write sends an unfragmented, unmasked frame.
+func (c *Conn) write(op byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+	return c.writeLocked(op, payload)
+}
+
+func (c *Conn) writeLocked(op byte, payload []byte) error {
+	hdr := []byte{0x80 | op, 0}
+	switch n := len(payload); {
+	case n <= 125:
+		hdr[1] = byte(n)
+	case n <= 0xFFFF:
+		hdr[1] = 126
+		hdr = append(hdr, 0, 0)
+		binary.BigEndian.PutUint16(hdr[2:], uint16(n))
+	default:
+		hdr[1] = 127
+		hdr = append(hdr, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(hdr[2:], uint64(n))
+	}
+	c.nc.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if _, err := c.nc.Write(append(hdr, payload...)); err != nil {
+		// The stream might be corrupted, nothing else can be sent.
+		c.closeLocked()
+		return err
+	}
+	return nil
+}
+
+// Close sends a close frame with code, if the connection is still open, and
+// closes it. Pending and future reads and writes fail.
+func (c *Conn) Close(code int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	var payload [2]byte
+	binary.BigEndian.PutUint16(payload[:], uint16(code))
+	c.writeLocked(opClose, payload[:])
+	c.closeLocked()
+}
+
+func (c *Conn) closeLocked() {
+	if c.closed {
+		return
+	}
+	c.closed = true
+	close(c.done)
+	c.nc.Close()
+}
+
+func (c *Conn) ping() {
+	t := time.NewTicker(pingInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-t.C:
+			if err := c.write(opPing, nil); err != nil {
+				return
+			}
+		}
+	}
+}
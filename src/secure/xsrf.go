@@ -0,0 +1,78 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secure
+
+import (
+	"mime"
+
+	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/go-safeweb/safehttp/plugins/xsrf"
+
+	"github.com/empijei/go-safeweb-example-app/src/secure/metrics"
+)
+
+// xsrfInterceptor wraps the XSRF interceptor, which cannot be configured per
+// handler, so that it can be skipped with SkipXSRF.
+type xsrfInterceptor struct {
+	safehttp.Interceptor
+}
+
+func (it xsrfInterceptor) Before(w safehttp.ResponseWriter, r *safehttp.IncomingRequest, cfg safehttp.InterceptorConfig) safehttp.Result {
+	switch cfg.(type) {
+	case SkipXSRF:
+		return safehttp.NotWritten()
+	case JSONAPI:
+		if xsrf.StatePreserving(r) {
+			return safehttp.NotWritten()
+		}
+		if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct != "application/json" {
+			metrics.CountRejection("xsrf")
+			return w.WriteError(safehttp.StatusUnsupportedMediaType)
+		}
+		return safehttp.NotWritten()
+	}
+	return it.Interceptor.Before(w, r, nil)
+}
+
+func (it xsrfInterceptor) Commit(w safehttp.ResponseHeadersWriter, r *safehttp.IncomingRequest, resp safehttp.Response, cfg safehttp.InterceptorConfig) {
+	it.Interceptor.Commit(w, r, resp, nil)
+}
+
+// SkipXSRF allows to mark an endpoint to skip XSRF checks. Only use it for
+// endpoints that browsers call without user interaction and that do not act
+// on behalf of the user, e.g. report collectors.
+//
+// Like auth.Skip, its uses would normally be gated by a security review.
+type SkipXSRF struct{}
+
+func (SkipXSRF) Match(i safehttp.Interceptor) bool {
+	// This configuration only applies to the XSRF plugin.
+	_, ok := i.(xsrfInterceptor)
+	return ok
+}
+
+// JSONAPI marks an endpoint as a JSON API, which is called by scripts rather
+// than by HTML forms and so cannot send XSRF tokens.
+//
+// Instead, state changing requests must have an application/json body. Other
+// origins cannot send such requests without a CORS preflight, which this
+// application never allows.
+type JSONAPI struct{}
+
+func (JSONAPI) Match(i safehttp.Interceptor) bool {
+	// This configuration only applies to the XSRF plugin.
+	_, ok := i.(xsrfInterceptor)
+	return ok
+}
@@ -0,0 +1,70 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"log"
+
+	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/safehtml/template"
+
+	"github.com/empijei/go-safeweb-example-app/src/secure/auth"
+	"github.com/empijei/go-safeweb-example-app/src/secure/envelope"
+	"github.com/empijei/go-safeweb-example-app/src/secure/responses"
+)
+
+// deleteAccountHandler deletes the account of the user and all their notes,
+// then logs them out. The user must confirm by typing their username in the
+// "confirm" field.
+//
+// Their notes become unreadable right away, see storage.DeleteAccount, and
+// their export is deleted, but exports they downloaded are out of reach.
+func deleteAccountHandler(deps *serverDeps) safehttp.Handler {
+	confirmErr := responses.NewError(
+		safehttp.StatusBadRequest,
+		template.MustParseAndExecuteToHTML("Please type your username to confirm that you want to delete your account."),
+	)
+
+	return safehttp.HandlerFunc(func(rw safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		form, err := r.PostForm()
+		if err != nil {
+			return rw.WriteError(safehttp.StatusBadRequest)
+		}
+		user := auth.User(r)
+		if form.String("confirm", "") != user {
+			return rw.WriteError(confirmErr)
+		}
+		as := deps.db.DeleteAccount(user)
+		deps.imports.drop(user)
+		deps.exports.drop(user)
+		deleteBlobs(r.Context(), deps.blobs, as)
+		deps.audit.Log(user, "account.deleted", map[string]interface{}{"attachments": len(as)})
+		auth.ClearSession(r)
+		return safehttp.Redirect(rw, r, "/", safehttp.StatusSeeOther)
+	})
+}
+
+// RewrapKeys returns a job that wraps the data keys of users with the current
+// master key, so that older master keys can be removed after a rotation.
+func RewrapKeys(vault *envelope.Vault) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		n, err := vault.RewrapKeys()
+		if n > 0 {
+			log.Printf("Wrapped %d data keys with the current master key", n)
+		}
+		return err
+	}
+}
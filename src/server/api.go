@@ -0,0 +1,176 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-safeweb/safehttp"
+
+	"github.com/empijei/go-safeweb-example-app/src/secure/auth"
+	"github.com/empijei/go-safeweb-example-app/src/secure/responses"
+	"github.com/empijei/go-safeweb-example-app/src/storage"
+)
+
+// maxNoteSize is the maximum size of a note sent to the API.
+const maxNoteSize = 1 << 20
+
+// apiNote is the JSON representation of a note.
+type apiNote struct {
+	Title    string   `json:"title"`
+	Text     string   `json:"text"`
+	Markdown bool     `json:"markdown"`
+	Notebook string   `json:"notebook"`
+	Tags     []string `json:"tags"`
+	Pinned   bool     `json:"pinned"`
+	Archived bool     `json:"archived"`
+	Color    string   `json:"color"`
+	Deleted  bool     `json:"deleted,omitempty"`
+	Version  int      `json:"version"`
+}
+
+// noteETag returns the entity tag of a version of a note.
+func noteETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// parseVersions returns the note versions in an If-Match or If-None-Match
+// header. wildcard is set for "*".
+func parseVersions(header string) (versions []int, wildcard bool) {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" {
+			wildcard = true
+			continue
+		}
+		// Weak tags are ignored: a version identifies the exact content.
+		if !strings.HasPrefix(t, `"`) || !strings.HasSuffix(t, `"`) || len(t) < 2 {
+			continue
+		}
+		if v, err := strconv.Atoi(t[1 : len(t)-1]); err == nil {
+			versions = append(versions, v)
+		}
+	}
+	return versions, wildcard
+}
+
+// noteRef returns the note a request is about: the "title" parameter and the
+// "owner" one, which defaults to the user for their own notes.
+func noteRef(r *safehttp.IncomingRequest) (owner, title string, ok bool) {
+	q, err := r.URL.Query()
+	if err != nil {
+		return "", "", false
+	}
+	owner = q.String("owner", auth.User(r))
+	title = q.String("title", "")
+	return owner, title, owner != "" && title != ""
+}
+
+// writeAccessError writes the error for an ErrNotFound or ErrForbidden
+// returned by the storage.
+func writeAccessError(rw safehttp.ResponseWriter, err error) safehttp.Result {
+	if errors.Is(err, storage.ErrForbidden) {
+		return rw.WriteError(safehttp.StatusForbidden)
+	}
+	return rw.WriteError(safehttp.StatusNotFound)
+}
+
+// getNoteAPIHandler returns a note of the user, or one shared with them. It
+// supports If-None-Match, so clients can cheaply check whether a note changed.
+func getNoteAPIHandler(deps *serverDeps) safehttp.Handler {
+	return safehttp.HandlerFunc(func(rw safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		owner, title, ok := noteRef(r)
+		if !ok {
+			return rw.WriteError(safehttp.StatusBadRequest)
+		}
+		n, _, err := deps.db.GetNoteAs(auth.User(r), owner, title)
+		if err != nil {
+			return writeAccessError(rw, err)
+		}
+		rw.Header().Set("ETag", noteETag(n.Version))
+		versions, wildcard := parseVersions(r.Header.Get("If-None-Match"))
+		if wildcard {
+			return rw.Write(responses.NotModified{})
+		}
+		for _, v := range versions {
+			if v == n.Version {
+				return rw.Write(responses.NotModified{})
+			}
+		}
+		return rw.Write(safehttp.JSONResponse{Data: apiNote(n)})
+	})
+}
+
+// putNoteAPIHandler creates or updates a note, whose text is sent as JSON.
+//
+// To not overwrite concurrent edits, updates must have an If-Match header
+// with the ETag of the version they are based on, and creations an
+// If-None-Match: * header. Notes shared with the user can be updated if they
+// are an editor.
+func putNoteAPIHandler(deps *serverDeps) safehttp.Handler {
+	return safehttp.HandlerFunc(func(rw safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		owner, title, ok := noteRef(r)
+		if !ok {
+			return rw.WriteError(safehttp.StatusBadRequest)
+		}
+		var version int
+		switch ifMatch, ifNoneMatch := r.Header.Get("If-Match"), r.Header.Get("If-None-Match"); {
+		case ifMatch != "":
+			versions, wildcard := parseVersions(ifMatch)
+			if wildcard || len(versions) != 1 || versions[0] < 1 {
+				// A version is needed to detect concurrent edits.
+				return rw.WriteError(responses.TextError{
+					StatusCode: safehttp.StatusPreconditionRequired,
+					Body:       "If-Match must contain exactly one ETag of the note.",
+				})
+			}
+			version = versions[0]
+		case ifNoneMatch == "*":
+			version = 0
+		default:
+			return rw.WriteError(responses.TextError{
+				StatusCode: safehttp.StatusPreconditionRequired,
+				Body:       "Use If-Match to update a note, or If-None-Match: * to create one.",
+			})
+		}
+
+		var body struct {
+			Text     string   `json:"text"`
+			Markdown bool     `json:"markdown"`
+			Tags     []string `json:"tags"`
+		}
+		dec := json.NewDecoder(io.LimitReader(r.Body(), maxNoteSize))
+		if err := dec.Decode(&body); err != nil || body.Text == "" {
+			return rw.WriteError(safehttp.StatusBadRequest)
+		}
+
+		n, err := deps.db.EditNoteAs(auth.User(r), owner, storage.Note{Title: title, Text: body.Text, Markdown: body.Markdown, Tags: body.Tags, Version: version})
+		switch {
+		case errors.Is(err, storage.ErrInvalidTag):
+			return rw.WriteError(safehttp.StatusBadRequest)
+		case errors.Is(err, storage.ErrConflict):
+			rw.Header().Set("ETag", noteETag(n.Version))
+			return rw.WriteError(safehttp.StatusPreconditionFailed)
+		case err != nil:
+			return writeAccessError(rw, err)
+		}
+		rw.Header().Set("ETag", noteETag(n.Version))
+		return rw.Write(safehttp.JSONResponse{Data: apiNote(n)})
+	})
+}
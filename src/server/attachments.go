@@ -0,0 +1,294 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/safehtml/template"
+
+	"github.com/empijei/go-safeweb-example-app/src/blobstore"
+	"github.com/empijei/go-safeweb-example-app/src/images"
+	"github.com/empijei/go-safeweb-example-app/src/secure/auth"
+	"github.com/empijei/go-safeweb-example-app/src/secure/responses"
+	"github.com/empijei/go-safeweb-example-app/src/storage"
+)
+
+const (
+	// attachmentsPath is where attachments are downloaded from, followed by
+	// their ID. It is only used for downloads, see secure.Download.
+	attachmentsPath = "/attachments/"
+	// thumbnailSuffix follows the attachment ID in the path of thumbnails.
+	thumbnailSuffix = "/thumbnail"
+	// maxAttachmentSize is the maximum size of an attachment. Request bodies
+	// are also bounded by the server.max_body_bytes setting.
+	maxAttachmentSize = 10 << 20
+	// maxAttachmentName is the maximum length of attachment names, in runes.
+	maxAttachmentName = 100
+)
+
+// attachmentTypes are the types of files that can be attached, as sniffed
+// from their content, and the extension their names get.
+var attachmentTypes = map[string]string{
+	"image/png":       ".png",
+	"image/jpeg":      ".jpg",
+	"image/gif":       ".gif",
+	"image/webp":      ".webp",
+	"application/pdf": ".pdf",
+}
+
+var invalidAttachmentErr = responses.NewError(
+	safehttp.StatusBadRequest,
+	template.MustParseAndExecuteToHTML("Please attach one PNG, JPEG, GIF or WebP image, or PDF document, of up to 10 MiB."),
+)
+
+// uploadAttachmentHandler attaches the file in the "file" field of a
+// multipart form to a note of the user, or shared with them as an editor.
+//
+// The type of the file is sniffed from its content rather than trusted from
+// the request, and only the types in attachmentTypes are accepted. The
+// metadata of images is stripped, see images.StripMetadata.
+func uploadAttachmentHandler(deps *serverDeps) safehttp.Handler {
+	tooManyErr := responses.NewError(
+		safehttp.StatusBadRequest,
+		template.MustParseAndExecuteToHTML("This note has too many attachments, delete some first."),
+	)
+
+	return safehttp.HandlerFunc(func(rw safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		// The form was parsed already by the XSRF interceptor, which spills
+		// files to disk.
+		form, err := r.MultipartForm(32 << 20)
+		if err != nil {
+			return rw.WriteError(invalidAttachmentErr)
+		}
+		defer form.RemoveFiles()
+		user := auth.User(r)
+		owner := form.String("owner", user)
+		title := form.String("title", "")
+		files := form.File("file")
+		if len(files) != 1 || files[0].Size > maxAttachmentSize {
+			return rw.WriteError(invalidAttachmentErr)
+		}
+		f, err := files[0].Open()
+		if err != nil {
+			return rw.WriteError(invalidAttachmentErr)
+		}
+		defer f.Close()
+		data, err := ioutil.ReadAll(io.LimitReader(f, maxAttachmentSize))
+		if err != nil {
+			return rw.WriteError(invalidAttachmentErr)
+		}
+		contentType := http.DetectContentType(data)
+		ext, ok := attachmentTypes[contentType]
+		if !ok {
+			return rw.WriteError(invalidAttachmentErr)
+		}
+		// Photos often carry the location they were taken at.
+		if data, err = images.StripMetadata(data, contentType); err != nil {
+			return rw.WriteError(invalidAttachmentErr)
+		}
+
+		a := storage.Attachment{
+			ID:          storage.NewAttachmentID(),
+			Owner:       owner,
+			Title:       title,
+			Name:        attachmentName(files[0].Filename, ext),
+			ContentType: contentType,
+			Size:        int64(len(data)),
+			Created:     time.Now(),
+		}
+		ctx := r.Context()
+		if err := deps.blobs.Put(ctx, a.ID, bytes.NewReader(data)); err != nil {
+			log.Printf("Storing attachment: %v", err)
+			return rw.WriteError(safehttp.StatusInternalServerError)
+		}
+		if err := deps.db.AddAttachment(user, a); err != nil {
+			deleteBlobs(ctx, deps.blobs, []storage.Attachment{a})
+			if errors.Is(err, storage.ErrTooManyAttachments) {
+				return rw.WriteError(tooManyErr)
+			}
+			return writeAccessError(rw, err)
+		}
+		return redirectToEditAs(rw, r, owner, title)
+	})
+}
+
+// attachmentName returns the name an attachment is saved as: the base name of
+// the uploaded file, without control characters, with the extension of its
+// sniffed type so that it is not opened as another type once downloaded.
+func attachmentName(name, ext string) string {
+	// Some browsers send the whole path of the file, with either separator.
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == utf8.RuneError {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSuffix(name, path.Ext(name))
+	if rs := []rune(name); len(rs) > maxAttachmentName {
+		name = string(rs[:maxAttachmentName])
+	}
+	if strings.Trim(name, ". ") == "" || name == "/" {
+		name = "attachment"
+	}
+	return name + ext
+}
+
+// downloadAttachmentHandler serves the attachments of the notes the user can
+// see, at attachmentsPath followed by their ID, and the thumbnails of images
+// at the same path followed by thumbnailSuffix. Attachments are always
+// downloaded, never rendered, see responses.Download: thumbnails are still
+// shown by img elements.
+func downloadAttachmentHandler(deps *serverDeps) safehttp.Handler {
+	return safehttp.HandlerFunc(func(rw safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		id := strings.TrimPrefix(r.URL.Path(), attachmentsPath)
+		id, thumb := trimSuffix(id, thumbnailSuffix)
+		a, err := deps.db.GetAttachmentAs(auth.User(r), id)
+		if err != nil {
+			return rw.WriteError(safehttp.StatusNotFound)
+		}
+		if thumb {
+			data, err := thumbnail(r.Context(), deps.blobs, a)
+			if err != nil {
+				log.Printf("Making thumbnail of attachment %q: %v", a.ID, err)
+				return rw.WriteError(safehttp.StatusNotFound)
+			}
+			contentType := images.ThumbnailType(a.ContentType)
+			return rw.Write(responses.Download{
+				Name:        "thumbnail-" + attachmentName(a.Name, attachmentTypes[contentType]),
+				ContentType: contentType,
+				Size:        int64(len(data)),
+				Body:        ioutil.NopCloser(bytes.NewReader(data)),
+			})
+		}
+		body, err := deps.blobs.Get(r.Context(), a.ID)
+		if err != nil {
+			log.Printf("Loading attachment %q: %v", a.ID, err)
+			return rw.WriteError(safehttp.StatusNotFound)
+		}
+		return rw.Write(responses.Download{Name: a.Name, ContentType: a.ContentType, Size: a.Size, Body: body})
+	})
+}
+
+// trimSuffix returns s without suffix, and whether it had it.
+func trimSuffix(s, suffix string) (string, bool) {
+	t := strings.TrimSuffix(s, suffix)
+	return t, t != s
+}
+
+// thumbnailKey returns the key of the thumbnail of an attachment in the blob
+// store. Attachment IDs all have the same length, so it cannot be the ID of
+// another attachment.
+func thumbnailKey(a storage.Attachment) string {
+	return a.ID + "-thumbnail"
+}
+
+// hasThumbnail reports whether thumbnails can be made of an attachment.
+func hasThumbnail(a storage.Attachment) bool {
+	return images.ThumbnailType(a.ContentType) != ""
+}
+
+// thumbnail returns the thumbnail of an attachment, making it the first time
+// and caching it in the blob store.
+func thumbnail(ctx context.Context, blobs blobstore.Store, a storage.Attachment) ([]byte, error) {
+	if !hasThumbnail(a) {
+		return nil, images.ErrUnsupported
+	}
+	if data, err := readBlob(ctx, blobs, thumbnailKey(a)); !errors.Is(err, blobstore.ErrNotFound) {
+		return data, err
+	}
+	data, err := readBlob(ctx, blobs, a.ID)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = images.Thumbnail(data, a.ContentType); err != nil {
+		return nil, err
+	}
+	// Concurrent requests may both make the thumbnail, which is harmless.
+	if err := blobs.Put(ctx, thumbnailKey(a), bytes.NewReader(data)); err != nil {
+		log.Printf("Caching thumbnail of attachment %q: %v", a.ID, err)
+	}
+	return data, nil
+}
+
+func readBlob(ctx context.Context, blobs blobstore.Store, key string) ([]byte, error) {
+	body, err := blobs.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return ioutil.ReadAll(body)
+}
+
+func deleteAttachmentHandler(deps *serverDeps) safehttp.Handler {
+	return safehttp.HandlerFunc(func(rw safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		form, err := r.PostForm()
+		if err != nil {
+			return rw.WriteError(safehttp.StatusBadRequest)
+		}
+		a, err := deps.db.DeleteAttachmentAs(auth.User(r), form.String("id", ""))
+		if err != nil {
+			return writeAccessError(rw, err)
+		}
+		deleteBlobs(r.Context(), deps.blobs, []storage.Attachment{a})
+		return redirectToEditAs(rw, r, a.Owner, a.Title)
+	})
+}
+
+// deleteBlobs deletes the content of attachments that were removed from the
+// storage, and their thumbnails. Failures only leave unreachable blobs
+// behind, so they are logged.
+func deleteBlobs(ctx context.Context, blobs blobstore.Store, as []storage.Attachment) {
+	for _, a := range as {
+		for _, key := range []string{a.ID, thumbnailKey(a)} {
+			if err := blobs.Delete(ctx, key); err != nil {
+				log.Printf("Deleting attachment %q: %v", a.ID, err)
+			}
+		}
+	}
+}
+
+// redirectToEditAs redirects to the edit page of a note of owner.
+func redirectToEditAs(rw safehttp.ResponseWriter, r *safehttp.IncomingRequest, owner, title string) safehttp.Result {
+	q := url.Values{"owner": {owner}, "title": {title}}
+	return safehttp.Redirect(rw, r, "/notes/edit?"+q.Encode(), safehttp.StatusSeeOther)
+}
+
+// PurgeTrash returns a job that permanently deletes the notes that have been
+// in the trash for longer than retention, with their attachments.
+func PurgeTrash(db *storage.DB, blobs blobstore.Store, retention time.Duration) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		n, as := db.PurgeTrash(time.Now().Add(-retention))
+		deleteBlobs(ctx, blobs, as)
+		if n > 0 {
+			log.Printf("Purged %d notes from the trash", n)
+		}
+		return nil
+	}
+}
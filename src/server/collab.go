@@ -0,0 +1,57 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"errors"
+
+	"github.com/google/go-safeweb/safehttp"
+
+	"github.com/empijei/go-safeweb-example-app/src/secure/auth"
+	"github.com/empijei/go-safeweb-example-app/src/secure/websocket"
+	"github.com/empijei/go-safeweb-example-app/src/storage"
+)
+
+// collabHandler lets users edit a note together over a WebSocket. See
+// package collab for the protocol and static/collab.js for the client.
+//
+// The handshake goes through the interceptors like any other request, so
+// only authenticated users get here. Owners and editors of a note can edit it,
+// the hub checks that they still can for every edit.
+func collabHandler(deps *serverDeps) safehttp.Handler {
+	return safehttp.HandlerFunc(func(rw safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		owner, title, ok := noteRef(r)
+		if !ok {
+			return rw.WriteError(safehttp.StatusBadRequest)
+		}
+		user := auth.User(r)
+		switch role := deps.db.NoteRole(user, owner, title); {
+		case role == storage.NoAccess:
+			return rw.WriteError(safehttp.StatusNotFound)
+		case !role.CanEdit():
+			return rw.WriteError(safehttp.StatusForbidden)
+		}
+		upgrade, err := websocket.Accept(r, func(conn *websocket.Conn) {
+			deps.collab.Serve(conn, owner, title, user)
+		})
+		switch {
+		case errors.Is(err, websocket.ErrCrossOrigin):
+			return rw.WriteError(safehttp.StatusForbidden)
+		case err != nil:
+			return rw.WriteError(safehttp.StatusBadRequest)
+		}
+		return rw.Write(upgrade)
+	})
+}
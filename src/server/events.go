@@ -0,0 +1,68 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"errors"
+
+	"github.com/google/go-safeweb/safehttp"
+
+	"github.com/empijei/go-safeweb-example-app/src/secure/auth"
+	"github.com/empijei/go-safeweb-example-app/src/secure/sse"
+	"github.com/empijei/go-safeweb-example-app/src/storage"
+)
+
+// notesEventsHandler streams the notes of the user as they change, so that
+// the notes page stays up to date. See static/live.js for the client.
+//
+// The stream starts with a "notes" event with all the notes, followed by a
+// "note" event for every note that is added or edited.
+func notesEventsHandler(deps *serverDeps) safehttp.Handler {
+	return safehttp.HandlerFunc(func(rw safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		user := auth.User(r)
+		// Subscribe before listing the notes, so that no change is missed.
+		notes, cancel, err := deps.db.Subscribe(user)
+		switch {
+		case errors.Is(err, storage.ErrTooManySubscriptions):
+			return rw.WriteError(safehttp.StatusTooManyRequests)
+		case err != nil:
+			return rw.WriteError(safehttp.StatusServiceUnavailable)
+		}
+		defer cancel()
+
+		var all []apiNote
+		for _, n := range deps.db.GetNotes(user) {
+			all = append(all, apiNote(n))
+		}
+
+		ctx := r.Context()
+		events := make(chan sse.Event)
+		go func() {
+			defer close(events)
+			for n := range notes {
+				select {
+				case events <- sse.Event{Name: "note", Data: apiNote(n)}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		return rw.Write(sse.Stream{
+			Context: ctx,
+			Initial: []sse.Event{{Name: "notes", Data: all}},
+			Events:  events,
+		})
+	})
+}
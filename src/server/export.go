@@ -0,0 +1,523 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/safehtml/template"
+
+	"github.com/empijei/go-safeweb-example-app/src/blobstore"
+	"github.com/empijei/go-safeweb-example-app/src/notefile"
+	"github.com/empijei/go-safeweb-example-app/src/secure/auth"
+	"github.com/empijei/go-safeweb-example-app/src/secure/envelope"
+	"github.com/empijei/go-safeweb-example-app/src/secure/responses"
+	"github.com/empijei/go-safeweb-example-app/src/storage"
+)
+
+const (
+	// exportFormat is the version of the format of exports, in their
+	// manifest. It must be incremented by incompatible changes.
+	exportFormat = 1
+	// manifestName is the name of the manifest in exports.
+	manifestName = "manifest.json"
+	// maxExports is how many times users can export their data in
+	// exportWindow.
+	maxExports   = 5
+	exportWindow = time.Hour
+	// exportTimeout bounds the time to build an export.
+	exportTimeout = 10 * time.Minute
+	// exportRetention is how long finished exports can be downloaded. They
+	// are deleted afterwards, as their keys are only kept until then.
+	exportRetention = time.Hour
+	// exportKeyPrefix starts the keys of exports in the blob store.
+	exportKeyPrefix = "export-"
+	// exportPath is the page showing the status of exports.
+	exportPath = "/account/export"
+)
+
+// exportManifest describes the content of an export, for programs.
+type exportManifest struct {
+	Format    int              `json:"format"`
+	User      string           `json:"user"`
+	Exported  time.Time        `json:"exported"`
+	Notebooks []exportNotebook `json:"notebooks"`
+	Notes     []exportNote     `json:"notes"`
+}
+
+type exportNotebook struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Parent string `json:"parent,omitempty"`
+}
+
+type exportNote struct {
+	// File is the path of the note in the export.
+	File        string             `json:"file"`
+	Title       string             `json:"title"`
+	Notebook    string             `json:"notebook,omitempty"`
+	Tags        []string           `json:"tags"`
+	Markdown    bool               `json:"markdown"`
+	Pinned      bool               `json:"pinned"`
+	Archived    bool               `json:"archived"`
+	Color       string             `json:"color,omitempty"`
+	Version     int                `json:"version"`
+	Attachments []exportAttachment `json:"attachments"`
+}
+
+type exportAttachment struct {
+	// File is the path of the attachment in the export.
+	File        string    `json:"file"`
+	Name        string    `json:"name"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	Created     time.Time `json:"created"`
+}
+
+// finishedExport is an export kept in the blob store until it expires.
+type finishedExport struct {
+	// Key is the key of the zip in the blob store.
+	Key     string
+	Name    string
+	Size    int64
+	Expires time.Time
+
+	// key encrypts the zip in the blob store. It is only kept in memory, so
+	// that exports left behind, e.g. by a restart, cannot be read.
+	key *envelope.StreamKey
+}
+
+var (
+	errExportRunning  = errors.New("an export is running")
+	errTooManyExports = errors.New("too many exports")
+)
+
+// exportStore runs the exports of users: it limits how often each user
+// exports their data, as exports are expensive, lets them run one export at a
+// time, and keeps their last finished export until it expires.
+type exportStore struct {
+	blobs blobstore.Store
+
+	mu      sync.Mutex
+	running map[string]bool
+	// recent are the times of the exports of each user that finished in the
+	// last exportWindow. Failed exports do not count.
+	recent   map[string][]time.Time
+	finished map[string]*finishedExport
+}
+
+func newExportStore(blobs blobstore.Store) *exportStore {
+	return &exportStore{
+		blobs:    blobs,
+		running:  map[string]bool{},
+		recent:   map[string][]time.Time{},
+		finished: map[string]*finishedExport{},
+	}
+}
+
+// start returns nil if user can start an export now. If so, finish must be
+// called once it ends.
+func (s *exportStore) start(user string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running[user] {
+		return errExportRunning
+	}
+	if len(s.recentLocked(user, now)) >= maxExports {
+		return errTooManyExports
+	}
+	s.running[user] = true
+	return nil
+}
+
+func (s *exportStore) recentLocked(user string, now time.Time) []time.Time {
+	var recent []time.Time
+	for _, t := range s.recent[user] {
+		if now.Sub(t) < exportWindow {
+			recent = append(recent, t)
+		}
+	}
+	s.recent[user] = recent
+	return recent
+}
+
+// finish ends the running export of user, and keeps f if it succeeded. The
+// previous export of user is deleted.
+func (s *exportStore) finish(user string, f *finishedExport, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.running, user)
+	if f == nil {
+		return
+	}
+	s.recent[user] = append(s.recentLocked(user, now), now)
+	if old := s.finished[user]; old != nil {
+		s.deleteBlob(old)
+	}
+	s.finished[user] = f
+	time.AfterFunc(f.Expires.Sub(now), func() { s.expire(user, f) })
+}
+
+// status returns whether an export of user is running, and their finished
+// export if any.
+func (s *exportStore) status(user string) (bool, *finishedExport) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.running[user], s.finished[user]
+}
+
+// expire deletes f, unless a newer export replaced it.
+func (s *exportStore) expire(user string, f *finishedExport) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.finished[user] == f {
+		delete(s.finished, user)
+		s.deleteBlob(f)
+	}
+}
+
+// drop deletes the finished export of user, if any.
+func (s *exportStore) drop(user string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if f := s.finished[user]; f != nil {
+		delete(s.finished, user)
+		s.deleteBlob(f)
+	}
+}
+
+// sweep deletes the exports left in the blob store by a previous run of the
+// program, which cannot be decrypted anymore. It must be called before any
+// export starts.
+func (s *exportStore) sweep(ctx context.Context) error {
+	keys, err := s.blobs.List(ctx, exportKeyPrefix)
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err := s.blobs.Delete(ctx, k); err != nil {
+			return err
+		}
+	}
+	if len(keys) > 0 {
+		log.Printf("Deleted %d exports left behind", len(keys))
+	}
+	return nil
+}
+
+func (s *exportStore) deleteBlob(f *finishedExport) {
+	// Exports are short-lived, failures only leave unreachable blobs behind.
+	if err := s.blobs.Delete(context.Background(), f.Key); err != nil {
+		log.Printf("Deleting export: %v", err)
+	}
+}
+
+// exportHandler starts an export of all the notes of the user: a zip of
+// Markdown files with front matter (see package notefile), their attachments
+// and a manifest describing them. It then redirects to the export page, see
+// getExportHandler.
+//
+// The zip is built in the background into the blob store, rather than sent
+// while it is written, so that building it is not bound to the timeouts of
+// the request. Exports are rate limited and recorded in the audit log.
+func exportHandler(deps *serverDeps) safehttp.Handler {
+	tooManyErr := responses.NewError(
+		safehttp.StatusTooManyRequests,
+		template.MustParseAndExecuteToHTML("You exported your data too many times recently, please try again later."),
+	)
+
+	return safehttp.HandlerFunc(func(rw safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		user := auth.User(r)
+		now := time.Now()
+		switch err := deps.exports.start(user, now); err {
+		case errExportRunning:
+			return safehttp.Redirect(rw, r, exportPath, safehttp.StatusSeeOther)
+		case errTooManyExports:
+			deps.audit.Log(user, "export.rate_limited", nil)
+			return rw.WriteError(tooManyErr)
+		}
+		e := newExport(deps, user, now)
+		deps.audit.Log(user, "export.started", map[string]interface{}{"notes": len(e.manifest.Notes)})
+		go e.build(user, now)
+		return safehttp.Redirect(rw, r, exportPath, safehttp.StatusSeeOther)
+	})
+}
+
+// getExportHandler shows whether the export of the user is ready, and links
+// to it once it is.
+func getExportHandler(deps *serverDeps) safehttp.Handler {
+	return safehttp.HandlerFunc(func(rw safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		running, finished := deps.exports.status(auth.User(r))
+		return safehttp.ExecuteNamedTemplate(rw, templates, "export.tpl.html", map[string]interface{}{
+			"running":  running,
+			"finished": finished,
+		})
+	})
+}
+
+// downloadExportHandler serves the finished export of the user. It can be
+// downloaded again until it expires, e.g. if a download was interrupted.
+func downloadExportHandler(deps *serverDeps) safehttp.Handler {
+	return safehttp.HandlerFunc(func(rw safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		user := auth.User(r)
+		_, f := deps.exports.status(user)
+		if f == nil {
+			return rw.WriteError(safehttp.StatusNotFound)
+		}
+		sealed, err := deps.blobs.Get(r.Context(), f.Key)
+		if err != nil {
+			log.Printf("Loading export: %v", err)
+			return rw.WriteError(safehttp.StatusNotFound)
+		}
+		body := struct {
+			io.Reader
+			io.Closer
+		}{f.key.Decrypt(sealed), sealed}
+		deps.audit.Log(user, "export.downloaded", map[string]interface{}{"bytes": f.Size})
+		return rw.Write(responses.Download{Name: f.Name, ContentType: "application/zip", Size: f.Size, Body: body})
+	})
+}
+
+// export is the content of an export of the data of a user.
+type export struct {
+	deps     *serverDeps
+	manifest exportManifest
+	notes    []storage.Note
+	// attachments are the attachments of notes, in the same order, which are
+	// only read from the blob store while the export is written.
+	attachments [][]storage.Attachment
+	paths       map[string][]string
+}
+
+func newExport(deps *serverDeps, user string, now time.Time) *export {
+	e := &export{
+		deps:     deps,
+		manifest: exportManifest{Format: exportFormat, User: user, Exported: now.UTC(), Notebooks: []exportNotebook{}, Notes: []exportNote{}},
+		paths:    map[string][]string{},
+	}
+	tree := loadNotebookTree(deps, user)
+	for _, nb := range deps.db.GetNotebooks(user) {
+		e.manifest.Notebooks = append(e.manifest.Notebooks, exportNotebook{ID: nb.ID, Name: nb.Name, Parent: nb.Parent})
+		for _, p := range tree.path(nb.ID) {
+			e.paths[nb.ID] = append(e.paths[nb.ID], p.Name)
+		}
+	}
+	attachments := deps.db.GetAllAttachments(user)
+	files := map[string]bool{}
+	notes := deps.db.GetNotes(user)
+	storage.SortNotes(notes)
+	for _, n := range notes {
+		e.notes = append(e.notes, n)
+		e.attachments = append(e.attachments, attachments[n.Title])
+		e.manifest.Notes = append(e.manifest.Notes, exportNote{
+			File:        uniqueName(files, "notes/"+exportFileName(n.Title), ".md"),
+			Title:       n.Title,
+			Notebook:    n.Notebook,
+			Tags:        append([]string{}, n.Tags...),
+			Markdown:    n.Markdown,
+			Pinned:      n.Pinned,
+			Archived:    n.Archived,
+			Color:       n.Color,
+			Version:     n.Version,
+			Attachments: []exportAttachment{},
+		})
+	}
+	return e
+}
+
+// build writes the export of user to the blob store, encrypted with a new
+// key, and records it as finished, or failed, in the export store and the
+// audit log.
+func (e *export) build(user string, now time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+	key, err := envelope.NewStreamKey()
+	if err != nil {
+		e.deps.audit.Log(user, "export.failed", map[string]interface{}{"error": err.Error()})
+		e.deps.exports.finish(user, nil, time.Now())
+		return
+	}
+	f := &finishedExport{
+		Key:     exportKeyPrefix + newImportID(),
+		Name:    "notekeeper-export-" + now.Format("2006-01-02") + ".zip",
+		Expires: now.Add(exportRetention),
+		key:     key,
+	}
+	pr, pw := io.Pipe()
+	enc := key.Encrypt(pw)
+	// Counts the size of the zip, as downloaded.
+	cw := &countingWriter{w: enc}
+	written := make(chan struct{})
+	go func() {
+		err := e.write(ctx, cw)
+		if err == nil {
+			err = enc.Close()
+		}
+		pw.CloseWithError(err)
+		close(written)
+	}()
+	err = e.deps.blobs.Put(ctx, f.Key, pr)
+	// Stops the writer if Put failed before reading everything.
+	pr.CloseWithError(errors.New("export aborted"))
+	<-written
+	f.Size = cw.n
+	if err == nil && !e.deps.db.HasUser(user) {
+		err = errors.New("the account was deleted")
+	}
+
+	details := map[string]interface{}{"bytes": f.Size}
+	if err != nil {
+		if derr := e.deps.blobs.Delete(context.Background(), f.Key); derr != nil {
+			log.Printf("Deleting failed export: %v", derr)
+		}
+		details["error"] = err.Error()
+		e.deps.audit.Log(user, "export.failed", details)
+		e.deps.exports.finish(user, nil, time.Now())
+		return
+	}
+	e.deps.audit.Log(user, "export.completed", details)
+	e.deps.exports.finish(user, f, time.Now())
+}
+
+// write writes the export as a zip to w. The attachments that cannot be read
+// from the blob store are left out, and the manifest, written last, only lists
+// the ones in the zip.
+func (e *export) write(ctx context.Context, w io.Writer) error {
+	zw := zip.NewWriter(w)
+	for i, n := range e.notes {
+		note := &e.manifest.Notes[i]
+		for _, a := range e.attachments[i] {
+			ea := exportAttachment{
+				File:        path.Join("attachments", a.ID, a.Name),
+				Name:        a.Name,
+				ContentType: a.ContentType,
+				Size:        a.Size,
+				Created:     a.Created.UTC(),
+			}
+			ok, err := e.writeAttachment(ctx, zw, ea.File, a)
+			if err != nil {
+				return err
+			}
+			if ok {
+				note.Attachments = append(note.Attachments, ea)
+			}
+		}
+
+		fm := notefile.FrontMatter{
+			Title:    n.Title,
+			Notebook: e.paths[n.Notebook],
+			Tags:     n.Tags,
+			Markdown: n.Markdown,
+			Pinned:   n.Pinned,
+			Archived: n.Archived,
+			Color:    n.Color,
+		}
+		for _, a := range note.Attachments {
+			// Relative to the note, so that links work once extracted.
+			fm.Attachments = append(fm.Attachments, "../"+a.File)
+		}
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: note.File, Method: zip.Deflate, Modified: e.manifest.Exported})
+		if err != nil {
+			return err
+		}
+		if err := notefile.Write(f, fm, n.Text); err != nil {
+			return err
+		}
+	}
+
+	f, err := zw.CreateHeader(&zip.FileHeader{Name: manifestName, Method: zip.Deflate, Modified: e.manifest.Exported})
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(e.manifest); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// writeAttachment copies an attachment from the blob store to the zip. It
+// returns false, without an error, if the attachment cannot be read.
+func (e *export) writeAttachment(ctx context.Context, zw *zip.Writer, name string, a storage.Attachment) (bool, error) {
+	body, err := e.deps.blobs.Get(ctx, a.ID)
+	if err != nil {
+		log.Printf("Exporting attachment %q: %v", a.ID, err)
+		return false, nil
+	}
+	defer body.Close()
+	// Images and PDFs are compressed already.
+	f, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: a.Created})
+	if err != nil {
+		return false, err
+	}
+	_, err = io.Copy(f, body)
+	return err == nil, err
+}
+
+// exportFileName returns a file name for a note title that is valid on common
+// file systems: without separators, characters Windows rejects, control
+// characters, or leading dots.
+func exportFileName(title string) string {
+	name := strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, title)
+	name = strings.TrimLeft(strings.TrimSpace(name), ".")
+	if rs := []rune(name); len(rs) > maxAttachmentName {
+		name = string(rs[:maxAttachmentName])
+	}
+	if name == "" {
+		name = "note"
+	}
+	return name
+}
+
+// uniqueName returns base+ext, numbered if needed to not be one of the used
+// names, which are compared ignoring case for case-insensitive file systems.
+func uniqueName(used map[string]bool, base, ext string) string {
+	name := base + ext
+	for i := 2; used[strings.ToLower(name)]; i++ {
+		name = base + " (" + strconv.Itoa(i) + ")" + ext
+	}
+	used[strings.ToLower(name)] = true
+	return name
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
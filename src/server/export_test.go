@@ -0,0 +1,113 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/empijei/go-safeweb-example-app/src/audit"
+	"github.com/empijei/go-safeweb-example-app/src/blobstore"
+	"github.com/empijei/go-safeweb-example-app/src/secure/envelope"
+	"github.com/empijei/go-safeweb-example-app/src/storage"
+)
+
+func readAll(t *testing.T, blobs blobstore.Store, key string) []byte {
+	t.Helper()
+	r, err := blobs.Get(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestExportIsEncrypted(t *testing.T) {
+	keys, err := envelope.NewKeyring([]string{"master"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := storage.NewDB(envelope.NewVault(keys, envelope.NewMemoryKeyStore()))
+	if err := db.AddOrAuthUser("alice", "pw"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.AddOrEditNote("alice", storage.Note{Title: "diary", Text: "a secret"}); err != nil {
+		t.Fatal(err)
+	}
+	blobs := blobstore.NewMemory()
+	deps := &serverDeps{db: db, blobs: blobs, audit: audit.New(ioutil.Discard), exports: newExportStore(blobs)}
+
+	now := time.Now()
+	if err := deps.exports.start("alice", now); err != nil {
+		t.Fatal(err)
+	}
+	newExport(deps, "alice", now).build("alice", now)
+	_, f := deps.exports.status("alice")
+	if f == nil {
+		t.Fatal("The export failed")
+	}
+
+	sealed := readAll(t, blobs, f.Key)
+	if bytes.Contains(sealed, []byte("a secret")) || bytes.HasPrefix(sealed, []byte("PK")) {
+		t.Error("The export is stored in plaintext")
+	}
+	zipped, err := ioutil.ReadAll(f.key.Decrypt(bytes.NewReader(sealed)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(zipped)) != f.Size {
+		t.Errorf("Size = %d, want the size of the zip, %d", f.Size, len(zipped))
+	}
+	zr, err := zip.NewReader(bytes.NewReader(zipped), int64(len(zipped)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, zf := range zr.File {
+		names = append(names, zf.Name)
+	}
+	if got := strings.Join(names, " "); got != "notes/diary.md "+manifestName {
+		t.Errorf("The export contains %q, want the note and the manifest", got)
+	}
+}
+
+func TestSweepExports(t *testing.T) {
+	ctx := context.Background()
+	blobs := blobstore.NewMemory()
+	for _, k := range []string{exportKeyPrefix + "old", exportKeyPrefix + "older", "attachment"} {
+		if err := blobs.Put(ctx, k, strings.NewReader("x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := newExportStore(blobs).sweep(ctx); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := blobs.List(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0] != "attachment" {
+		t.Errorf("Blobs after sweep() = %q, want only the attachment", keys)
+	}
+}
@@ -0,0 +1,343 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/safehtml/template"
+
+	"github.com/empijei/go-safeweb-example-app/src/importer"
+	"github.com/empijei/go-safeweb-example-app/src/secure/auth"
+	"github.com/empijei/go-safeweb-example-app/src/secure/responses"
+	"github.com/empijei/go-safeweb-example-app/src/storage"
+)
+
+const (
+	importPath = "/account/import"
+	// maxImportSize is the maximum size of an imported file. Request bodies
+	// are also bounded by the server.max_body_bytes setting.
+	maxImportSize = 16 << 20
+	// importTTL is how long imports wait to be reviewed.
+	importTTL = time.Hour
+	// excerptLen is the length, in runes, of the text of notes shown when
+	// reviewing an import.
+	excerptLen = 200
+)
+
+// The actions for each note of an import, chosen when reviewing it.
+const (
+	importNew = "import"
+	// importRename imports a note whose title is taken with another title.
+	importRename  = "rename"
+	importReplace = "replace"
+	importSkip    = "skip"
+)
+
+// pendingImport is an imported file, waiting for the user to review it.
+type pendingImport struct {
+	// ID identifies the import, so that a review does not apply to another
+	// import uploaded in the meantime.
+	ID      string
+	Created time.Time
+	Notes   []importer.Note
+	Skipped []string
+}
+
+// importStore holds the pending import of each user. Imports are kept in
+// memory, they are only needed until they are reviewed.
+type importStore struct {
+	mu     sync.Mutex
+	byUser map[string]*pendingImport
+}
+
+func newImportStore() *importStore {
+	return &importStore{byUser: map[string]*pendingImport{}}
+}
+
+// put replaces the pending import of user.
+func (s *importStore) put(user string, p *pendingImport) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.byUser[user] = p
+}
+
+// get returns the pending import of user, or nil.
+func (s *importStore) get(user string, now time.Time) *pendingImport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.byUser[user]
+	if p != nil && now.Sub(p.Created) > importTTL {
+		delete(s.byUser, user)
+		return nil
+	}
+	return p
+}
+
+// take removes the pending import of user and returns it, if it has the
+// given ID.
+func (s *importStore) take(user, id string, now time.Time) *pendingImport {
+	p := s.get(user, now)
+	if p == nil || p.ID != id {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.byUser[user] == p {
+		delete(s.byUser, user)
+		return p
+	}
+	return nil
+}
+
+// drop removes the pending import of user, if any.
+func (s *importStore) drop(user string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.byUser, user)
+}
+
+func newImportID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// importRow is a note of a pending import, as reviewed by the user.
+type importRow struct {
+	Index int
+	importer.Note
+	Excerpt string
+	// Conflict is set for notes whose title is taken, by a note of the user
+	// or a note before it in the import.
+	Conflict bool
+}
+
+// getImportHandler shows the form to import notes, and the notes of the
+// pending import of the user, if any, to review them.
+func getImportHandler(deps *serverDeps) safehttp.Handler {
+	return safehttp.HandlerFunc(func(rw safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		user := auth.User(r)
+		data := map[string]interface{}{"user": user}
+		if p := deps.imports.get(user, time.Now()); p != nil {
+			var rows []importRow
+			titles := map[string]bool{}
+			for i, n := range p.Notes {
+				_, exists := deps.db.GetNote(user, n.Title)
+				excerpt := n.Text
+				if rs := []rune(excerpt); len(rs) > excerptLen {
+					excerpt = string(rs[:excerptLen]) + "…"
+				}
+				rows = append(rows, importRow{Index: i, Note: n, Excerpt: excerpt, Conflict: exists || titles[n.Title]})
+				titles[n.Title] = true
+			}
+			data["pending"] = p
+			data["rows"] = rows
+		}
+		return safehttp.ExecuteNamedTemplate(rw, templates, "import.tpl.html", data)
+	})
+}
+
+// uploadImportHandler parses an imported file and keeps its notes for the
+// user to review them. Nothing is stored until then, see
+// commitImportHandler.
+func uploadImportHandler(deps *serverDeps) safehttp.Handler {
+	invalidErr := responses.NewError(
+		safehttp.StatusBadRequest,
+		template.MustParseAndExecuteToHTML("Please import one zip, Markdown, text, Google Keep JSON or Evernote ENEX file, of up to 16 MiB."),
+	)
+
+	return safehttp.HandlerFunc(func(rw safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		form, err := r.MultipartForm(32 << 20)
+		if err != nil {
+			return rw.WriteError(invalidErr)
+		}
+		defer form.RemoveFiles()
+		files := form.File("file")
+		if len(files) != 1 || files[0].Size > maxImportSize {
+			return rw.WriteError(invalidErr)
+		}
+		f, err := files[0].Open()
+		if err != nil {
+			return rw.WriteError(invalidErr)
+		}
+		defer f.Close()
+		data, err := ioutil.ReadAll(io.LimitReader(f, maxImportSize))
+		if err != nil {
+			return rw.WriteError(invalidErr)
+		}
+		res, err := importer.Parse(files[0].Filename, data)
+		if errors.Is(err, importer.ErrUnsupported) {
+			return rw.WriteError(invalidErr)
+		}
+		if err != nil {
+			return rw.WriteError(responses.NewError(
+				safehttp.StatusBadRequest,
+				template.MustParseAndExecuteToHTML("The file cannot be imported, it is too large once uncompressed."),
+			))
+		}
+		deps.imports.put(auth.User(r), &pendingImport{ID: newImportID(), Created: time.Now(), Notes: res.Notes, Skipped: res.Skipped})
+		return safehttp.Redirect(rw, r, importPath, safehttp.StatusSeeOther)
+	})
+}
+
+// commitImportHandler stores the notes of the pending import of the user as
+// reviewed: the "action" fields are "N:ACTION", where ACTION is one of the
+// import actions for the Nth note. Notes whose title is taken are renamed
+// unless they are replaced or skipped.
+func commitImportHandler(deps *serverDeps) safehttp.Handler {
+	return safehttp.HandlerFunc(func(rw safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		form, err := r.PostForm()
+		if err != nil {
+			return rw.WriteError(safehttp.StatusBadRequest)
+		}
+		user := auth.User(r)
+		p := deps.imports.take(user, form.String("id", ""), time.Now())
+		if p == nil {
+			// Expired, or replaced by another import.
+			return safehttp.Redirect(rw, r, importPath, safehttp.StatusSeeOther)
+		}
+
+		var fields []string
+		form.Slice("action", &fields)
+		actions := map[int]string{}
+		for _, f := range fields {
+			parts := strings.SplitN(f, ":", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			if i, err := strconv.Atoi(parts[0]); err == nil {
+				actions[i] = parts[1]
+			}
+		}
+
+		counts := map[string]int{}
+		notebooks := newNotebookResolver(deps, user)
+		for i, n := range p.Notes {
+			switch actions[i] {
+			case importNew, importRename, importReplace:
+			default:
+				// Notes that were not reviewed are skipped too.
+				counts["skipped"]++
+				continue
+			}
+			cur, exists := deps.db.GetNote(user, n.Title)
+			replace := exists && actions[i] == importReplace
+			note := storage.Note{Title: n.Title, Text: n.Text, Markdown: n.Markdown, Tags: n.Tags}
+			switch {
+			case replace:
+				// Edits keep the notebook and state of the replaced note.
+				note.Version = cur.Version
+			case exists:
+				note.Title = freeTitle(deps, user, n.Title)
+				fallthrough
+			default:
+				note.Notebook = notebooks.resolve(n.Notebook)
+			}
+			saved, err := deps.db.AddOrEditNote(user, note)
+			if err != nil {
+				counts["failed"]++
+				continue
+			}
+			if replace {
+				counts["replaced"]++
+				continue
+			}
+			counts["imported"]++
+			if n.Pinned {
+				deps.db.PinNote(user, saved.Title, true)
+			}
+			if n.Archived {
+				deps.db.ArchiveNote(user, saved.Title, true)
+			}
+			if n.Color != "" {
+				deps.db.ColorNote(user, saved.Title, n.Color)
+			}
+		}
+		details := map[string]interface{}{}
+		for k, v := range counts {
+			details[k] = v
+		}
+		deps.audit.Log(user, "import.completed", details)
+		return safehttp.Redirect(rw, r, "/notes/", safehttp.StatusSeeOther)
+	})
+}
+
+func cancelImportHandler(deps *serverDeps) safehttp.Handler {
+	return safehttp.HandlerFunc(func(rw safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		form, err := r.PostForm()
+		if err != nil {
+			return rw.WriteError(safehttp.StatusBadRequest)
+		}
+		deps.imports.take(auth.User(r), form.String("id", ""), time.Now())
+		return safehttp.Redirect(rw, r, "/notes/", safehttp.StatusSeeOther)
+	})
+}
+
+// freeTitle returns a title for an imported note whose title is taken.
+func freeTitle(deps *serverDeps, user, title string) string {
+	t := title + " (imported)"
+	for i := 2; ; i++ {
+		if _, exists := deps.db.GetNote(user, t); !exists {
+			return t
+		}
+		t = title + " (imported " + strconv.Itoa(i) + ")"
+	}
+}
+
+// notebookResolver finds the notebooks of imported notes by their path,
+// creating the missing ones.
+type notebookResolver struct {
+	deps *serverDeps
+	user string
+	// ids maps the parent and name of notebooks to their ID.
+	ids map[[2]string]string
+}
+
+func newNotebookResolver(deps *serverDeps, user string) *notebookResolver {
+	nr := &notebookResolver{deps: deps, user: user, ids: map[[2]string]string{}}
+	for _, nb := range deps.db.GetNotebooks(user) {
+		nr.ids[[2]string{nb.Parent, nb.Name}] = nb.ID
+	}
+	return nr
+}
+
+// resolve returns the ID of the notebook at path. If a notebook cannot be
+// created, e.g. because its name is not valid, it returns its parent.
+func (nr *notebookResolver) resolve(path []string) string {
+	parent := ""
+	for _, name := range path {
+		id, ok := nr.ids[[2]string{parent, name}]
+		if !ok {
+			nb, err := nr.deps.db.CreateNotebook(nr.user, name, parent)
+			if err != nil {
+				return parent
+			}
+			id = nb.ID
+			nr.ids[[2]string{parent, name}] = id
+		}
+		parent = id
+	}
+	return parent
+}
@@ -0,0 +1,115 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/safehtml/template"
+
+	"github.com/empijei/go-safeweb-example-app/src/secure/auth"
+	"github.com/empijei/go-safeweb-example-app/src/secure/responses"
+	"github.com/empijei/go-safeweb-example-app/src/storage"
+)
+
+// sharedPath is where public share links point to, followed by their token.
+const sharedPath = "/shared/"
+
+// maxLinkLifetime is the longest a share link can be valid for, unless it
+// never expires.
+const maxLinkLifetime = 365 * 24 * time.Hour
+
+// shareLink is a share link as shown to its owner.
+type shareLink struct {
+	storage.ShareLink
+	Token string
+}
+
+// getShareLinks returns the links to a note of owner, with their tokens.
+func getShareLinks(deps *serverDeps, owner, title string) []shareLink {
+	var ls []shareLink
+	for _, l := range deps.db.GetShareLinks(owner, title) {
+		ls = append(ls, shareLink{ShareLink: l, Token: deps.links.Token(l.ID, l.Expires)})
+	}
+	return ls
+}
+
+// createLinkHandler creates a share link to a note of the user, which expires
+// after the number of hours in the "expires" field, or never if it is 0.
+func createLinkHandler(deps *serverDeps) safehttp.Handler {
+	invalidLinkErr := responses.NewError(
+		safehttp.StatusBadRequest,
+		template.MustParseAndExecuteToHTML("Please specify a note and a valid expiry."),
+	)
+
+	return safehttp.HandlerFunc(func(rw safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		form, err := r.PostForm()
+		if err != nil {
+			return rw.WriteError(invalidLinkErr)
+		}
+		title := form.String("title", "")
+		hours := form.Int64("expires", -1)
+		if err := form.Err(); err != nil || hours < 0 || hours > int64(maxLinkLifetime/time.Hour) {
+			return rw.WriteError(invalidLinkErr)
+		}
+		var expires time.Time
+		if hours > 0 {
+			expires = time.Now().Add(time.Duration(hours) * time.Hour)
+		}
+		_, err = deps.db.CreateShareLink(auth.User(r), title, expires)
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			return rw.WriteError(safehttp.StatusNotFound)
+		case err != nil:
+			return rw.WriteError(invalidLinkErr)
+		}
+		return redirectToEdit(rw, r, title)
+	})
+}
+
+func revokeLinkHandler(deps *serverDeps) safehttp.Handler {
+	return safehttp.HandlerFunc(func(rw safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		form, err := r.PostForm()
+		if err != nil {
+			return rw.WriteError(safehttp.StatusBadRequest)
+		}
+		deps.db.RevokeShareLink(auth.User(r), form.String("id", ""))
+		return redirectToEdit(rw, r, form.String("title", ""))
+	})
+}
+
+// sharedNoteHandler shows a note to anyone with a valid share link. It is a
+// secure.PublicPage: the template must not load any script.
+func sharedNoteHandler(deps *serverDeps) safehttp.Handler {
+	return safehttp.HandlerFunc(func(rw safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		now := time.Now()
+		// Forged and expired tokens are rejected without a storage lookup.
+		id, err := deps.links.Verify(strings.TrimPrefix(r.URL.Path(), sharedPath), now)
+		if err != nil {
+			return rw.WriteError(safehttp.StatusNotFound)
+		}
+		n, l, err := deps.db.ResolveShareLink(id, now)
+		if err != nil {
+			return rw.WriteError(safehttp.StatusNotFound)
+		}
+		return safehttp.ExecuteNamedTemplate(rw, templates, "shared.tpl.html", map[string]interface{}{
+			"note":  n,
+			"owner": l.Owner,
+		})
+	})
+}
@@ -0,0 +1,213 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"errors"
+	"net/url"
+
+	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/safehtml/template"
+
+	"github.com/empijei/go-safeweb-example-app/src/secure/auth"
+	"github.com/empijei/go-safeweb-example-app/src/secure/responses"
+	"github.com/empijei/go-safeweb-example-app/src/storage"
+)
+
+// notebookTree is the notebooks of a user, with the number of notes in them.
+type notebookTree struct {
+	byID     map[string]storage.Notebook
+	children map[string][]storage.Notebook
+	counts   map[string]int
+}
+
+func loadNotebookTree(deps *serverDeps, user string) *notebookTree {
+	t := &notebookTree{
+		byID:     map[string]storage.Notebook{},
+		children: map[string][]storage.Notebook{},
+		counts:   deps.db.CountNotes(user),
+	}
+	// Notebooks are sorted by name, and so are the children of each one.
+	for _, nb := range deps.db.GetNotebooks(user) {
+		t.byID[nb.ID] = nb
+		t.children[nb.Parent] = append(t.children[nb.Parent], nb)
+	}
+	return t
+}
+
+// path returns the notebooks from the top level to id, for breadcrumbs.
+func (t *notebookTree) path(id string) []storage.Notebook {
+	var p []storage.Notebook
+	for id != "" {
+		nb := t.byID[id]
+		p = append([]storage.Notebook{nb}, p...)
+		id = nb.Parent
+	}
+	return p
+}
+
+// count returns the number of notes in id and in the notebooks in it.
+func (t *notebookTree) count(id string) int {
+	c := t.counts[id]
+	for _, child := range t.children[id] {
+		c += t.count(child.ID)
+	}
+	return c
+}
+
+// notebookEntry is a notebook as listed on the notes page.
+type notebookEntry struct {
+	storage.Notebook
+	Count int
+}
+
+func (t *notebookTree) entries(parent string) []notebookEntry {
+	var es []notebookEntry
+	for _, nb := range t.children[parent] {
+		es = append(es, notebookEntry{Notebook: nb, Count: t.count(nb.ID)})
+	}
+	return es
+}
+
+// notebookOption is a notebook in a select, named by its whole path.
+type notebookOption struct {
+	ID, Path string
+}
+
+// options returns the notebooks in depth-first order, except the ones in
+// skip, which can be empty.
+func (t *notebookTree) options(skip string) []notebookOption {
+	var opts []notebookOption
+	var walk func(parent, prefix string)
+	walk = func(parent, prefix string) {
+		for _, nb := range t.children[parent] {
+			if nb.ID == skip {
+				continue
+			}
+			p := prefix + nb.Name
+			opts = append(opts, notebookOption{ID: nb.ID, Path: p})
+			walk(nb.ID, p+" / ")
+		}
+	}
+	walk("", "")
+	return opts
+}
+
+// The handlers below only act on the notebooks and notes of the user, as
+// notebooks are not shared.
+
+var invalidNotebookErr = responses.NewError(
+	safehttp.StatusBadRequest,
+	template.MustParseAndExecuteToHTML("Please specify a notebook name that is not empty and not used by another notebook in the same place."),
+)
+
+// writeNotebookError writes the error for an error returned by the storage
+// for a notebook.
+func writeNotebookError(rw safehttp.ResponseWriter, err error) safehttp.Result {
+	if errors.Is(err, storage.ErrNoSuchNotebook) || errors.Is(err, storage.ErrNotFound) {
+		return rw.WriteError(safehttp.StatusNotFound)
+	}
+	return rw.WriteError(invalidNotebookErr)
+}
+
+func createNotebookHandler(deps *serverDeps) safehttp.Handler {
+	return safehttp.HandlerFunc(func(rw safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		form, err := r.PostForm()
+		if err != nil {
+			return rw.WriteError(invalidNotebookErr)
+		}
+		nb, err := deps.db.CreateNotebook(auth.User(r), form.String("name", ""), form.String("parent", ""))
+		if err != nil {
+			return writeNotebookError(rw, err)
+		}
+		return redirectToNotebook(rw, r, nb.ID)
+	})
+}
+
+func renameNotebookHandler(deps *serverDeps) safehttp.Handler {
+	return safehttp.HandlerFunc(func(rw safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		form, err := r.PostForm()
+		if err != nil {
+			return rw.WriteError(invalidNotebookErr)
+		}
+		id := form.String("id", "")
+		if err := deps.db.RenameNotebook(auth.User(r), id, form.String("name", "")); err != nil {
+			return writeNotebookError(rw, err)
+		}
+		return redirectToNotebook(rw, r, id)
+	})
+}
+
+// moveNotebookHandler moves a notebook to the one in the "parent" field, or
+// to the top level if it is empty.
+func moveNotebookHandler(deps *serverDeps) safehttp.Handler {
+	return safehttp.HandlerFunc(func(rw safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		form, err := r.PostForm()
+		if err != nil {
+			return rw.WriteError(invalidNotebookErr)
+		}
+		id := form.String("id", "")
+		if err := deps.db.MoveNotebook(auth.User(r), id, form.String("parent", "")); err != nil {
+			return writeNotebookError(rw, err)
+		}
+		return redirectToNotebook(rw, r, id)
+	})
+}
+
+// deleteNotebookHandler deletes a notebook, but not what is in it: see
+// storage.DB.DeleteNotebook.
+func deleteNotebookHandler(deps *serverDeps) safehttp.Handler {
+	return safehttp.HandlerFunc(func(rw safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		form, err := r.PostForm()
+		if err != nil {
+			return rw.WriteError(safehttp.StatusBadRequest)
+		}
+		user := auth.User(r)
+		nb, ok := deps.db.GetNotebook(user, form.String("id", ""))
+		if !ok {
+			return rw.WriteError(safehttp.StatusNotFound)
+		}
+		if err := deps.db.DeleteNotebook(user, nb.ID); err != nil {
+			return writeNotebookError(rw, err)
+		}
+		return redirectToNotebook(rw, r, nb.Parent)
+	})
+}
+
+// moveNoteHandler moves a note to the notebook in the "notebook" field, or to
+// the top level if it is empty.
+func moveNoteHandler(deps *serverDeps) safehttp.Handler {
+	return safehttp.HandlerFunc(func(rw safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		form, err := r.PostForm()
+		if err != nil {
+			return rw.WriteError(safehttp.StatusBadRequest)
+		}
+		notebook := form.String("notebook", "")
+		if err := deps.db.MoveNote(auth.User(r), form.String("title", ""), notebook); err != nil {
+			return writeNotebookError(rw, err)
+		}
+		return redirectToNotebook(rw, r, notebook)
+	})
+}
+
+// redirectToNotebook redirects to the notes page of a notebook of the user,
+// or of the top level if id is empty.
+func redirectToNotebook(rw safehttp.ResponseWriter, r *safehttp.IncomingRequest, id string) safehttp.Result {
+	u := "/notes/"
+	if id != "" {
+		u += "?notebook=" + url.QueryEscape(id)
+	}
+	return safehttp.Redirect(rw, r, u, safehttp.StatusSeeOther)
+}
@@ -18,18 +18,27 @@ package server
 import (
 	"github.com/google/go-safeweb/safehttp"
 
+	"context"
 	"embed"
+	"errors"
+	"log"
+	"strings"
+	"time"
 
+	"github.com/empijei/go-safeweb-example-app/src/audit"
+	"github.com/empijei/go-safeweb-example-app/src/blobstore"
+	"github.com/empijei/go-safeweb-example-app/src/collab"
+	"github.com/empijei/go-safeweb-example-app/src/markdown"
+	"github.com/empijei/go-safeweb-example-app/src/secure"
 	"github.com/empijei/go-safeweb-example-app/src/secure/auth"
 	"github.com/empijei/go-safeweb-example-app/src/secure/responses"
+	"github.com/empijei/go-safeweb-example-app/src/secure/sharelink"
+	"github.com/empijei/go-safeweb-example-app/src/static"
 	"github.com/empijei/go-safeweb-example-app/src/storage"
 	"github.com/google/go-safeweb/safehttp/plugins/htmlinject"
 	"github.com/google/safehtml/template"
 )
 
-//go:embed static
-var staticFiles embed.FS
-
 //go:embed templates
 var templatesFS embed.FS
 
@@ -39,40 +48,173 @@ func init() {
 	tplSrc := template.TrustedSourceFromConstant("templates/*.tpl.html")
 	var err error
 	// Automatically inject CSP nonces and XSRF tokens placeholders.
-	templates, err = htmlinject.LoadGlobEmbed(nil, htmlinject.LoadConfig{}, tplSrc, templatesFS)
+	base := template.New("").Funcs(static.Assets.FuncMap()).Funcs(template.FuncMap{
+		"markdown":     markdown.Render,
+		"join":         strings.Join,
+		"hasThumbnail": hasThumbnail,
+	})
+	templates, err = htmlinject.LoadGlobEmbed(base, htmlinject.LoadConfig{}, tplSrc, templatesFS)
 	if err != nil {
 		panic(err)
 	}
 }
 
 type serverDeps struct {
-	db *storage.DB
+	db     *storage.DB
+	collab *collab.Hub
+	links  *sharelink.Signer
+	blobs  blobstore.Store
+	audit  *audit.Logger
+	// exports runs the exports of users.
+	exports *exportStore
+	imports *importStore
+	// trashRetention is how long notes stay in the trash, they are purged by
+	// a job that main schedules.
+	trashRetention time.Duration
 }
 
-func Load(db *storage.DB, cfg *safehttp.ServeMuxConfig) {
+func Load(db *storage.DB, links *sharelink.Signer, blobs blobstore.Store, auditLog *audit.Logger, trashRetention time.Duration, cfg *secure.MuxConfig) {
 	deps := &serverDeps{
-		db: db,
+		db:             db,
+		collab:         collab.NewHub(db),
+		links:          links,
+		blobs:          blobs,
+		audit:          auditLog,
+		exports:        newExportStore(blobs),
+		imports:        newImportStore(),
+		trashRetention: trashRetention,
+	}
+	if err := deps.exports.sweep(context.Background()); err != nil {
+		log.Printf("Deleting the exports left behind: %v", err)
 	}
 
 	// Private endpoints, only accessible to authenticated users (default).
 	cfg.Handle("/notes/", "GET", getNotesHandler(deps))
+	cfg.Handle("/notes/edit", "GET", editNoteHandler(deps))
+	cfg.Handle("/notes/events", "GET", notesEventsHandler(deps))
+	cfg.Handle("/notes/collab", "GET", collabHandler(deps))
 	cfg.Handle("/notes", "POST", postNotesHandler(deps))
+	cfg.Handle("/notes/share", "POST", shareNoteHandler(deps))
+	cfg.Handle("/notes/unshare", "POST", unshareNoteHandler(deps))
+	cfg.Handle("/notes/links", "POST", createLinkHandler(deps))
+	cfg.Handle("/notes/links/revoke", "POST", revokeLinkHandler(deps))
+	cfg.Handle("/notes/move", "POST", moveNoteHandler(deps))
+	cfg.Handle("/notes/delete", "POST", deleteNoteHandler(deps))
+	cfg.Handle("/notes/pin", "POST", pinNoteHandler(deps))
+	cfg.Handle("/notes/archive", "POST", archiveNoteHandler(deps))
+	cfg.Handle(archivePath, "GET", getArchiveHandler(deps))
+	cfg.Handle("/notes/color", "POST", colorNoteHandler(deps))
+	cfg.Handle("/notes/attachments", "POST", uploadAttachmentHandler(deps))
+	cfg.Handle("/notes/attachments/delete", "POST", deleteAttachmentHandler(deps))
+	cfg.Handle(attachmentsPath, "GET", downloadAttachmentHandler(deps), secure.Download{})
+	cfg.Handle(exportPath, "GET", getExportHandler(deps))
+	cfg.Handle(exportPath, "POST", exportHandler(deps))
+	cfg.Handle(exportPath+"/download", "GET", downloadExportHandler(deps), secure.Download{})
+	cfg.Handle(importPath, "GET", getImportHandler(deps))
+	cfg.Handle(importPath, "POST", uploadImportHandler(deps))
+	cfg.Handle(importPath+"/commit", "POST", commitImportHandler(deps))
+	cfg.Handle(importPath+"/cancel", "POST", cancelImportHandler(deps))
+	cfg.Handle("/account/delete", "POST", deleteAccountHandler(deps))
+	cfg.Handle(trashPath, "GET", getTrashHandler(deps))
+	cfg.Handle("/notes/trash/restore", "POST", restoreNoteHandler(deps))
+	cfg.Handle("/notes/trash/purge", "POST", purgeNoteHandler(deps))
+	cfg.Handle("/notebooks", "POST", createNotebookHandler(deps))
+	cfg.Handle("/notebooks/rename", "POST", renameNotebookHandler(deps))
+	cfg.Handle("/notebooks/move", "POST", moveNotebookHandler(deps))
+	cfg.Handle("/notebooks/delete", "POST", deleteNotebookHandler(deps))
+	cfg.Handle("/tags/rename", "POST", renameTagHandler(deps))
+	cfg.Handle("/api/notes", "GET", getNoteAPIHandler(deps), secure.JSONAPI{})
+	cfg.Handle("/api/notes", "PUT", putNoteAPIHandler(deps), secure.JSONAPI{})
+	cfg.Handle("/api/tags", "GET", tagsAPIHandler(deps), secure.JSONAPI{})
 	cfg.Handle("/logout", "POST", logoutHandler(deps))
 
 	// Public enpoints, no auth checks performed.
 	cfg.Handle("/login", "POST", postLoginHandler(deps), auth.Skip{})
-	cfg.Handle("/static/", "GET", safehttp.FileServerEmbed(staticFiles), auth.Skip{})
+	cfg.Handle(sharedPath, "GET", sharedNoteHandler(deps), auth.Skip{}, secure.PublicPage{})
+	cfg.Handle(static.Path, "GET", static.Assets.Handler(), auth.Skip{})
 	cfg.Handle("/", "GET", indexHandler(deps), auth.Skip{})
 }
 
+// getNotesHandler shows the notes of the user in the notebook in the
+// "notebook" parameter, or at the top level if there is none. With "tag"
+// parameters, it shows the notes with those tags instead, see parseTagFilter.
 func getNotesHandler(deps *serverDeps) safehttp.Handler {
 	return safehttp.HandlerFunc(func(rw safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
-		user := auth.User(r)
-		notes := deps.db.GetNotes(user)
-		return safehttp.ExecuteNamedTemplate(rw, templates, "notes.tpl.html", map[string]interface{}{
-			"notes": notes,
-			"user":  user,
-		})
+		q, err := r.URL.Query()
+		if err != nil {
+			return rw.WriteError(safehttp.StatusBadRequest)
+		}
+		tags, matchAny, err := parseTagFilter(q)
+		if err != nil {
+			return rw.WriteError(safehttp.StatusBadRequest)
+		}
+		if len(tags) > 0 {
+			return renderTagged(rw, deps, auth.User(r), tags, matchAny)
+		}
+		return renderNotes(rw, deps, auth.User(r), q.String("notebook", ""))
+	})
+}
+
+// renderNotes renders the notes of user in a notebook, empty for the top
+// level, and the notebooks in it. The notes shared with the user are shown at
+// the top level.
+func renderNotes(rw safehttp.ResponseWriter, deps *serverDeps, user, notebook string) safehttp.Result {
+	tree := loadNotebookTree(deps, user)
+	data := map[string]interface{}{
+		"notes":       listed(deps.db.GetNotesIn(user, notebook)),
+		"notebooks":   tree.entries(notebook),
+		"attachments": deps.db.GetAllAttachments(user),
+		"cloud":       tagCloud(deps, user),
+		"user":        user,
+	}
+	if notebook == "" {
+		data["shared"] = deps.db.SharedWith(user)
+	} else {
+		nb, ok := tree.byID[notebook]
+		if !ok {
+			return rw.WriteError(safehttp.StatusNotFound)
+		}
+		data["notebook"] = nb
+		data["path"] = tree.path(notebook)
+		// A notebook cannot be moved into itself or the notebooks in it.
+		data["targets"] = tree.options(notebook)
+	}
+	return safehttp.ExecuteNamedTemplate(rw, templates, "notes.tpl.html", data)
+}
+
+// editNoteHandler shows the form to edit a note, which must be owned by the
+// user or shared with them as an editor. Owners can also manage who the note
+// is shared with.
+//
+// Unlike the API, HTML pages do not support conditional requests: each
+// response has a fresh CSP nonce and XSRF token, so a cached copy cannot be
+// reused.
+func editNoteHandler(deps *serverDeps) safehttp.Handler {
+	return safehttp.HandlerFunc(func(rw safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		owner, title, ok := noteRef(r)
+		if !ok {
+			return rw.WriteError(safehttp.StatusBadRequest)
+		}
+		n, role, err := deps.db.GetNoteAs(auth.User(r), owner, title)
+		if err != nil {
+			return writeAccessError(rw, err)
+		}
+		if !role.CanEdit() {
+			return rw.WriteError(safehttp.StatusForbidden)
+		}
+		data := map[string]interface{}{
+			"note":        n,
+			"owner":       owner,
+			"isOwner":     role == storage.Owner,
+			"attachments": deps.db.GetAttachments(owner, title),
+		}
+		if role == storage.Owner {
+			data["shares"] = deps.db.GetShares(owner, title)
+			data["links"] = getShareLinks(deps, owner, title)
+			data["notebooks"] = loadNotebookTree(deps, owner).options("")
+			data["colors"] = storage.NoteColors
+		}
+		return safehttp.ExecuteNamedTemplate(rw, templates, "edit.tpl.html", data)
 	})
 }
 
@@ -85,6 +227,10 @@ func postNotesHandler(deps *serverDeps) safehttp.Handler {
 		safehttp.StatusBadRequest,
 		template.MustParseAndExecuteToHTML("Both title and text must be specified."),
 	)
+	invalidTagsErr := responses.NewError(
+		safehttp.StatusBadRequest,
+		template.MustParseAndExecuteToHTML("Tags can only contain letters, digits, '-', '_' and '/', be up to 32 characters long, and there can be up to 20 of them."),
+	)
 
 	return safehttp.HandlerFunc(func(rw safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
 		form, err := r.PostForm()
@@ -93,17 +239,33 @@ func postNotesHandler(deps *serverDeps) safehttp.Handler {
 		}
 		title := form.String("title", "")
 		body := form.String("text", "")
+		// New notes have version 0, so an old form cannot overwrite a note
+		// created since it was loaded.
+		version := form.Int64("version", 0)
 		if title == "" || body == "" {
 			return rw.WriteError(noFieldsErr)
 		}
+		tags, err := storage.ParseTags(form.String("tags", ""))
+		if err != nil {
+			return rw.WriteError(invalidTagsErr)
+		}
 		user := auth.User(r)
-		deps.db.AddOrEditNote(user, storage.Note{Title: title, Text: body})
-
-		notes := deps.db.GetNotes(user)
-		return safehttp.ExecuteNamedTemplate(rw, templates, "notes.tpl.html", map[string]interface{}{
-			"notes": notes,
-			"user":  user,
-		})
+		// Notes shared with the user are edited on behalf of their owner.
+		owner := form.String("owner", user)
+		// The notebook is only used for new notes, see storage.Note.
+		mine := storage.Note{Title: title, Text: body, Markdown: form.Bool("markdown", false), Notebook: form.String("notebook", ""), Tags: tags, Version: int(version)}
+		cur, err := deps.db.EditNoteAs(user, owner, mine)
+		switch {
+		case errors.Is(err, storage.ErrConflict):
+			return safehttp.ExecuteNamedTemplate(rw, templates, "conflict.tpl.html", map[string]interface{}{
+				"mine":    mine,
+				"current": cur,
+				"owner":   owner,
+			})
+		case err != nil:
+			return writeAccessError(rw, err)
+		}
+		return renderNotes(rw, deps, user, cur.Notebook)
 	})
 }
 
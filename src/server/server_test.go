@@ -0,0 +1,165 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/empijei/go-safeweb-example-app/src/audit"
+	"github.com/empijei/go-safeweb-example-app/src/blobstore"
+	"github.com/empijei/go-safeweb-example-app/src/config"
+	"github.com/empijei/go-safeweb-example-app/src/secure"
+	"github.com/empijei/go-safeweb-example-app/src/secure/envelope"
+	"github.com/empijei/go-safeweb-example-app/src/secure/reports"
+	"github.com/empijei/go-safeweb-example-app/src/secure/sharelink"
+	"github.com/empijei/go-safeweb-example-app/src/server"
+	"github.com/empijei/go-safeweb-example-app/src/storage"
+)
+
+// TestTrustedTypes checks that every response requires Trusted Types, signed
+// in or not, and enforces a strict CSP even when Trusted Types are only
+// reported.
+func TestTrustedTypes(t *testing.T) {
+	for _, tc := range []struct {
+		mode   config.TrustedTypesMode
+		header string
+	}{
+		{config.TrustedTypesEnforce, "Content-Security-Policy"},
+		{config.TrustedTypesReportOnly, "Content-Security-Policy-Report-Only"},
+	} {
+		t.Run(string(tc.mode), func(t *testing.T) {
+			conf := config.Default()
+			conf.Dev = true
+			conf.Plugins.TrustedTypes = tc.mode
+			if err := conf.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			keys, err := envelope.NewKeyring(conf.Secrets.MasterKeys)
+			if err != nil {
+				t.Fatal(err)
+			}
+			db := storage.NewDB(envelope.NewVault(keys, envelope.NewMemoryKeyStore()))
+			if err := db.AddOrAuthUser("alice", "pw"); err != nil {
+				t.Fatal(err)
+			}
+			cfg := secure.NewMuxConfig(db, conf, reports.NewCollector(1, 1))
+			server.Load(db, sharelink.NewSigner(conf.Secrets.ShareLinkKey), blobstore.NewMemory(), audit.New(ioutil.Discard), time.Hour, cfg)
+			mux := cfg.Mux()
+
+			for _, route := range cfg.Routes() {
+				for _, token := range []string{"", db.GetToken("alice")} {
+					// Event streams only end with their context.
+					ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+					req := httptest.NewRequest(route.Method, "http://"+conf.Server.PublicHosts[0]+route.Pattern, nil).WithContext(ctx)
+					if token != "" {
+						req.AddCookie(&http.Cookie{Name: "SESSION", Value: token})
+					}
+					rec := httptest.NewRecorder()
+					mux.ServeHTTP(rec, req)
+					cancel()
+
+					desc := fmt.Sprintf("%s %s (signed in: %v), status %d", route.Method, route.Pattern, token != "", rec.Code)
+					h := rec.Result().Header
+					got := strings.Join(h.Values(tc.header), ", ")
+					for _, want := range []string{"require-trusted-types-for 'script'", "trusted-types notekeeper"} {
+						if !strings.Contains(got, want) {
+							t.Errorf("%s: %s is %q, want it to contain %q", desc, tc.header, got, want)
+						}
+					}
+					enforced := h.Values("Content-Security-Policy")
+					for _, err := range checkStrictCSP(enforced) {
+						t.Errorf("%s: Content-Security-Policy %q: %v", desc, enforced, err)
+					}
+					if tc.mode == config.TrustedTypesReportOnly {
+						for _, p := range parseCSP(enforced) {
+							if _, ok := p["require-trusted-types-for"]; ok {
+								t.Errorf("%s: Content-Security-Policy %q requires Trusted Types in report-only mode", desc, enforced)
+							}
+							if _, ok := p["trusted-types"]; ok {
+								t.Errorf("%s: Content-Security-Policy %q restricts Trusted Types policies in report-only mode", desc, enforced)
+							}
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+// parseCSP returns the directives of each policy in headers, by name.
+func parseCSP(headers []string) []map[string]string {
+	var policies []map[string]string
+	for _, h := range headers {
+		for _, policy := range strings.Split(h, ",") {
+			p := map[string]string{}
+			for _, d := range strings.Split(policy, ";") {
+				fields := strings.Fields(d)
+				if len(fields) == 0 {
+					continue
+				}
+				p[strings.ToLower(fields[0])] = strings.Join(fields[1:], " ")
+			}
+			policies = append(policies, p)
+		}
+	}
+	return policies
+}
+
+// checkStrictCSP returns what the policies in headers fail to restrict. Scripts
+// must either need a nonce or be blocked altogether, as in the policies of
+// public pages and downloads.
+func checkStrictCSP(headers []string) []error {
+	var scripts, objects, base, frames bool
+	for _, p := range parseCSP(headers) {
+		def, hasDef := p["default-src"]
+		blockAll := hasDef && def == "'none'"
+		if src, ok := p["script-src"]; ok && strings.Contains(src, "'nonce-") && !strings.Contains(src, "*") {
+			scripts = true
+		} else if !ok && blockAll {
+			scripts = true
+		}
+		if src, ok := p["object-src"]; ok && src == "'none'" || !ok && blockAll {
+			objects = true
+		}
+		if _, ok := p["base-uri"]; ok {
+			base = true
+		}
+		if _, ok := p["frame-ancestors"]; ok {
+			frames = true
+		}
+	}
+	var errs []error
+	if !scripts {
+		errs = append(errs, errors.New("scripts do not need a nonce"))
+	}
+	if !objects {
+		errs = append(errs, errors.New("plugins are not blocked with object-src 'none'"))
+	}
+	if !base {
+		errs = append(errs, errors.New("no base-uri"))
+	}
+	if !frames {
+		errs = append(errs, errors.New("no frame-ancestors"))
+	}
+	return errs
+}
@@ -0,0 +1,75 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"errors"
+	"net/url"
+
+	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/safehtml/template"
+
+	"github.com/empijei/go-safeweb-example-app/src/secure/auth"
+	"github.com/empijei/go-safeweb-example-app/src/secure/responses"
+	"github.com/empijei/go-safeweb-example-app/src/storage"
+)
+
+// Users can only share their own notes, so the handlers below never take an
+// owner parameter.
+
+func shareNoteHandler(deps *serverDeps) safehttp.Handler {
+	invalidShareErr := responses.NewError(
+		safehttp.StatusBadRequest,
+		template.MustParseAndExecuteToHTML(`Please specify another user and a role, either "viewer" or "editor".`),
+	)
+
+	return safehttp.HandlerFunc(func(rw safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		form, err := r.PostForm()
+		if err != nil {
+			return rw.WriteError(invalidShareErr)
+		}
+		title := form.String("title", "")
+		role, ok := storage.ParseRole(form.String("role", ""))
+		if !ok {
+			return rw.WriteError(invalidShareErr)
+		}
+		err = deps.db.ShareNote(auth.User(r), title, form.String("user", ""), role)
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			return rw.WriteError(safehttp.StatusNotFound)
+		case err != nil:
+			return rw.WriteError(invalidShareErr)
+		}
+		return redirectToEdit(rw, r, title)
+	})
+}
+
+func unshareNoteHandler(deps *serverDeps) safehttp.Handler {
+	return safehttp.HandlerFunc(func(rw safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		form, err := r.PostForm()
+		if err != nil {
+			return rw.WriteError(safehttp.StatusBadRequest)
+		}
+		title := form.String("title", "")
+		deps.db.RevokeShare(auth.User(r), title, form.String("user", ""))
+		return redirectToEdit(rw, r, title)
+	})
+}
+
+// redirectToEdit redirects to the edit page of a note of the user, where
+// shares are managed.
+func redirectToEdit(rw safehttp.ResponseWriter, r *safehttp.IncomingRequest, title string) safehttp.Result {
+	return safehttp.Redirect(rw, r, "/notes/edit?title="+url.QueryEscape(title), safehttp.StatusSeeOther)
+}
@@ -0,0 +1,101 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"errors"
+
+	"github.com/google/go-safeweb/safehttp"
+
+	"github.com/empijei/go-safeweb-example-app/src/secure/auth"
+	"github.com/empijei/go-safeweb-example-app/src/storage"
+)
+
+// Pinning, archiving and coloring notes only changes how their owner sees
+// them, so the handlers below never take an owner parameter.
+
+const archivePath = "/notes/archive"
+
+// listed returns the notes that are shown in lists, i.e. not archived, pinned
+// ones first.
+func listed(ns []storage.Note) []storage.Note {
+	var out []storage.Note
+	for _, n := range ns {
+		if !n.Archived {
+			out = append(out, n)
+		}
+	}
+	storage.SortNotes(out)
+	return out
+}
+
+func getArchiveHandler(deps *serverDeps) safehttp.Handler {
+	return safehttp.HandlerFunc(func(rw safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		var notes []storage.Note
+		for _, n := range deps.db.GetNotes(auth.User(r)) {
+			if n.Archived {
+				notes = append(notes, n)
+			}
+		}
+		storage.SortNotes(notes)
+		return safehttp.ExecuteNamedTemplate(rw, templates, "archive.tpl.html", map[string]interface{}{
+			"notes": notes,
+		})
+	})
+}
+
+// pinNoteHandler pins the note in the "title" field if "pinned" is true, and
+// unpins it otherwise.
+func pinNoteHandler(deps *serverDeps) safehttp.Handler {
+	return stateHandler(func(user string, form *safehttp.Form) (storage.Note, error) {
+		return deps.db.PinNote(user, form.String("title", ""), form.Bool("pinned", false))
+	})
+}
+
+// archiveNoteHandler archives the note in the "title" field if "archived" is
+// true, and brings it back to the lists otherwise.
+func archiveNoteHandler(deps *serverDeps) safehttp.Handler {
+	return stateHandler(func(user string, form *safehttp.Form) (storage.Note, error) {
+		return deps.db.ArchiveNote(user, form.String("title", ""), form.Bool("archived", false))
+	})
+}
+
+func colorNoteHandler(deps *serverDeps) safehttp.Handler {
+	return stateHandler(func(user string, form *safehttp.Form) (storage.Note, error) {
+		return deps.db.ColorNote(user, form.String("title", ""), form.String("color", ""))
+	})
+}
+
+// stateHandler returns a handler that changes the state of a note of the user
+// with update, then redirects to where the note is listed.
+func stateHandler(update func(user string, form *safehttp.Form) (storage.Note, error)) safehttp.Handler {
+	return safehttp.HandlerFunc(func(rw safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		form, err := r.PostForm()
+		if err != nil {
+			return rw.WriteError(safehttp.StatusBadRequest)
+		}
+		n, err := update(auth.User(r), form)
+		switch {
+		case errors.Is(err, storage.ErrInvalidColor):
+			return rw.WriteError(safehttp.StatusBadRequest)
+		case err != nil:
+			return rw.WriteError(safehttp.StatusNotFound)
+		}
+		if n.Archived {
+			return safehttp.Redirect(rw, r, archivePath, safehttp.StatusSeeOther)
+		}
+		return redirectToNotebook(rw, r, n.Notebook)
+	})
+}
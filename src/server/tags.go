@@ -0,0 +1,130 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/safehtml/template"
+
+	"github.com/empijei/go-safeweb-example-app/src/secure/auth"
+	"github.com/empijei/go-safeweb-example-app/src/secure/responses"
+	"github.com/empijei/go-safeweb-example-app/src/storage"
+)
+
+const (
+	// maxFilterTags bounds the tags notes can be filtered by.
+	maxFilterTags = 10
+	// maxSuggestions is the number of tags returned by the autocomplete API.
+	maxSuggestions = 10
+	// cloudWeights is the number of sizes of tags in the tag cloud, see the
+	// tag-N classes in styles.css.
+	cloudWeights = 5
+)
+
+// cloudTag is a tag in the tag cloud. Weight goes from 1 to cloudWeights,
+// for the tags used the most.
+type cloudTag struct {
+	Tag    string
+	Count  int
+	Weight int
+}
+
+// tagCloud returns the tags of user, sorted by name.
+func tagCloud(deps *serverDeps, user string) []cloudTag {
+	tcs := deps.db.GetTags(user, "")
+	if len(tcs) == 0 {
+		return nil
+	}
+	// Tags are sorted by decreasing count.
+	top := tcs[0].Count
+	var cloud []cloudTag
+	for _, tc := range tcs {
+		cloud = append(cloud, cloudTag{Tag: tc.Tag, Count: tc.Count, Weight: 1 + (tc.Count*cloudWeights-1)/top})
+	}
+	sort.Slice(cloud, func(i, j int) bool { return cloud[i].Tag < cloud[j].Tag })
+	return cloud
+}
+
+// renderTagged renders the notes of user with all the given tags, or any of
+// them, regardless of their notebook.
+func renderTagged(rw safehttp.ResponseWriter, deps *serverDeps, user string, tags []string, matchAny bool) safehttp.Result {
+	return safehttp.ExecuteNamedTemplate(rw, templates, "notes.tpl.html", map[string]interface{}{
+		"notes":       listed(deps.db.FindNotes(user, tags, matchAny)),
+		"filter":      tags,
+		"matchAny":    matchAny,
+		"cloud":       tagCloud(deps, user),
+		"attachments": deps.db.GetAllAttachments(user),
+		"user":        user,
+	})
+}
+
+// parseTagFilter returns the tags in the "tag" parameters of q, each of
+// which can hold several tags like the forms do, and whether notes must have
+// any of them rather than all, as set by "match=any".
+func parseTagFilter(q safehttp.Form) (tags []string, matchAny bool, err error) {
+	var raw []string
+	q.Slice("tag", &raw)
+	if tags, err = storage.ParseTags(strings.Join(raw, ",")); err != nil {
+		return nil, false, err
+	}
+	if len(tags) > maxFilterTags {
+		return nil, false, storage.ErrInvalidTag
+	}
+	return tags, q.String("match", "all") == "any", nil
+}
+
+// renameTagHandler renames a tag on all the notes of the user.
+func renameTagHandler(deps *serverDeps) safehttp.Handler {
+	invalidTagErr := responses.NewError(
+		safehttp.StatusBadRequest,
+		template.MustParseAndExecuteToHTML("Tags can only contain letters, digits, '-', '_' and '/', and be up to 32 characters long."),
+	)
+
+	return safehttp.HandlerFunc(func(rw safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		form, err := r.PostForm()
+		if err != nil {
+			return rw.WriteError(invalidTagErr)
+		}
+		to := form.String("to", "")
+		if _, err := deps.db.RenameTag(auth.User(r), form.String("from", ""), to); err != nil {
+			return rw.WriteError(invalidTagErr)
+		}
+		to, _ = storage.NormalizeTag(to)
+		return safehttp.Redirect(rw, r, "/notes/?tag="+url.QueryEscape(to), safehttp.StatusSeeOther)
+	})
+}
+
+// tagsAPIHandler returns the tags of the user starting with the "prefix"
+// parameter, the most used first, to autocomplete tags in forms.
+func tagsAPIHandler(deps *serverDeps) safehttp.Handler {
+	return safehttp.HandlerFunc(func(rw safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		q, err := r.URL.Query()
+		if err != nil {
+			return rw.WriteError(safehttp.StatusBadRequest)
+		}
+		tags := []string{}
+		for _, tc := range deps.db.GetTags(auth.User(r), strings.ToLower(strings.TrimSpace(q.String("prefix", "")))) {
+			if len(tags) == maxSuggestions {
+				break
+			}
+			tags = append(tags, tc.Tag)
+		}
+		return rw.Write(safehttp.JSONResponse{Data: tags})
+	})
+}
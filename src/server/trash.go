@@ -0,0 +1,107 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/go-safeweb/safehttp"
+	"github.com/google/safehtml/template"
+
+	"github.com/empijei/go-safeweb-example-app/src/secure/auth"
+	"github.com/empijei/go-safeweb-example-app/src/secure/responses"
+	"github.com/empijei/go-safeweb-example-app/src/storage"
+)
+
+// Only owners can delete notes, so the handlers below never take an owner
+// parameter.
+
+const trashPath = "/notes/trash"
+
+// trashedNote is a note in the trash, with the time it will be purged at.
+type trashedNote struct {
+	storage.TrashedNote
+	Purge time.Time
+}
+
+func deleteNoteHandler(deps *serverDeps) safehttp.Handler {
+	return safehttp.HandlerFunc(func(rw safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		form, err := r.PostForm()
+		if err != nil {
+			return rw.WriteError(safehttp.StatusBadRequest)
+		}
+		replaced, err := deps.db.DeleteNote(auth.User(r), form.String("title", ""))
+		if err != nil {
+			return rw.WriteError(safehttp.StatusNotFound)
+		}
+		deleteBlobs(r.Context(), deps.blobs, replaced)
+		return safehttp.Redirect(rw, r, trashPath, safehttp.StatusSeeOther)
+	})
+}
+
+func getTrashHandler(deps *serverDeps) safehttp.Handler {
+	return safehttp.HandlerFunc(func(rw safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		var notes []trashedNote
+		for _, t := range deps.db.GetTrash(auth.User(r)) {
+			notes = append(notes, trashedNote{TrashedNote: t, Purge: t.Deleted.Add(deps.trashRetention)})
+		}
+		return safehttp.ExecuteNamedTemplate(rw, templates, "trash.tpl.html", map[string]interface{}{
+			"notes": notes,
+		})
+	})
+}
+
+func restoreNoteHandler(deps *serverDeps) safehttp.Handler {
+	titleTakenErr := responses.NewError(
+		safehttp.StatusConflict,
+		template.MustParseAndExecuteToHTML("Another note has the title of this one. Rename or delete it, then restore this note again."),
+	)
+
+	return safehttp.HandlerFunc(func(rw safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		form, err := r.PostForm()
+		if err != nil {
+			return rw.WriteError(safehttp.StatusBadRequest)
+		}
+		n, err := deps.db.RestoreNote(auth.User(r), form.String("title", ""))
+		switch {
+		case errors.Is(err, storage.ErrTitleTaken):
+			return rw.WriteError(titleTakenErr)
+		case err != nil:
+			return rw.WriteError(safehttp.StatusNotFound)
+		}
+		return redirectToNotebook(rw, r, n.Notebook)
+	})
+}
+
+// purgeNoteHandler permanently deletes a note in the trash, or all of them
+// if the "all" field is set.
+func purgeNoteHandler(deps *serverDeps) safehttp.Handler {
+	return safehttp.HandlerFunc(func(rw safehttp.ResponseWriter, r *safehttp.IncomingRequest) safehttp.Result {
+		form, err := r.PostForm()
+		if err != nil {
+			return rw.WriteError(safehttp.StatusBadRequest)
+		}
+		user := auth.User(r)
+		var purged []storage.Attachment
+		if form.Bool("all", false) {
+			purged = deps.db.EmptyTrash(user)
+		} else if purged, err = deps.db.PurgeNote(user, form.String("title", "")); err != nil {
+			return rw.WriteError(safehttp.StatusNotFound)
+		}
+		deleteBlobs(r.Context(), deps.blobs, purged)
+		return safehttp.Redirect(rw, r, trashPath, safehttp.StatusSeeOther)
+	})
+}
@@ -0,0 +1,324 @@
+/**
+ * @license
+ * Copyright 2020 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/**
+ * Lets several users edit a note at the same time, over the WebSocket at
+ * /notes/collab. See package collab for the protocol.
+ *
+ * Operations are arrays of components, as in ot.js: a positive number retains
+ * that many characters, a negative one deletes them, a string is inserted.
+ * Lengths are in code points, like on the server, so texts are handled as
+ * arrays of code points (Array.from) rather than UTF-16 strings.
+ */
+document.addEventListener('DOMContentLoaded', function () {
+  const form = document.getElementById('editnote');
+  const presence = document.getElementById('presence');
+  if (!form || !presence || !window.WebSocket) {
+    return;
+  }
+  const textarea = form.elements['text'];
+  const version = form.elements['version'];
+  const owner = form.elements['owner'].value;
+  const title = form.elements['title'].value;
+
+  // Operations.
+
+  const isRetain = (c) => typeof c === 'number' && c > 0;
+  const isDelete = (c) => typeof c === 'number' && c < 0;
+  const isInsert = (c) => typeof c === 'string';
+  const len = (s) => Array.from(s).length;
+
+  /** Builds canonical operations, like the builder methods of collab.Op. */
+  class Builder {
+    constructor() {
+      this.ops = [];
+    }
+    last(i = 1) {
+      return this.ops[this.ops.length - i];
+    }
+    retain(n) {
+      if (n === 0) return this;
+      if (isRetain(this.last())) this.ops[this.ops.length - 1] += n;
+      else this.ops.push(n);
+      return this;
+    }
+    insert(s) {
+      if (s === '') return this;
+      const l = this.ops.length;
+      if (isInsert(this.last())) {
+        this.ops[l - 1] += s;
+      } else if (isDelete(this.last())) {
+        if (isInsert(this.last(2))) {
+          this.ops[l - 2] += s;
+        } else {
+          this.ops.push(this.ops[l - 1]);
+          this.ops[l - 1] = s;
+        }
+      } else {
+        this.ops.push(s);
+      }
+      return this;
+    }
+    delete(n) {
+      if (n === 0) return this;
+      if (isDelete(this.last())) this.ops[this.ops.length - 1] -= n;
+      else this.ops.push(-n);
+      return this;
+    }
+  }
+
+  /** Applies op to text, an array of code points. */
+  function apply(op, text) {
+    const out = [];
+    let i = 0;
+    for (const c of op) {
+      if (isRetain(c)) {
+        out.push(...text.slice(i, i + c));
+        i += c;
+      } else if (isDelete(c)) {
+        i -= c;
+      } else {
+        out.push(...Array.from(c));
+      }
+    }
+    if (i !== text.length) {
+      throw new Error('operation does not match the text');
+    }
+    return out;
+  }
+
+  /** Returns an operation with the effect of a followed by b. */
+  function compose(a, b) {
+    const out = new Builder();
+    const as = a.slice();
+    const bs = b.slice();
+    let ca = as.shift();
+    let cb = bs.shift();
+    while (ca !== undefined || cb !== undefined) {
+      if (isDelete(ca)) {
+        out.delete(-ca);
+        ca = as.shift();
+        continue;
+      }
+      if (isInsert(cb)) {
+        out.insert(cb);
+        cb = bs.shift();
+        continue;
+      }
+      if (ca === undefined || cb === undefined) {
+        throw new Error('operations cannot be composed');
+      }
+      const la = isInsert(ca) ? len(ca) : ca;
+      const lb = Math.abs(cb);
+      const min = Math.min(la, lb);
+      if (isRetain(ca) && isRetain(cb)) {
+        out.retain(min);
+      } else if (isInsert(ca) && isRetain(cb)) {
+        out.insert(Array.from(ca).slice(0, min).join(''));
+      } else if (isRetain(ca) && isDelete(cb)) {
+        out.delete(min);
+      }
+      // An insert followed by a delete of the same characters cancels out.
+      if (la === min) ca = as.shift();
+      else ca = isInsert(ca) ? Array.from(ca).slice(min).join('') : ca - min;
+      if (lb === min) cb = bs.shift();
+      else cb = isDelete(cb) ? cb + min : cb - min;
+    }
+    return out.ops;
+  }
+
+  /**
+   * Returns [a', b'] such that applying a then b' is the same as applying b
+   * then a'. Same as collab.Transform, inserts of a come first.
+   */
+  function transform(a, b) {
+    const a1 = new Builder();
+    const b1 = new Builder();
+    const as = a.slice();
+    const bs = b.slice();
+    let ca = as.shift();
+    let cb = bs.shift();
+    while (ca !== undefined || cb !== undefined) {
+      if (isInsert(ca)) {
+        a1.insert(ca);
+        b1.retain(len(ca));
+        ca = as.shift();
+        continue;
+      }
+      if (isInsert(cb)) {
+        a1.retain(len(cb));
+        b1.insert(cb);
+        cb = bs.shift();
+        continue;
+      }
+      if (ca === undefined || cb === undefined) {
+        throw new Error('operations cannot be transformed');
+      }
+      const la = Math.abs(ca);
+      const lb = Math.abs(cb);
+      const min = Math.min(la, lb);
+      if (isRetain(ca) && isRetain(cb)) {
+        a1.retain(min);
+        b1.retain(min);
+      } else if (isDelete(ca) && isRetain(cb)) {
+        a1.delete(min);
+      } else if (isRetain(ca) && isDelete(cb)) {
+        b1.delete(min);
+      }
+      if (la === min) ca = as.shift();
+      else ca = Math.sign(ca) * (la - min);
+      if (lb === min) cb = bs.shift();
+      else cb = Math.sign(cb) * (lb - min);
+    }
+    return [a1.ops, b1.ops];
+  }
+
+  /** Returns where index, in code points, moves to when op is applied. */
+  function transformIndex(op, index) {
+    let pos = 0;
+    let shift = 0;
+    for (const c of op) {
+      if (pos > index) break;
+      if (isRetain(c)) {
+        pos += c;
+      } else if (isInsert(c)) {
+        shift += len(c);
+      } else {
+        shift -= Math.min(-c, index - pos);
+        pos -= c;
+      }
+    }
+    return index + shift;
+  }
+
+  /** Returns the operation turning text into value, both code point arrays. */
+  function diff(text, value) {
+    let start = 0;
+    while (start < text.length && start < value.length &&
+           text[start] === value[start]) {
+      start++;
+    }
+    let end = 0;
+    while (end < text.length - start && end < value.length - start &&
+           text[text.length - 1 - end] === value[value.length - 1 - end]) {
+      end++;
+    }
+    return new Builder()
+        .retain(start)
+        .delete(text.length - start - end)
+        .insert(value.slice(start, value.length - end).join(''))
+        .retain(end)
+        .ops;
+  }
+
+  // Client state: the last text known to the server plus local edits, the
+  // revision it is based on, the operation waiting for an ack and the local
+  // edits made meanwhile.
+  let text = Array.from(textarea.value);
+  let rev = 0;
+  let pending = null;
+  let buffer = null;
+  let ready = false;
+
+  const scheme = location.protocol === 'https:' ? 'wss:' : 'ws:';
+  const ws = new WebSocket(scheme + '//' + location.host +
+      '/notes/collab?owner=' + encodeURIComponent(owner) +
+      '&title=' + encodeURIComponent(title));
+
+  function send(op) {
+    ws.send(JSON.stringify({type: 'op', rev: rev, op: op}));
+  }
+
+  /** Sets the textarea, moving the selection along with the edit. */
+  function show(op) {
+    const value = textarea.value;
+    const toCodePoints = (i) => len(value.slice(0, i));
+    const start = toCodePoints(textarea.selectionStart);
+    const end = toCodePoints(textarea.selectionEnd);
+    textarea.value = text.join('');
+    const toUTF16 = (i) => text.slice(0, i).join('').length;
+    if (op) {
+      textarea.setSelectionRange(
+          toUTF16(transformIndex(op, start)), toUTF16(transformIndex(op, end)));
+    }
+  }
+
+  textarea.addEventListener('input', function () {
+    if (!ready) return;
+    const value = Array.from(textarea.value);
+    const op = diff(text, value);
+    text = value;
+    if (op.length === 1 && isRetain(op[0]) || op.length === 0) return;
+    if (pending === null) {
+      pending = op;
+      send(op);
+    } else {
+      buffer = buffer === null ? op : compose(buffer, op);
+    }
+  });
+
+  ws.addEventListener('message', function (e) {
+    const m = JSON.parse(e.data);
+    switch (m.type) {
+      case 'init':
+        // Local edits that were not acknowledged are lost, the text starts
+        // over from the server's.
+        text = Array.from(m.text);
+        rev = m.rev;
+        pending = buffer = null;
+        version.value = m.version;
+        show(null);
+        ready = true;
+        break;
+      case 'ack':
+        rev = m.rev;
+        pending = buffer;
+        buffer = null;
+        if (pending !== null) send(pending);
+        break;
+      case 'op': {
+        rev = m.rev;
+        let op = m.op;
+        if (pending !== null) [pending, op] = transform(pending, op);
+        if (buffer !== null) [buffer, op] = transform(buffer, op);
+        text = apply(op, text);
+        show(op);
+        break;
+      }
+      case 'presence':
+        presence.replaceChildren();
+        for (const user of m.users) {
+          const li = document.createElement('li');
+          li.textContent = user;
+          presence.append(li);
+        }
+        break;
+      case 'saved':
+        // The form saves on top of the collaborative edits.
+        version.value = m.version;
+        break;
+    }
+  });
+
+  ws.addEventListener('close', function () {
+    ready = false;
+    presence.replaceChildren();
+    const li = document.createElement('li');
+    li.textContent = 'Disconnected, reload the page to keep editing together.';
+    presence.append(li);
+  });
+});
@@ -0,0 +1,178 @@
+/**
+ * @license
+ * Copyright 2020 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/**
+ * Keeps the list of notes up to date with the changes made from other tabs or
+ * devices, streamed by /notes/events.
+ *
+ * Notes are rendered with DOM APIs and textContent only, never as HTML, so
+ * their content cannot inject markup.
+ */
+document.addEventListener('DOMContentLoaded', function () {
+  const list = document.getElementById('notes');
+  if (!list || !window.EventSource) {
+    return;
+  }
+
+  /**
+   * Renders the title of a note like notes.tpl.html does, with the version
+   * that its body was rendered from.
+   */
+  function renderTitle(note) {
+    const dt = document.createElement('dt');
+    dt.dataset.title = note.title;
+    dt.dataset.version = note.version;
+    dt.className = 'color-' + (note.color || 'none');
+    if (note.pinned) {
+      const pinned = document.createElement('span');
+      pinned.className = 'pinned';
+      pinned.textContent = 'Pinned';
+      dt.append(pinned, ' ');
+    }
+    const edit = document.createElement('a');
+    edit.href = '/notes/edit?title=' + encodeURIComponent(note.title);
+    edit.textContent = 'Edit';
+    dt.append(note.title + ' ', edit, ' ');
+    for (const tag of note.tags || []) {
+      const a = document.createElement('a');
+      a.className = 'tag';
+      a.href = '/notes/?tag=' + encodeURIComponent(tag);
+      a.textContent = tag;
+      dt.append(a, ' ');
+    }
+    return dt;
+  }
+
+  /**
+   * Renders the body of a note. The text of Markdown notes edited since the
+   * page was loaded is shown as is until it is reloaded: formatting it here
+   * would need an HTML sink, which the Trusted Types policy does not allow.
+   */
+  function renderBody(note) {
+    const dd = document.createElement('dd');
+    dd.className = 'color-' + (note.color || 'none');
+    const pre = document.createElement('pre');
+    pre.textContent = note.text;
+    dd.append(pre);
+    return dd;
+  }
+
+  /** Returns the title of the note with the given title in the list, if any. */
+  function find(title) {
+    // Titles are compared as data, not used in a selector, so they need no
+    // escaping.
+    for (const dt of list.getElementsByTagName('dt')) {
+      if (dt.dataset.title === title) {
+        return dt;
+      }
+    }
+    return null;
+  }
+
+  /** Removes the title of a note from the list, with its body. */
+  function remove(dt) {
+    const dd = dt.nextElementSibling;
+    dd.nextElementSibling.remove();
+    dd.remove();
+    dt.remove();
+  }
+
+  /**
+   * Reports whether note belongs in the list: it is not archived, and has the
+   * tags the list is filtered by or is in the notebook shown if there are
+   * none.
+   */
+  function shown(note) {
+    if (note.archived) {
+      return false;
+    }
+    const filter = list.dataset.tags.split(' ').filter(Boolean);
+    if (filter.length === 0) {
+      return (note.notebook || '') === list.dataset.notebook;
+    }
+    const tags = note.tags || [];
+    const has = (tag) => tags.includes(tag);
+    return list.dataset.match === 'any' ? filter.some(has) : filter.every(has);
+  }
+
+  /**
+   * Adds or updates a note in the list, or removes it if it does not belong
+   * there anymore, e.g. because it was moved to the trash. Its body is only
+   * replaced if the note was edited, so that notes rendered by the server,
+   * Markdown included, stay as they are when only their state changes.
+   *
+   * Returns the title of the note in the list, if it is there.
+   */
+  function upsert(note) {
+    const here = !note.deleted && shown(note);
+    const dt = find(note.title);
+    if (!dt) {
+      if (!here) {
+        return null;
+      }
+      const title = renderTitle(note);
+      list.append(title, renderBody(note), document.createElement('br'));
+      return title;
+    }
+    if (!here) {
+      remove(dt);
+      return null;
+    }
+    const title = renderTitle(note);
+    const dd = dt.nextElementSibling;
+    if (dt.dataset.version === title.dataset.version) {
+      dd.className = title.className;
+    } else {
+      const body = renderBody(note);
+      // Events do not carry attachments, which do not change with the note.
+      const attachments = dd.querySelector('.attachments');
+      if (attachments) {
+        body.append(attachments);
+      }
+      dd.replaceWith(body);
+    }
+    dt.replaceWith(title);
+    return title;
+  }
+
+  const events = new EventSource('/notes/events');
+  // Sent on every (re)connection, so that changes missed while disconnected
+  // are caught up.
+  events.addEventListener('notes', function (e) {
+    const notes = JSON.parse(e.data) || [];
+    const titles = new Set(notes.map((note) => note.title));
+    for (const dt of Array.from(list.getElementsByTagName('dt'))) {
+      if (!titles.has(dt.dataset.title)) {
+        remove(dt);
+      }
+    }
+    // Pinned notes first, like storage.SortNotes. Appending the nodes that are
+    // already in the list moves them, so they keep their content.
+    notes.sort(function (a, b) {
+      return (b.pinned - a.pinned) || a.title.localeCompare(b.title);
+    }).forEach(function (note) {
+      const dt = upsert(note);
+      if (dt) {
+        const dd = dt.nextElementSibling;
+        list.append(dt, dd, dd.nextElementSibling);
+      }
+    });
+  });
+  events.addEventListener('note', function (e) {
+    upsert(JSON.parse(e.data));
+  });
+});
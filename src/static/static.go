@@ -0,0 +1,32 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package static embeds the stylesheets, scripts and fonts used by the pages of
+// the application.
+package static
+
+import (
+	"embed"
+
+	"github.com/empijei/go-safeweb-example-app/src/secure/assets"
+)
+
+// Path is the URL path the files are served under.
+const Path = "/static/"
+
+//go:embed *.css *.js fonts
+var files embed.FS
+
+// Assets serves the files under Path.
+var Assets = assets.MustNew(files, Path)
@@ -0,0 +1,61 @@
+/**
+ * @license
+ * Copyright 2020 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/**
+ * Autocompletes the tag being typed in the inputs using the
+ * #tag-suggestions datalist, with the tags returned by /api/tags.
+ *
+ * Inputs hold several tags separated by commas, and a datalist suggests whole
+ * values, so suggestions are the input with its last tag completed.
+ */
+document.addEventListener('DOMContentLoaded', function () {
+  const datalist = document.getElementById('tag-suggestions');
+  if (!datalist || !window.fetch) {
+    return;
+  }
+  let last = null;
+
+  async function suggest(input) {
+    const value = input.value;
+    const cut = value.lastIndexOf(',') + 1;
+    const head = value.slice(0, cut) + (cut > 0 ? ' ' : '');
+    const prefix = value.slice(cut).trim();
+    if (prefix === last) return;
+    last = prefix;
+    const resp = await fetch('/api/tags?prefix=' + encodeURIComponent(prefix));
+    if (!resp.ok || prefix !== last) return;
+    // JSON responses start with a line that prevents them from being included
+    // as scripts.
+    const body = await resp.text();
+    const tags = JSON.parse(body.slice(body.indexOf('\n') + 1));
+    datalist.replaceChildren(...tags.map(function (tag) {
+      const option = document.createElement('option');
+      option.value = head + tag;
+      return option;
+    }));
+  }
+
+  for (const input of document.querySelectorAll('input[list="tag-suggestions"]')) {
+    input.addEventListener('input', function () {
+      suggest(input).catch(function () {});
+    });
+    input.addEventListener('focus', function () {
+      last = null;
+      suggest(input).catch(function () {});
+    });
+  }
+});
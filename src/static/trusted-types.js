@@ -0,0 +1,39 @@
+/**
+ * @license
+ * Copyright 2020 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/**
+ * The only Trusted Types policy the CSP allows to create, see
+ * https://w3c.github.io/trusted-types/dist/spec/.
+ *
+ * None of our scripts write to DOM XSS sinks: they build the DOM with
+ * createElement and textContent. The policy only exists to claim its name
+ * before any other script can, which the CSP then forbids, so it rejects
+ * everything. A script that needs a sink must add a rule here that does not
+ * trust its input.
+ */
+(function () {
+  const reject = function () {
+    throw new TypeError('DOM XSS sinks are not used by this application');
+  };
+  if (window.trustedTypes && window.trustedTypes.createPolicy) {
+    window.trustedTypes.createPolicy('notekeeper', {
+      createHTML: reject,
+      createScript: reject,
+      createScriptURL: reject,
+    });
+  }
+})();
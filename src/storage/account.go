@@ -0,0 +1,75 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import "log"
+
+// DeleteAccount deletes user and all their data. It returns the attachments
+// of their notes, including those in the trash, so that the caller deletes
+// their content.
+//
+// The data key of user is deleted first: this makes their notes unreadable in
+// any copy of the storage, like backups, even if the rest fails ("crypto-
+// shredding").
+func (s *DB) DeleteAccount(user string) []Attachment {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.vault.Forget(user); err != nil {
+		log.Printf("Deleting the data key of %q: %v", user, err)
+	}
+
+	var as []Attachment
+	for id, a := range s.attachments {
+		if a.Owner == user {
+			as = append(as, a)
+			delete(s.attachments, id)
+		}
+	}
+	for _, t := range s.trash[user] {
+		as = append(as, t.attachments...)
+	}
+	delete(s.trash, user)
+	delete(s.notes, user)
+	delete(s.notebooks, user)
+	delete(s.shares, user)
+	for owner, refs := range s.shares {
+		for ref, users := range refs {
+			delete(users, user)
+			if len(users) == 0 {
+				delete(refs, ref)
+			}
+		}
+		if len(refs) == 0 {
+			delete(s.shares, owner)
+		}
+	}
+	for _, ts := range s.trash {
+		for _, t := range ts {
+			delete(t.shares, user)
+		}
+	}
+	for id, l := range s.links {
+		if l.Owner == user {
+			delete(s.links, id)
+		}
+	}
+
+	if token, ok := s.userSessions[user]; ok {
+		delete(s.sessionTokens, token)
+	}
+	delete(s.userSessions, user)
+	delete(s.credentials, user)
+	return as
+}
@@ -0,0 +1,56 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/empijei/go-safeweb-example-app/src/secure/envelope"
+)
+
+func TestDeleteAccountShredsNotes(t *testing.T) {
+	keys, err := envelope.NewKeyring([]string{"master"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := envelope.NewMemoryKeyStore()
+	vault := envelope.NewVault(keys, store)
+	db := NewDB(vault)
+	if _, err := db.AddOrEditNote("alice", Note{Title: "note", Text: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	// A copy of the storage, like a backup.
+	ref := db.refLocked("alice", "note")
+	sn := db.notes["alice"][ref]
+
+	db.DeleteAccount("alice")
+	if users, _ := store.Users(); len(users) != 0 {
+		t.Errorf("Data keys after DeleteAccount() = %q, want none", users)
+	}
+	for field, sealed := range map[string][]byte{"title": sn.title, "text": sn.text} {
+		if _, err := vault.Open("alice", ref, field, sealed); !errors.Is(err, envelope.ErrNoKey) {
+			t.Errorf("Opening the %s after DeleteAccount(): got err %v, want ErrNoKey", field, err)
+		}
+	}
+
+	// Not even by a new account with the same name.
+	if _, err := db.AddOrEditNote("alice", Note{Title: "note", Text: "new"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vault.Open("alice", ref, "text", sn.text); !errors.Is(err, envelope.ErrDecrypt) {
+		t.Errorf("Opening the text with a new data key: got err %v, want ErrDecrypt", err)
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// maxAttachmentsPerNote bounds the attachments of a note.
+const maxAttachmentsPerNote = 20
+
+// ErrTooManyAttachments is returned when adding an attachment to a note that
+// has maxAttachmentsPerNote already.
+var ErrTooManyAttachments = errors.New("too many attachments")
+
+// Attachment is a file attached to a note. Its content is kept in a blob
+// store, under its ID.
+type Attachment struct {
+	// ID is random and URL safe, see newID.
+	ID    string
+	Owner string
+	// Title is not stored, only the ref of the note is, see sealedNote. It is
+	// set in the attachments returned with their note, not in those returned
+	// to delete their content.
+	Title string
+	// Name is the file name, as uploaded.
+	Name        string
+	ContentType string
+	Size        int64
+	Created     time.Time
+
+	ref string
+}
+
+// NewAttachmentID returns the ID of a new attachment, to store its content
+// before calling AddAttachment.
+func NewAttachmentID() string {
+	return newID()
+}
+
+// AddAttachment attaches a to the note a.Owner and a.Title, on behalf of
+// user, which must be the owner or an editor.
+func (s *DB) AddAttachment(user string, a Attachment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a.ref = s.refLocked(a.Owner, a.Title)
+	switch r := s.roleLocked(user, a.Owner, a.ref); {
+	case r == NoAccess:
+		return ErrNotFound
+	case !r.CanEdit():
+		return ErrForbidden
+	}
+	if len(s.attachmentsLocked(a.Owner, a.ref)) >= maxAttachmentsPerNote {
+		return ErrTooManyAttachments
+	}
+	a.Title = ""
+	s.attachments[a.ID] = a
+	return nil
+}
+
+// GetAttachments returns the attachments of the note of owner, oldest first.
+func (s *DB) GetAttachments(owner, title string) []Attachment {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return withTitle(s.attachmentsLocked(owner, s.refLocked(owner, title)), title)
+}
+
+// attachmentsLocked returns the attachments of the note of owner stored under
+// ref, as stored.
+func (s *DB) attachmentsLocked(owner, ref string) []Attachment {
+	var as []Attachment
+	for _, a := range s.attachments {
+		if a.Owner == owner && a.ref == ref {
+			as = append(as, a)
+		}
+	}
+	sort.Slice(as, func(i, j int) bool { return as[i].Created.Before(as[j].Created) })
+	return as
+}
+
+// withTitle sets the title of the note of attachments.
+func withTitle(as []Attachment, title string) []Attachment {
+	for i := range as {
+		as[i].Title = title
+	}
+	return as
+}
+
+// GetAllAttachments returns the attachments of all the notes of owner, by
+// note title.
+func (s *DB) GetAllAttachments(owner string) map[string][]Attachment {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all := map[string][]Attachment{}
+	titles := map[string]string{}
+	for _, a := range s.attachments {
+		if a.Owner != owner {
+			continue
+		}
+		title, ok := titles[a.ref]
+		if !ok {
+			title = s.titleLocked(owner, a.ref)
+			titles[a.ref] = title
+		}
+		a.Title = title
+		all[title] = append(all[title], a)
+	}
+	for _, as := range all {
+		sort.Slice(as, func(i, j int) bool { return as[i].Created.Before(as[j].Created) })
+	}
+	return all
+}
+
+// GetAttachmentAs returns an attachment, if user can see its note.
+func (s *DB) GetAttachmentAs(user, id string) (Attachment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.attachments[id]
+	if !ok || s.roleLocked(user, a.Owner, a.ref) == NoAccess {
+		return Attachment{}, ErrNotFound
+	}
+	a.Title = s.titleLocked(a.Owner, a.ref)
+	return a, nil
+}
+
+// DeleteAttachmentAs deletes an attachment on behalf of user, which must be
+// the owner or an editor of its note. The caller must delete its content.
+func (s *DB) DeleteAttachmentAs(user, id string) (Attachment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.attachments[id]
+	if !ok {
+		return Attachment{}, ErrNotFound
+	}
+	switch r := s.roleLocked(user, a.Owner, a.ref); {
+	case r == NoAccess:
+		return Attachment{}, ErrNotFound
+	case !r.CanEdit():
+		return Attachment{}, ErrForbidden
+	}
+	delete(s.attachments, id)
+	a.Title = s.titleLocked(a.Owner, a.ref)
+	return a, nil
+}
@@ -0,0 +1,119 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"log"
+
+	"github.com/empijei/go-safeweb-example-app/src/secure/envelope"
+)
+
+// Note titles and texts are encrypted at rest by the vault, with the data key
+// of their owner, see envelope.Vault. Notes are stored under a blind index of
+// their title, their ref, so that they can still be looked up by title. The
+// other fields of notes are kept in plaintext to list and organize them.
+//
+// A real database would store notes and everything keyed by refs, and the
+// wrapped data keys through an envelope.KeyStore.
+
+// sealedNote is a note as stored: Title and Text are empty, and kept
+// encrypted in title and text.
+type sealedNote struct {
+	Note
+	title, text []byte
+}
+
+// refLocked returns the ref of the note of user with the given title. It is
+// empty if user has no data key, and thus no note, or if it cannot be
+// unwrapped, which is logged: their data is then treated as missing.
+func (s *DB) refLocked(user, title string) string {
+	ref, err := s.vault.Ref(user, title)
+	if err != nil && !errors.Is(err, envelope.ErrNoKey) {
+		log.Printf("Unwrapping the data key of %q: %v", user, err)
+	}
+	return ref
+}
+
+// noteLocked returns the note of user with the given title, if any.
+func (s *DB) noteLocked(user, title string) (Note, bool) {
+	return s.openLocked(user, s.refLocked(user, title))
+}
+
+// openLocked returns the note of user stored under ref, if any.
+func (s *DB) openLocked(user, ref string) (Note, bool) {
+	sn, ok := s.notes[user][ref]
+	if !ok {
+		return Note{}, false
+	}
+	return s.openNoteLocked(user, ref, sn)
+}
+
+// openNoteLocked decrypts a note of user stored under ref. Notes that cannot
+// be decrypted are logged and treated as missing.
+func (s *DB) openNoteLocked(user, ref string, sn sealedNote) (Note, bool) {
+	title, err := s.vault.Open(user, ref, "title", sn.title)
+	if err != nil {
+		log.Printf("Decrypting a note of %q: %v", user, err)
+		return Note{}, false
+	}
+	text, err := s.vault.Open(user, ref, "text", sn.text)
+	if err != nil {
+		log.Printf("Decrypting a note of %q: %v", user, err)
+		return Note{}, false
+	}
+	n := sn.Note
+	n.Title, n.Text = string(title), string(text)
+	return n, true
+}
+
+// notesLocked returns all the notes of user.
+func (s *DB) notesLocked(user string) []Note {
+	var ns []Note
+	for ref, sn := range s.notes[user] {
+		if n, ok := s.openNoteLocked(user, ref, sn); ok {
+			ns = append(ns, n)
+		}
+	}
+	return ns
+}
+
+// titleLocked returns the title of the note of user stored under ref.
+func (s *DB) titleLocked(user, ref string) string {
+	n, _ := s.openLocked(user, ref)
+	return n.Title
+}
+
+// putNoteLocked encrypts and stores a note of user, creating their data key
+// for their first note.
+func (s *DB) putNoteLocked(user string, n Note) error {
+	ref, err := s.vault.NewRef(user, n.Title)
+	if err != nil {
+		return err
+	}
+	sn := sealedNote{Note: n}
+	if sn.title, err = s.vault.Seal(user, ref, "title", []byte(n.Title)); err != nil {
+		return err
+	}
+	if sn.text, err = s.vault.Seal(user, ref, "text", []byte(n.Text)); err != nil {
+		return err
+	}
+	sn.Title, sn.Text = "", ""
+	if s.notes[user] == nil {
+		s.notes[user] = map[string]sealedNote{}
+	}
+	s.notes[user][ref] = sn
+	return nil
+}
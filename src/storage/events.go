@@ -0,0 +1,118 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"sync"
+)
+
+const (
+	// subscriptionBuffer is the number of changes a subscriber can fall behind
+	// before it is dropped.
+	subscriptionBuffer = 16
+	// maxSubscriptionsPerUser bounds the resources a single user can hold,
+	// e.g. with many open tabs.
+	maxSubscriptionsPerUser = 8
+)
+
+// ErrTooManySubscriptions is returned by Subscribe when a user already has
+// too many subscriptions.
+var ErrTooManySubscriptions = errors.New("too many subscriptions")
+
+// ErrStopped is returned by Subscribe after StopEvents was called.
+var ErrStopped = errors.New("events are stopped")
+
+type subscription struct {
+	notes chan Note
+}
+
+// broker fans out the changes to the notes of each user to their subscribers.
+type broker struct {
+	mu      sync.Mutex
+	stopped bool
+	// user -> subscriptions
+	subs map[string]map[*subscription]bool
+}
+
+// Subscribe returns the notes of user as they are added or edited, and a
+// function to cancel the subscription.
+//
+// Publishing never blocks: a subscriber that does not keep up is dropped. The
+// channel is closed when the subscription is canceled, dropped or stopped by
+// StopEvents, after which the subscriber should fetch all the notes again.
+func (s *DB) Subscribe(user string) (notes <-chan Note, cancel func(), err error) {
+	b := &s.events
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.stopped {
+		return nil, nil, ErrStopped
+	}
+	if len(b.subs[user]) >= maxSubscriptionsPerUser {
+		return nil, nil, ErrTooManySubscriptions
+	}
+	if b.subs[user] == nil {
+		b.subs[user] = map[*subscription]bool{}
+	}
+	sub := &subscription{notes: make(chan Note, subscriptionBuffer)}
+	b.subs[user][sub] = true
+	cancel = func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.remove(user, sub)
+	}
+	return sub.notes, cancel, nil
+}
+
+// StopEvents closes all the subscriptions and rejects new ones. It is meant to
+// be called when the server shuts down, so that long-lived requests waiting
+// for events can end.
+func (s *DB) StopEvents() {
+	b := &s.events
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stopped = true
+	for user, subs := range b.subs {
+		for sub := range subs {
+			b.remove(user, sub)
+		}
+	}
+}
+
+// publish sends n to the subscribers of user.
+func (b *broker) publish(user string, n Note) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subs[user] {
+		select {
+		case sub.notes <- n:
+		default:
+			// The subscriber fell behind, it will miss this change.
+			b.remove(user, sub)
+		}
+	}
+}
+
+// remove closes a subscription, if it was not already. b.mu must be held.
+func (b *broker) remove(user string, sub *subscription) {
+	if !b.subs[user][sub] {
+		return
+	}
+	delete(b.subs[user], sub)
+	if len(b.subs[user]) == 0 {
+		delete(b.subs, user)
+	}
+	close(sub.notes)
+}
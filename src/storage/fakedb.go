@@ -15,12 +15,15 @@
 package storage
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/base64"
 	"errors"
 	"sync"
 
 	"golang.org/x/crypto/scrypt"
+
+	"github.com/empijei/go-safeweb-example-app/src/secure/envelope"
 )
 
 // Note: a real program would connect to a real DB using
@@ -31,12 +34,46 @@ import (
 
 type Note struct {
 	Title, Text string
+	// Markdown is set for notes whose text is Markdown, and shown formatted.
+	Markdown bool
+	// Notebook is the ID of the notebook of the note, empty for notes at the
+	// top level. Edits keep the notebook of a note, see MoveNote.
+	Notebook string
+	// Tags are normalized and sorted, see NormalizeTags.
+	Tags []string
+	// Pinned, Archived and Color organize the notes of the owner, and like
+	// Notebook are kept by edits, see PinNote.
+	Pinned   bool
+	Archived bool
+	Color    string
+	// Deleted is only set in the events for notes moved to the trash.
+	Deleted bool
+	// Version is incremented by every edit. When editing a note, it must be
+	// the version the edit is based on, or 0 to create a new note.
+	Version int
 }
 
+// ErrConflict is returned when editing a note that was modified since the
+// version the edit is based on.
+var ErrConflict = errors.New("the note was modified concurrently")
+
 type DB struct {
 	mu sync.Mutex
-	// user -> note title -> notes
-	notes map[string]map[string]Note
+	// user -> note ref -> notes, see sealedNote
+	notes map[string]map[string]sealedNote
+	// owner -> note ref -> user -> role
+	shares map[string]map[string]map[string]Role
+	// share link ID -> link
+	links map[string]ShareLink
+	// user -> notebook ID -> notebook
+	notebooks map[string]map[string]Notebook
+	// attachment ID -> attachment
+	attachments map[string]Attachment
+	// user -> note ref -> deleted note
+	trash map[string]map[string]trashedNote
+
+	// vault encrypts notes, see encryption.go.
+	vault *envelope.Vault
 
 	// user -> token
 	sessionTokens map[string]string
@@ -45,36 +82,98 @@ type DB struct {
 
 	// user -> pw hash
 	credentials map[string]string
+
+	// events has its own lock, so that subscribers do not contend with
+	// storage operations.
+	events broker
+
+	closed bool
 }
 
-func NewDB() *DB {
+// NewDB returns a storage that encrypts notes with vault.
+func NewDB(vault *envelope.Vault) *DB {
 	return &DB{
-		notes:         map[string]map[string]Note{},
+		notes:         map[string]map[string]sealedNote{},
+		shares:        map[string]map[string]map[string]Role{},
+		links:         map[string]ShareLink{},
+		notebooks:     map[string]map[string]Notebook{},
+		attachments:   map[string]Attachment{},
+		trash:         map[string]map[string]trashedNote{},
+		vault:         vault,
 		sessionTokens: map[string]string{},
 		userSessions:  map[string]string{},
 		credentials:   map[string]string{},
+		events:        broker{subs: map[string]map[*subscription]bool{}},
+	}
+}
+
+// Close flushes any pending write and releases the storage.
+//
+// This in-memory storage has nothing to flush, but a real database would
+// need to be closed when the program terminates.
+func (s *DB) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+// Ping checks that the storage is reachable.
+func (s *DB) Ping(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errors.New("storage is closed")
 	}
+	return ctx.Err()
 }
 
 // Notes
 
-func (s *DB) AddOrEditNote(user string, n Note) {
+// AddOrEditNote stores n, unless the stored note has a version other than
+// n.Version. It returns the stored note, which is the current one in case of
+// ErrConflict.
+func (s *DB) AddOrEditNote(user string, n Note) (Note, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if s.notes[user] == nil {
-		s.notes[user] = map[string]Note{}
+	return s.editNoteLocked(user, n)
+}
+
+func (s *DB) editNoteLocked(user string, n Note) (Note, error) {
+	cur, exists := s.noteLocked(user, n.Title)
+	if cur.Version != n.Version {
+		return cur, ErrConflict
 	}
-	s.notes[user][n.Title] = n
+	if exists {
+		n.Notebook = cur.Notebook
+		n.Pinned, n.Archived, n.Color = cur.Pinned, cur.Archived, cur.Color
+	} else if _, ok := s.notebooks[user][n.Notebook]; n.Notebook != "" && !ok {
+		return Note{}, ErrNoSuchNotebook
+	}
+	tags, err := NormalizeTags(n.Tags)
+	if err != nil {
+		return Note{}, err
+	}
+	n.Tags = tags
+	n.Version++
+	if err := s.putNoteLocked(user, n); err != nil {
+		return Note{}, err
+	}
+	s.events.publish(user, n)
+	return n, nil
+}
+
+// GetNote returns the note of user with the given title, if any.
+func (s *DB) GetNote(user, title string) (n Note, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.noteLocked(user, title)
 }
 
 func (s *DB) GetNotes(user string) []Note {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	var ns []Note
-	for _, n := range s.notes[user] {
-		ns = append(ns, n)
-	}
-	return ns
+	return s.notesLocked(user)
 }
 
 // Sessions
@@ -110,6 +209,13 @@ func (s *DB) DelSession(user string) {
 	delete(s.sessionTokens, token)
 }
 
+// newID returns a random, URL safe ID.
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
 func genToken() string {
 	b := make([]byte, 20)
 	rand.Read(b)
@@ -152,3 +258,23 @@ func hash(pw string) string {
 	}
 	return string(hash)
 }
+
+// Stats
+
+// Stats holds the number of entities currently in storage.
+type Stats struct {
+	Users, Notes, Sessions int
+}
+
+func (s *DB) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := Stats{
+		Users:    len(s.credentials),
+		Sessions: len(s.sessionTokens),
+	}
+	for _, ns := range s.notes {
+		st.Notes += len(ns)
+	}
+	return st
+}
@@ -0,0 +1,120 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// maxLinksPerNote bounds the share links of a note.
+const maxLinksPerNote = 16
+
+// ShareLink gives read-only access to a note to anyone who has it.
+type ShareLink struct {
+	// ID is random and URL safe, see newID.
+	ID    string
+	Owner string
+	// Title is not stored, only the ref of the note is, see sealedNote.
+	Title   string
+	Created time.Time
+	// Expires is zero for links that never expire.
+	Expires time.Time
+
+	ref string
+}
+
+func (l ShareLink) expired(now time.Time) bool {
+	return !l.Expires.IsZero() && !now.Before(l.Expires)
+}
+
+// CreateShareLink creates a link to the note of owner.
+func (s *DB) CreateShareLink(owner, title string, expires time.Time) (ShareLink, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ref := s.refLocked(owner, title)
+	if _, ok := s.notes[owner][ref]; !ok {
+		return ShareLink{}, ErrNotFound
+	}
+	if len(s.linksLocked(owner, ref, time.Now())) >= maxLinksPerNote {
+		return ShareLink{}, errors.New("too many share links")
+	}
+	l := ShareLink{
+		ID:      newID(),
+		Owner:   owner,
+		Created: time.Now(),
+		Expires: expires,
+		ref:     ref,
+	}
+	s.links[l.ID] = l
+	l.Title = title
+	return l, nil
+}
+
+// GetShareLinks returns the links to the note of owner that did not expire,
+// oldest first.
+func (s *DB) GetShareLinks(owner, title string) []ShareLink {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ls := s.linksLocked(owner, s.refLocked(owner, title), time.Now())
+	for i := range ls {
+		ls[i].Title = title
+	}
+	return ls
+}
+
+// linksLocked returns the links to the note of owner stored under ref, as
+// stored.
+func (s *DB) linksLocked(owner, ref string, now time.Time) []ShareLink {
+	var ls []ShareLink
+	for id, l := range s.links {
+		if l.expired(now) {
+			delete(s.links, id)
+			continue
+		}
+		if l.Owner == owner && l.ref == ref {
+			ls = append(ls, l)
+		}
+	}
+	sort.Slice(ls, func(i, j int) bool { return ls[i].Created.Before(ls[j].Created) })
+	return ls
+}
+
+// RevokeShareLink deletes a link to a note of owner.
+func (s *DB) RevokeShareLink(owner, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.links[id].Owner == owner {
+		delete(s.links, id)
+	}
+}
+
+// ResolveShareLink returns the note a link points to, or ErrNotFound if the
+// link was revoked or expired.
+func (s *DB) ResolveShareLink(id string, now time.Time) (Note, ShareLink, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.links[id]
+	if !ok || l.expired(now) {
+		return Note{}, ShareLink{}, ErrNotFound
+	}
+	n, ok := s.openLocked(l.Owner, l.ref)
+	if !ok {
+		return Note{}, ShareLink{}, ErrNotFound
+	}
+	l.Title = n.Title
+	return n, l, nil
+}
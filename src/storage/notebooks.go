@@ -0,0 +1,248 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxNotebooks bounds the notebooks of a user.
+	maxNotebooks = 256
+	// maxNotebookDepth bounds the nesting of notebooks.
+	maxNotebookDepth = 16
+)
+
+// Notebook is a folder of notes and other notebooks.
+type Notebook struct {
+	// ID is random and URL safe, see newID.
+	ID   string
+	Name string
+	// Parent is the ID of the parent notebook, empty for top level notebooks.
+	Parent string
+}
+
+var (
+	// ErrNoSuchNotebook is returned for notebooks that do not exist.
+	ErrNoSuchNotebook = errors.New("no such notebook")
+	// ErrNotebookExists is returned when a notebook would have the same name
+	// as another one in the same parent.
+	ErrNotebookExists = errors.New("a notebook with this name already exists")
+	// ErrInvalidNotebook is returned for invalid names, cycles, and when the
+	// limits on notebooks would be exceeded.
+	ErrInvalidNotebook = errors.New("invalid notebook")
+)
+
+// CreateNotebook creates a notebook of user in parent, which is empty for the
+// top level.
+func (s *DB) CreateNotebook(user, name, parent string) (Notebook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	nb := Notebook{ID: newID(), Name: strings.TrimSpace(name), Parent: parent}
+	if len(s.notebooks[user]) >= maxNotebooks {
+		return Notebook{}, ErrInvalidNotebook
+	}
+	if err := s.checkNotebookLocked(user, nb); err != nil {
+		return Notebook{}, err
+	}
+	if s.notebooks[user] == nil {
+		s.notebooks[user] = map[string]Notebook{}
+	}
+	s.notebooks[user][nb.ID] = nb
+	return nb, nil
+}
+
+// RenameNotebook renames a notebook of user.
+func (s *DB) RenameNotebook(user, id, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	nb, ok := s.notebooks[user][id]
+	if !ok {
+		return ErrNoSuchNotebook
+	}
+	nb.Name = strings.TrimSpace(name)
+	if err := s.checkNotebookLocked(user, nb); err != nil {
+		return err
+	}
+	s.notebooks[user][id] = nb
+	return nil
+}
+
+// MoveNotebook moves a notebook of user, and everything in it, to parent.
+func (s *DB) MoveNotebook(user, id, parent string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	nb, ok := s.notebooks[user][id]
+	if !ok {
+		return ErrNoSuchNotebook
+	}
+	nb.Parent = parent
+	if err := s.checkNotebookLocked(user, nb); err != nil {
+		return err
+	}
+	s.notebooks[user][id] = nb
+	return nil
+}
+
+// checkNotebookLocked checks that nb can be stored: its name is valid and
+// unique in its parent, and its parent exists and is not nb or one of its
+// descendants.
+func (s *DB) checkNotebookLocked(user string, nb Notebook) error {
+	if nb.Name == "" || len(nb.Name) > 100 {
+		return ErrInvalidNotebook
+	}
+	for _, other := range s.notebooks[user] {
+		if other.ID != nb.ID && other.Parent == nb.Parent && other.Name == nb.Name {
+			return ErrNotebookExists
+		}
+	}
+	depth := 1
+	for p := nb.Parent; p != ""; p = s.notebooks[user][p].Parent {
+		if _, ok := s.notebooks[user][p]; !ok {
+			return ErrNoSuchNotebook
+		}
+		if p == nb.ID {
+			return ErrInvalidNotebook
+		}
+		depth++
+	}
+	if depth+s.heightLocked(user, nb.ID) > maxNotebookDepth {
+		return ErrInvalidNotebook
+	}
+	return nil
+}
+
+// heightLocked returns the number of levels of notebooks under id.
+func (s *DB) heightLocked(user, id string) int {
+	h := 0
+	for _, child := range s.notebooks[user] {
+		if child.Parent == id && id != "" {
+			if ch := 1 + s.heightLocked(user, child.ID); ch > h {
+				h = ch
+			}
+		}
+	}
+	return h
+}
+
+// DeleteNotebook deletes a notebook of user. The notes and notebooks in it are
+// moved to its parent, keeping their names unique.
+func (s *DB) DeleteNotebook(user, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	nb, ok := s.notebooks[user][id]
+	if !ok {
+		return ErrNoSuchNotebook
+	}
+	delete(s.notebooks[user], id)
+	for _, child := range s.notebooks[user] {
+		if child.Parent != id {
+			continue
+		}
+		child.Parent = nb.Parent
+		base := child.Name
+		for i := 2; s.checkNotebookLocked(user, child) == ErrNotebookExists; i++ {
+			child.Name = base + " (" + strconv.Itoa(i) + ")"
+		}
+		s.notebooks[user][child.ID] = child
+	}
+	for ref, sn := range s.notes[user] {
+		if sn.Notebook == id {
+			sn.Notebook = nb.Parent
+			s.notes[user][ref] = sn
+			if n, ok := s.openNoteLocked(user, ref, sn); ok {
+				s.events.publish(user, n)
+			}
+		}
+	}
+	return nil
+}
+
+// MoveNote moves a note of user to a notebook, empty for the top level. The
+// version of the note does not change, as its content does not.
+func (s *DB) MoveNote(user, title, notebook string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, ok := s.noteLocked(user, title)
+	if !ok {
+		return ErrNotFound
+	}
+	if _, ok := s.notebooks[user][notebook]; notebook != "" && !ok {
+		return ErrNoSuchNotebook
+	}
+	n.Notebook = notebook
+	if err := s.putNoteLocked(user, n); err != nil {
+		return err
+	}
+	s.events.publish(user, n)
+	return nil
+}
+
+// GetNotebook returns a notebook of user.
+func (s *DB) GetNotebook(user, id string) (Notebook, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	nb, ok := s.notebooks[user][id]
+	return nb, ok
+}
+
+// GetNotebooks returns all the notebooks of user, sorted by name.
+func (s *DB) GetNotebooks(user string) []Notebook {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var nbs []Notebook
+	for _, nb := range s.notebooks[user] {
+		nbs = append(nbs, nb)
+	}
+	sort.Slice(nbs, func(i, j int) bool {
+		if nbs[i].Name != nbs[j].Name {
+			return nbs[i].Name < nbs[j].Name
+		}
+		return nbs[i].ID < nbs[j].ID
+	})
+	return nbs
+}
+
+// GetNotesIn returns the notes of user directly in a notebook, empty for the
+// top level.
+func (s *DB) GetNotesIn(user, notebook string) []Note {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ns []Note
+	for _, n := range s.notesLocked(user) {
+		if n.Notebook == notebook {
+			ns = append(ns, n)
+		}
+	}
+	return ns
+}
+
+// CountNotes returns the number of notes of user directly in each notebook,
+// with the key "" for the top level. Archived notes are not counted.
+func (s *DB) CountNotes(user string) map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := map[string]int{}
+	for _, n := range s.notes[user] {
+		if n.Archived {
+			continue
+		}
+		counts[n.Notebook]++
+	}
+	return counts
+}
@@ -0,0 +1,218 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"sort"
+)
+
+// Role is what a user can do with a note.
+type Role int
+
+const (
+	NoAccess Role = iota
+	Viewer
+	Editor
+	Owner
+)
+
+// ParseRole parses the roles notes can be shared with, "viewer" and "editor".
+func ParseRole(s string) (Role, bool) {
+	switch s {
+	case "viewer":
+		return Viewer, true
+	case "editor":
+		return Editor, true
+	}
+	return NoAccess, false
+}
+
+func (r Role) String() string {
+	switch r {
+	case Viewer:
+		return "viewer"
+	case Editor:
+		return "editor"
+	case Owner:
+		return "owner"
+	}
+	return "none"
+}
+
+// CanEdit reports whether r allows editing notes.
+func (r Role) CanEdit() bool {
+	return r >= Editor
+}
+
+// ErrNotFound is returned for notes that do not exist or that the user has no
+// access to, which are indistinguishable to not leak their existence.
+var ErrNotFound = errors.New("note not found")
+
+// ErrForbidden is returned when the role of a user does not allow an action.
+var ErrForbidden = errors.New("permission denied")
+
+// Share is a user a note is shared with.
+type Share struct {
+	User string
+	Role Role
+}
+
+// SharedNote is a note shared with a user.
+type SharedNote struct {
+	Owner       string
+	Role        Role
+	Note        Note
+	Attachments []Attachment
+}
+
+// ShareNote shares the note of owner with user, replacing any role user had.
+//
+// The user does not need to exist yet, so that sharing does not reveal which
+// users exist.
+func (s *DB) ShareNote(owner, title, user string, role Role) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if role != Viewer && role != Editor {
+		return errors.New("notes can only be shared with viewers and editors")
+	}
+	if user == "" || user == owner {
+		return errors.New("notes can only be shared with other users")
+	}
+	ref := s.refLocked(owner, title)
+	if _, ok := s.notes[owner][ref]; !ok {
+		return ErrNotFound
+	}
+	if s.shares[owner] == nil {
+		s.shares[owner] = map[string]map[string]Role{}
+	}
+	if s.shares[owner][ref] == nil {
+		s.shares[owner][ref] = map[string]Role{}
+	}
+	s.shares[owner][ref][user] = role
+	return nil
+}
+
+// RevokeShare stops sharing the note of owner with user.
+func (s *DB) RevokeShare(owner, title, user string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ref := s.refLocked(owner, title)
+	delete(s.shares[owner][ref], user)
+	if len(s.shares[owner][ref]) == 0 {
+		delete(s.shares[owner], ref)
+	}
+	if len(s.shares[owner]) == 0 {
+		delete(s.shares, owner)
+	}
+}
+
+// GetShares returns the users the note of owner is shared with, sorted by
+// name.
+func (s *DB) GetShares(owner, title string) []Share {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var shares []Share
+	for u, r := range s.shares[owner][s.refLocked(owner, title)] {
+		shares = append(shares, Share{User: u, Role: r})
+	}
+	sort.Slice(shares, func(i, j int) bool { return shares[i].User < shares[j].User })
+	return shares
+}
+
+// SharedWith returns the notes other users shared with user, sorted by owner
+// and title.
+func (s *DB) SharedWith(user string) []SharedNote {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var shared []SharedNote
+	for owner, refs := range s.shares {
+		for ref, users := range refs {
+			r, ok := users[user]
+			if !ok {
+				continue
+			}
+			if n, ok := s.openLocked(owner, ref); ok {
+				n.Notebook = ""
+				shared = append(shared, SharedNote{Owner: owner, Role: r, Note: n, Attachments: withTitle(s.attachmentsLocked(owner, ref), n.Title)})
+			}
+		}
+	}
+	sort.Slice(shared, func(i, j int) bool {
+		if shared[i].Owner != shared[j].Owner {
+			return shared[i].Owner < shared[j].Owner
+		}
+		return shared[i].Note.Title < shared[j].Note.Title
+	})
+	return shared
+}
+
+// NoteRole returns the role of user on the note of owner.
+func (s *DB) NoteRole(user, owner, title string) Role {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.roleLocked(user, owner, s.refLocked(owner, title))
+}
+
+func (s *DB) roleLocked(user, owner, ref string) Role {
+	if _, ok := s.notes[owner][ref]; !ok {
+		return NoAccess
+	}
+	if user == owner {
+		return Owner
+	}
+	return s.shares[owner][ref][user]
+}
+
+// GetNoteAs returns the note of owner with the given title, if user has
+// access to it, and the role of user. Notebooks are private to the owner, so
+// the notebook is only set for them.
+func (s *DB) GetNoteAs(user, owner, title string) (Note, Role, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ref := s.refLocked(owner, title)
+	r := s.roleLocked(user, owner, ref)
+	if r == NoAccess {
+		return Note{}, NoAccess, ErrNotFound
+	}
+	n, ok := s.openLocked(owner, ref)
+	if !ok {
+		return Note{}, NoAccess, ErrNotFound
+	}
+	if r != Owner {
+		n.Notebook = ""
+	}
+	return n, r, nil
+}
+
+// EditNoteAs is AddOrEditNote on behalf of user, which must be the owner or
+// an editor. Only owners can create notes.
+func (s *DB) EditNoteAs(user, owner string, n Note) (Note, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if user != owner {
+		switch r := s.roleLocked(user, owner, s.refLocked(owner, n.Title)); {
+		case r == NoAccess:
+			return Note{}, ErrNotFound
+		case !r.CanEdit():
+			return Note{}, ErrForbidden
+		}
+	}
+	n, err := s.editNoteLocked(owner, n)
+	if user != owner {
+		n.Notebook = ""
+	}
+	return n, err
+}
@@ -0,0 +1,77 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"sort"
+)
+
+// NoteColors are the colors notes can have, besides none.
+var NoteColors = []string{"red", "orange", "yellow", "green", "blue", "purple"}
+
+// ErrInvalidColor is returned for colors not in NoteColors.
+var ErrInvalidColor = errors.New("invalid color")
+
+// PinNote pins a note of user to the top of the lists, or unpins it. As
+// with MoveNote, the version of the note does not change.
+func (s *DB) PinNote(user, title string, pinned bool) (Note, error) {
+	return s.updateState(user, title, func(n *Note) { n.Pinned = pinned })
+}
+
+// ArchiveNote moves a note of user out of the lists of notes, or back.
+func (s *DB) ArchiveNote(user, title string, archived bool) (Note, error) {
+	return s.updateState(user, title, func(n *Note) { n.Archived = archived })
+}
+
+// ColorNote sets the color of a note of user, one of NoteColors or empty for
+// none.
+func (s *DB) ColorNote(user, title, color string) (Note, error) {
+	if color != "" {
+		i := 0
+		for i < len(NoteColors) && NoteColors[i] != color {
+			i++
+		}
+		if i == len(NoteColors) {
+			return Note{}, ErrInvalidColor
+		}
+	}
+	return s.updateState(user, title, func(n *Note) { n.Color = color })
+}
+
+func (s *DB) updateState(user, title string, update func(n *Note)) (Note, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, ok := s.noteLocked(user, title)
+	if !ok {
+		return Note{}, ErrNotFound
+	}
+	update(&n)
+	if err := s.putNoteLocked(user, n); err != nil {
+		return Note{}, err
+	}
+	s.events.publish(user, n)
+	return n, nil
+}
+
+// SortNotes sorts notes with the pinned ones first, then by title.
+func SortNotes(ns []Note) {
+	sort.Slice(ns, func(i, j int) bool {
+		if ns[i].Pinned != ns[j].Pinned {
+			return ns[i].Pinned
+		}
+		return ns[i].Title < ns[j].Title
+	})
+}
@@ -0,0 +1,175 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	// MaxTags bounds the tags of a note.
+	MaxTags = 20
+	// maxTagLen is the maximum length of a tag, in bytes.
+	maxTagLen = 32
+)
+
+// ErrInvalidTag is returned for tags that are empty, too long or contain
+// characters other than letters, digits, '-', '_' and '/', and for notes with
+// too many tags.
+var ErrInvalidTag = errors.New("invalid tag")
+
+// NormalizeTag returns the canonical form of a tag: trimmed and lower case.
+func NormalizeTag(tag string) (string, error) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if tag == "" || len(tag) > maxTagLen {
+		return "", ErrInvalidTag
+	}
+	for _, r := range tag {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '_' && r != '/' {
+			return "", ErrInvalidTag
+		}
+	}
+	return tag, nil
+}
+
+// ParseTags parses a list of tags separated by commas or spaces, as typed in
+// forms, and returns them normalized, sorted and without duplicates.
+func ParseTags(s string) ([]string, error) {
+	return NormalizeTags(strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	}))
+}
+
+// NormalizeTags normalizes tags, and returns them sorted and without
+// duplicates.
+func NormalizeTags(tags []string) ([]string, error) {
+	seen := map[string]bool{}
+	var out []string
+	for _, t := range tags {
+		t, err := NormalizeTag(t)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[t] {
+			seen[t] = true
+			out = append(out, t)
+		}
+	}
+	if len(out) > MaxTags {
+		return nil, ErrInvalidTag
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// hasTag reports whether n has tag. Tags are sorted.
+func (n Note) hasTag(tag string) bool {
+	i := sort.SearchStrings(n.Tags, tag)
+	return i < len(n.Tags) && n.Tags[i] == tag
+}
+
+// FindNotes returns the notes of user with all the given tags, or with any
+// of them if matchAny is set. Tags must be normalized.
+func (s *DB) FindNotes(user string, tags []string, matchAny bool) []Note {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ns []Note
+	for _, n := range s.notesLocked(user) {
+		if matchTags(n, tags, matchAny) {
+			ns = append(ns, n)
+		}
+	}
+	return ns
+}
+
+func matchTags(n Note, tags []string, matchAny bool) bool {
+	for _, t := range tags {
+		if n.hasTag(t) == matchAny {
+			return matchAny
+		}
+	}
+	return !matchAny
+}
+
+// TagCount is a tag and the number of notes with it.
+type TagCount struct {
+	Tag   string
+	Count int
+}
+
+// GetTags returns the tags of the notes of user, starting with prefix, sorted
+// by decreasing count and then by name.
+func (s *DB) GetTags(user, prefix string) []TagCount {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := map[string]int{}
+	for _, n := range s.notes[user] {
+		for _, t := range n.Tags {
+			if strings.HasPrefix(t, prefix) {
+				counts[t]++
+			}
+		}
+	}
+	var tcs []TagCount
+	for t, c := range counts {
+		tcs = append(tcs, TagCount{Tag: t, Count: c})
+	}
+	sort.Slice(tcs, func(i, j int) bool {
+		if tcs[i].Count != tcs[j].Count {
+			return tcs[i].Count > tcs[j].Count
+		}
+		return tcs[i].Tag < tcs[j].Tag
+	})
+	return tcs
+}
+
+// RenameTag renames a tag on all the notes of user, merging it with to if
+// some notes have both. As this edits the notes, their version is
+// incremented. It returns the number of notes changed.
+func (s *DB) RenameTag(user, from, to string) (int, error) {
+	from, err := NormalizeTag(from)
+	if err != nil {
+		return 0, err
+	}
+	if to, err = NormalizeTag(to); err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed := 0
+	for ref, sn := range s.notes[user] {
+		if !sn.hasTag(from) || from == to {
+			continue
+		}
+		tags := []string{to}
+		for _, t := range sn.Tags {
+			if t != from {
+				tags = append(tags, t)
+			}
+		}
+		// Tags are valid, and renaming does not add any.
+		sn.Tags, _ = NormalizeTags(tags)
+		sn.Version++
+		s.notes[user][ref] = sn
+		if n, ok := s.openNoteLocked(user, ref, sn); ok {
+			s.events.publish(user, n)
+		}
+		changed++
+	}
+	return changed, nil
+}
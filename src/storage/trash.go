@@ -0,0 +1,187 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// ErrTitleTaken is returned when restoring a note while another note has its
+// title.
+var ErrTitleTaken = errors.New("a note with this title already exists")
+
+// TrashedNote is a deleted note, which can be restored until it is purged.
+type TrashedNote struct {
+	Note
+	Deleted time.Time
+}
+
+// trashedNote is a TrashedNote as stored.
+type trashedNote struct {
+	note    sealedNote
+	deleted time.Time
+
+	// The shares, links and attachments of the note are kept with it, so that
+	// they are restored too but do not apply to another note with the same
+	// title.
+	shares      map[string]Role
+	links       []ShareLink
+	attachments []Attachment
+}
+
+// DeleteNote moves a note of user to their trash. A note with the same title
+// already in the trash is replaced, and the attachments it had are returned
+// so that the caller deletes their content.
+func (s *DB) DeleteNote(user, title string) ([]Attachment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ref := s.refLocked(user, title)
+	n, ok := s.openLocked(user, ref)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	t := trashedNote{note: s.notes[user][ref], deleted: time.Now(), shares: s.shares[user][ref]}
+	for id, l := range s.links {
+		if l.Owner == user && l.ref == ref {
+			t.links = append(t.links, l)
+			delete(s.links, id)
+		}
+	}
+	t.attachments = s.attachmentsLocked(user, ref)
+	for _, a := range t.attachments {
+		delete(s.attachments, a.ID)
+	}
+	delete(s.shares[user], ref)
+	delete(s.notes[user], ref)
+	if s.trash[user] == nil {
+		s.trash[user] = map[string]trashedNote{}
+	}
+	replaced := s.trash[user][ref].attachments
+	s.trash[user][ref] = t
+	n.Deleted = true
+	s.events.publish(user, n)
+	return replaced, nil
+}
+
+// RestoreNote moves a note of user out of their trash. It goes back to its
+// notebook, or to the top level if the notebook was deleted meanwhile.
+func (s *DB) RestoreNote(user, title string) (Note, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ref := s.refLocked(user, title)
+	t, ok := s.trash[user][ref]
+	if !ok {
+		return Note{}, ErrNotFound
+	}
+	if _, ok := s.notes[user][ref]; ok {
+		return Note{}, ErrTitleTaken
+	}
+	sn := t.note
+	if _, ok := s.notebooks[user][sn.Notebook]; !ok {
+		sn.Notebook = ""
+	}
+	// Clients that saw the note before it was deleted must not overwrite it.
+	sn.Version++
+	n, ok := s.openNoteLocked(user, ref, sn)
+	if !ok {
+		return Note{}, ErrNotFound
+	}
+	delete(s.trash[user], ref)
+	if s.notes[user] == nil {
+		s.notes[user] = map[string]sealedNote{}
+	}
+	s.notes[user][ref] = sn
+	if t.shares != nil {
+		if s.shares[user] == nil {
+			s.shares[user] = map[string]map[string]Role{}
+		}
+		s.shares[user][ref] = t.shares
+	}
+	for _, l := range t.links {
+		s.links[l.ID] = l
+	}
+	for _, a := range t.attachments {
+		s.attachments[a.ID] = a
+	}
+	s.events.publish(user, n)
+	return n, nil
+}
+
+// GetTrash returns the notes in the trash of user, the most recently deleted
+// first.
+func (s *DB) GetTrash(user string) []TrashedNote {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ts []TrashedNote
+	for ref, t := range s.trash[user] {
+		if n, ok := s.openNoteLocked(user, ref, t.note); ok {
+			ts = append(ts, TrashedNote{Note: n, Deleted: t.deleted})
+		}
+	}
+	sort.Slice(ts, func(i, j int) bool { return ts[i].Deleted.After(ts[j].Deleted) })
+	return ts
+}
+
+// The functions below permanently delete notes in the trash. They return the
+// attachments of the notes, so that the caller deletes their content.
+
+// PurgeNote permanently deletes a note in the trash of user.
+func (s *DB) PurgeNote(user, title string) ([]Attachment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ref := s.refLocked(user, title)
+	t, ok := s.trash[user][ref]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	delete(s.trash[user], ref)
+	return t.attachments, nil
+}
+
+// EmptyTrash permanently deletes all the notes in the trash of user.
+func (s *DB) EmptyTrash(user string) []Attachment {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var as []Attachment
+	for _, t := range s.trash[user] {
+		as = append(as, t.attachments...)
+	}
+	delete(s.trash, user)
+	return as
+}
+
+// PurgeTrash permanently deletes the notes of all users that were deleted
+// before a time. It returns the number of notes deleted.
+func (s *DB) PurgeTrash(before time.Time) (int, []Attachment) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	purged := 0
+	var as []Attachment
+	for user, ts := range s.trash {
+		for ref, t := range ts {
+			if t.deleted.Before(before) {
+				delete(ts, ref)
+				as = append(as, t.attachments...)
+				purged++
+			}
+		}
+		if len(ts) == 0 {
+			delete(s.trash, user)
+		}
+	}
+	return purged, as
+}